@@ -0,0 +1,109 @@
+package architecture
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker-library/bashbrew/pkg/stripper"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"pault.ag/go/debian/control"
+)
+
+var validNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// Register adds a new architecture to SupportedArches (which is then valid in "Architectures", "--arch", etc), normalizing the platform first (see Normalize); redefining an existing architecture is only allowed if the platform is the same, and two architectures cannot share the same platform (they would be indistinguishable)
+func Register(name string, p OCIPlatform) error {
+	if !validNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid architecture name %q", name)
+	}
+	if p.OS == "" || p.Architecture == "" {
+		return fmt.Errorf("architecture %q: missing OS or Architecture (%q)", name, p.String())
+	}
+	p = OCIPlatform(Normalize(ocispec.Platform(p)))
+	for existingName, existing := range SupportedArches {
+		if !reflect.DeepEqual(existing, p) {
+			if existingName == name {
+				return fmt.Errorf("architecture %q is already defined as %q (not %q)", name, existing.String(), p.String())
+			}
+			continue
+		}
+		if existingName != name {
+			return fmt.Errorf("architecture %q has the same platform (%q) as %q", name, p.String(), existingName)
+		}
+	}
+	SupportedArches[name] = p
+	return nil
+}
+
+// ParsePlatform parses a platform string ala "linux/amd64/v3" or "windows/arm64" (OS, Architecture, and optionally Variant)
+func ParsePlatform(s string) (OCIPlatform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return OCIPlatform{}, fmt.Errorf("invalid platform %q (expected \"os/arch\" or \"os/arch/variant\")", s)
+	}
+	p := OCIPlatform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+type customArchitecture struct {
+	control.Paragraph
+
+	Name         string
+	OS           string
+	Architecture string
+	Variant      string
+	OSVersion    string
+	OSFeatures   []string `delim:"," strip:"\n\r\t "`
+}
+
+// ParseCustom parses definitions of additional architectures (in the same RFC 2822 format as manifests; see Register for actually adding them), one per paragraph:
+//
+//	Name: amd64v3
+//	OS: linux
+//	Architecture: amd64
+//	Variant: v3
+//
+//	Name: windows-arm64
+//	OS: windows
+//	Architecture: arm64
+//	OSFeatures: win32k
+func ParseCustom(r io.Reader) (map[string]OCIPlatform, error) {
+	decoder, err := control.NewDecoder(bufio.NewReader(stripper.NewCommentStripper(r)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string]OCIPlatform{}
+	for {
+		custom := customArchitecture{}
+		if err := decoder.Decode(&custom); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if custom.Name == "" {
+			return nil, fmt.Errorf("architecture definition missing Name (%q)", custom.Paragraph.Order)
+		}
+		if _, ok := ret[custom.Name]; ok {
+			return nil, fmt.Errorf("architecture %q defined more than once", custom.Name)
+		}
+		ret[custom.Name] = OCIPlatform{
+			OS:           custom.OS,
+			Architecture: custom.Architecture,
+			Variant:      custom.Variant,
+			OSVersion:    custom.OSVersion,
+			OSFeatures:   custom.OSFeatures,
+		}
+	}
+	return ret, nil
+}
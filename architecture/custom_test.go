@@ -0,0 +1,107 @@
+package architecture_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker-library/bashbrew/architecture"
+)
+
+func TestRegister(t *testing.T) {
+	tests := map[string]struct {
+		platform architecture.OCIPlatform
+		expected string
+	}{
+		"loong64":       {architecture.OCIPlatform{OS: "linux", Architecture: "loong64"}, "linux/loong64"},
+		"amd64v3":       {architecture.OCIPlatform{OS: "linux", Architecture: "x86_64", Variant: "v3"}, "linux/amd64/v3"},
+		"windows-arm64": {architecture.OCIPlatform{OS: "windows", Architecture: "aarch64"}, "windows/arm64/v8"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer delete(architecture.SupportedArches, name)
+			if err := architecture.Register(name, test.platform); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := architecture.SupportedArches[name].String(); got != test.expected {
+				t.Errorf("expected %q; got %q", test.expected, got)
+			}
+			// registering the exact same thing again is fine
+			if err := architecture.Register(name, test.platform); err != nil {
+				t.Errorf("unexpected error re-registering: %v", err)
+			}
+		})
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	tests := map[string]architecture.OCIPlatform{
+		"amd64":       {OS: "linux", Architecture: "arm64"},                // redefining a builtin
+		"amd64v1":     {OS: "linux", Architecture: "amd64", Variant: "v1"}, // same platform as "amd64" (after normalizing)
+		"my-arm64":    {OS: "linux", Architecture: "arm64", Variant: "v8"}, // same platform as "arm64v8"
+		"no-os":       {Architecture: "amd64"},
+		"Not A Name!": {OS: "linux", Architecture: "riscv32"},
+	}
+	for name, platform := range tests {
+		t.Run(name, func(t *testing.T) {
+			before := architecture.SupportedArches[name]
+			if err := architecture.Register(name, platform); err == nil {
+				t.Errorf("expected error, got none (%q)", architecture.SupportedArches[name].String())
+			}
+			if after := architecture.SupportedArches[name]; !reflect.DeepEqual(before, after) {
+				t.Errorf("SupportedArches changed despite error: %q => %q", before.String(), after.String())
+			}
+		})
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	for _, s := range []string{"linux/amd64", "linux/arm/v7", "windows/amd64"} {
+		p, err := architecture.ParsePlatform(s)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		} else if got := p.String(); got != s {
+			t.Errorf("%q: got %q", s, got)
+		}
+	}
+	for _, s := range []string{"", "linux", "linux/", "/amd64", "linux/arm/v7/extra"} {
+		if p, err := architecture.ParsePlatform(s); err == nil {
+			t.Errorf("%q: expected error, got %q", s, p.String())
+		}
+	}
+}
+
+func TestParseCustom(t *testing.T) {
+	custom, err := architecture.ParseCustom(strings.NewReader(`
+# comments are fine
+Name: amd64v3
+OS: linux
+Architecture: amd64
+Variant: v3
+
+Name: windows-ltsc2022-amd64
+OS: windows
+Architecture: amd64
+OSVersion: 10.0.20348.2227
+OSFeatures: win32k, foo
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]architecture.OCIPlatform{
+		"amd64v3":                {OS: "linux", Architecture: "amd64", Variant: "v3"},
+		"windows-ltsc2022-amd64": {OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.2227", OSFeatures: []string{"win32k", "foo"}},
+	}
+	if !reflect.DeepEqual(custom, expected) {
+		t.Errorf("expected %#v; got %#v", expected, custom)
+	}
+
+	for _, invalid := range []string{
+		"OS: linux\nArchitecture: amd64\n",
+		"Name: foo\nOS: linux\nArchitecture: amd64\n\nName: foo\nOS: linux\nArchitecture: arm64\n",
+	} {
+		if _, err := architecture.ParseCustom(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
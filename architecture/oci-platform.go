@@ -2,6 +2,7 @@ package architecture
 
 import (
 	"path"
+	"slices"
 
 	"github.com/containerd/containerd/platforms"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -47,5 +48,30 @@ func Normalize(p ocispec.Platform) ocispec.Platform {
 
 func (p OCIPlatform) Is(q OCIPlatform) bool {
 	// (assumes "p" and "q" are both already bashbrew normalized, like one of the SupportedArches above)
-	return p.OS == q.OS && p.Architecture == q.Architecture && p.Variant == q.Variant
+	if p.OS != q.OS || p.Architecture != q.Architecture || p.Variant != q.Variant {
+		return false
+	}
+	// "os.version" only matters if both sides have one ("windows-amd64" is any version of Windows, but "10.0.17763.xxxx" is not "10.0.20348.yyyy"), and then only "major.minor.build" (the revision changes with every monthly update, but images are compatible with any revision of the same build); see MatchingArches for picking between several matching architectures
+	return p.OSVersion == "" || q.OSVersion == "" || osVersionBuild(p.OSVersion) == osVersionBuild(q.OSVersion)
+}
+
+// MatchingArches returns the (sorted) names of the SupportedArches which match the given platform (see OCIPlatform.Is), preferring the most specific: since an empty "os.version" matches any version, an image with an "os.version" is claimed by an architecture with a matching one (ala a custom "windows-ltsc2022-amd64") over a version-less one like "windows-amd64" (and vice versa for an image without one), so only falling back to the other if there is nothing better
+func MatchingArches(q OCIPlatform) []string {
+	exact, fallback := []string{}, []string{}
+	for arch, p := range SupportedArches {
+		if !p.Is(q) {
+			continue
+		}
+		if (p.OSVersion != "") == (q.OSVersion != "") {
+			exact = append(exact, arch)
+		} else {
+			fallback = append(fallback, arch)
+		}
+	}
+	ret := exact
+	if len(ret) == 0 {
+		ret = fallback
+	}
+	slices.Sort(ret)
+	return ret
 }
@@ -1,6 +1,7 @@
 package architecture_test
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/docker-library/bashbrew/architecture"
@@ -33,6 +34,7 @@ func TestIs(t *testing.T) {
 			{architecture.SupportedArches["arm32v7"], architecture.SupportedArches["arm32v7"]},
 			{architecture.SupportedArches["arm64v8"], architecture.OCIPlatform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
 			{architecture.SupportedArches["windows-amd64"], architecture.OCIPlatform{OS: "windows", Architecture: "amd64", OSVersion: "1.2.3.4"}},
+			{architecture.OCIPlatform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348"}, architecture.OCIPlatform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.2227"}},
 		},
 		false: {
 			{architecture.SupportedArches["amd64"], architecture.OCIPlatform{OS: "linux", Architecture: "amd64", Variant: "v4"}},
@@ -42,6 +44,7 @@ func TestIs(t *testing.T) {
 			{architecture.SupportedArches["arm32v7"], architecture.SupportedArches["arm32v6"]},
 			{architecture.SupportedArches["arm32v7"], architecture.SupportedArches["arm64v8"]},
 			{architecture.SupportedArches["arm64v8"], architecture.OCIPlatform{OS: "linux", Architecture: "arm64", Variant: "v9"}},
+			{architecture.OCIPlatform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.5329"}, architecture.OCIPlatform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.2227"}},
 		},
 	}
 	for expected, test := range tests {
@@ -55,6 +58,31 @@ func TestIs(t *testing.T) {
 	}
 }
 
+func TestMatchingArches(t *testing.T) {
+	defer delete(architecture.SupportedArches, "windows-ltsc2022-amd64")
+	if err := architecture.Register("windows-ltsc2022-amd64", architecture.OCIPlatform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		platform architecture.OCIPlatform
+		expected []string
+	}{
+		"linux":             {architecture.SupportedArches["amd64"], []string{"amd64"}},
+		"ltsc2022":          {architecture.OCIPlatform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.2227"}, []string{"windows-ltsc2022-amd64"}},
+		"ltsc2019":          {architecture.OCIPlatform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.5329"}, []string{"windows-amd64"}},
+		"no os.version":     {architecture.OCIPlatform{OS: "windows", Architecture: "amd64"}, []string{"windows-amd64"}},
+		"nothing (riscv32)": {architecture.OCIPlatform{OS: "linux", Architecture: "riscv32"}, []string{}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := architecture.MatchingArches(test.platform); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("expected %q; got %q", test.expected, got)
+			}
+		})
+	}
+}
+
 func TestNormalize(t *testing.T) {
 	for arch, expected := range architecture.SupportedArches {
 		t.Run(arch, func(t *testing.T) {
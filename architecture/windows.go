@@ -0,0 +1,52 @@
+package architecture
+
+import (
+	"regexp"
+	"strings"
+)
+
+// WindowsVersions maps the names of Windows releases (as used in tags ala "mcr.microsoft.com/windows/servercore:ltsc2022" and "windowsservercore-ltsc2022") to their "os.version" ("major.minor.build"; actual images also include a revision, ala "10.0.20348.2227")
+var WindowsVersions = map[string]string{
+	"ltsc2016": "10.0.14393",
+	"1809":     "10.0.17763",
+	"ltsc2019": "10.0.17763",
+	"ltsc2022": "10.0.20348",
+	"ltsc2025": "10.0.26100",
+}
+
+// every Windows release that supports containers is "10.0.BUILD" (anything else that looks like a version is much more likely to be the version of whatever's in the image, ala "python:3.12.1-windowsservercore-ltsc2022")
+var osVersionRegex = regexp.MustCompile(`^10[.]0[.][0-9]+([.][0-9]+)?$`)
+
+// returns the "major.minor.build" of an "os.version" value (see "OCIPlatform.Is")
+func osVersionBuild(v string) string {
+	parts := strings.SplitN(v, ".", 4)
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	return strings.Join(parts, ".")
+}
+
+// OSVersion resolves either a Windows "os.version" value ("10.0.20348" or "10.0.20348.2227") or the name of a Windows release (see WindowsVersions) to an "os.version" value (returning false if it is neither)
+func OSVersion(s string) (string, bool) {
+	if osVersionRegex.MatchString(s) {
+		return s, true
+	}
+	v, ok := WindowsVersions[s]
+	return v, ok
+}
+
+// WindowsVersion returns the "os.version" implied by the tag of the given (Windows) image reference, either because the tag includes a version (ala "mcr.microsoft.com/windows/servercore:10.0.20348.2227-amd64") or because it includes the name of a release (ala "mcr.microsoft.com/windows/nanoserver:ltsc2022" or "eclipse-temurin:21-windowsservercore-ltsc2022"), or "" if it doesn't imply one
+func WindowsVersion(ref string) string {
+	ref, _, _ = strings.Cut(ref, "@")
+	lastSlash := strings.LastIndex(ref, "/")
+	colon := strings.LastIndex(ref, ":")
+	if colon < 0 || colon < lastSlash {
+		return ""
+	}
+	for bit := range strings.SplitSeq(ref[colon+1:], "-") {
+		if v, ok := OSVersion(bit); ok {
+			return v
+		}
+	}
+	return ""
+}
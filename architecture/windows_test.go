@@ -0,0 +1,52 @@
+package architecture_test
+
+import (
+	"testing"
+
+	"github.com/docker-library/bashbrew/architecture"
+)
+
+func TestWindowsVersion(t *testing.T) {
+	tests := map[string]string{
+		"mcr.microsoft.com/windows/servercore:ltsc2022":                     "10.0.20348",
+		"mcr.microsoft.com/windows/nanoserver:1809":                         "10.0.17763",
+		"mcr.microsoft.com/windows/servercore:10.0.20348.2227-amd64":        "10.0.20348.2227",
+		"mcr.microsoft.com/windows/servercore:10.0.20348.2227":              "10.0.20348.2227",
+		"eclipse-temurin:21-jdk-windowsservercore-ltsc2019":                 "10.0.17763",
+		"eclipse-temurin:21-jdk-windowsservercore-ltsc2022@sha256:deadbeef": "10.0.20348",
+		"eclipse-temurin:21-jdk":                                            "",
+		"mcr.microsoft.com/windows/servercore":                              "",
+		"localhost:5000/windows/servercore":                                 "",
+		"localhost:5000/windows/servercore:ltsc2025":                        "10.0.26100",
+		"python:3.12.1-windowsservercore-ltsc2022":                          "10.0.20348",
+		"python:3.12.1-windowsservercore":                                   "",
+		"golang:1.22.0-windowsservercore-1809":                              "10.0.17763",
+		"golang:1.22.0-nanoserver-ltsc2022":                                 "10.0.20348",
+	}
+	for ref, expected := range tests {
+		t.Run(ref, func(t *testing.T) {
+			if got := architecture.WindowsVersion(ref); got != expected {
+				t.Errorf("expected %q; got %q", expected, got)
+			}
+		})
+	}
+}
+
+func TestOSVersion(t *testing.T) {
+	tests := map[string]string{
+		"ltsc2022":        "10.0.20348",
+		"10.0.20348":      "10.0.20348",
+		"10.0.20348.2227": "10.0.20348.2227",
+		"ltsc2000":        "",
+		"10.0":            "",
+		"3.12.1":          "",
+		"6.3.9600":        "",
+		"":                "",
+	}
+	for s, expected := range tests {
+		got, ok := architecture.OSVersion(s)
+		if ok != (expected != "") || got != expected {
+			t.Errorf("%q: expected %q; got %q (%v)", s, expected, got, ok)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/docker-library/bashbrew/manifest"
+	"github.com/docker-library/bashbrew/registry"
+
+	"github.com/containerd/containerd/reference/docker"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// the OCI annotations we stamp on everything we build (and every index "put-shared" creates) so that images can be traced back to the library entry they came from
+var bashbrewAnnotationKeys = []string{
+	imagespec.AnnotationSource,
+	imagespec.AnnotationRevision,
+	imagespec.AnnotationVersion,
+	imagespec.AnnotationCreated,
+	imagespec.AnnotationBaseImageName,
+	imagespec.AnnotationBaseImageDigest,
+}
+
+// returns the OCI annotations describing the given entry on the given architecture (see "bashbrewAnnotationKeys"; the "base" annotations describe the FROM of the last stage, and are omitted for "FROM scratch") -- the base digest is only recorded when we know exactly what the build is going to use (the FROM is by digest or pinned via "pins"; builders on top of local Docker images add it themselves via "dockerLocalBaseDigest"), since whatever the tag resolves to in the registry is not necessarily what we built on, and claiming the wrong base is worse than claiming none ("outdated" falls back to comparing layers)
+func (r Repo) ArchAnnotations(arch string, entry *manifest.Manifest2822Entry, pins map[string]string) (map[string]string, error) {
+	commit, err := r.fetchGitRepo(arch, entry)
+	if err != nil {
+		return nil, err
+	}
+	created, err := r.ArchGitTime(arch, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := map[string]string{
+		imagespec.AnnotationSource:   entry.ArchGitRepo(arch),
+		imagespec.AnnotationRevision: commit,
+		imagespec.AnnotationVersion:  entry.Tags[0],
+		imagespec.AnnotationCreated:  created.UTC().Format(time.RFC3339),
+	}
+
+	from, err := r.ArchLastStageFrom(arch, entry)
+	if err != nil {
+		return nil, err
+	}
+	if from == "scratch" {
+		return annotations, nil
+	}
+
+	fromRef, err := docker.ParseNormalizedNamed(from)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing FROM %q: %w", from, err)
+	}
+	fromRef = docker.TagNameOnly(fromRef)
+	annotations[imagespec.AnnotationBaseImageName] = fromRef.String()
+
+	if pinned, ok := pins[from]; ok {
+		fromRef, err = docker.ParseNormalizedNamed(pinned)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing pinned FROM %q: %w", pinned, err)
+		}
+	}
+	if digested, ok := fromRef.(docker.Digested); ok {
+		annotations[imagespec.AnnotationBaseImageDigest] = digested.Digest().String()
+	}
+
+	return annotations, nil
+}
+
+// returns the registry digest of the given (local) Docker image, if it was pulled from the registry (ie, it has a "RepoDigest" for the repository it is named after), or the empty string (if it was built or tagged locally, for example)
+func dockerLocalBaseDigest(image string) (string, error) {
+	ref, err := docker.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	out, err := dockerInspect("{{json .RepoDigests}}", image)
+	if err != nil || out == "" {
+		return "", err
+	}
+	var repoDigests []string
+	if err := json.Unmarshal([]byte(out), &repoDigests); err != nil {
+		return "", fmt.Errorf("failed parsing RepoDigests of %q: %w", image, err)
+	}
+	for _, repoDigest := range repoDigests {
+		digestRef, err := docker.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if digested, ok := digestRef.(docker.Digested); ok && digestRef.Name() == ref.Name() {
+			return digested.Digest().String(), nil
+		}
+	}
+	return "", nil
+}
+
+// returns the "bashbrew annotations" of the given single-image manifest, falling back to labels of the same names in the image config (for images whose manifests were created by "docker push" rather than by us; see "dockerBuild")
+func remoteImageAnnotations(ctx context.Context, obj registry.ResolvedObject) (map[string]string, error) {
+	m, err := obj.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	source := m.Annotations
+	if _, ok := source[imagespec.AnnotationRevision]; !ok {
+		config, err := obj.At(m.Config).ConfigBlob(ctx)
+		if err != nil {
+			return nil, err
+		}
+		source = config.Config.Labels
+	}
+
+	annotations := map[string]string{}
+	for _, key := range bashbrewAnnotationKeys {
+		if val, ok := source[key]; ok {
+			annotations[key] = val
+		}
+	}
+	return annotations, nil
+}
+
+// returns the annotations for an index of the given members: only the "bashbrew annotations" every (non-attestation) image agrees on (so a SharedTags index spanning several entries will end up with fewer annotations than the index of a single entry)
+func indexAnnotations(ctx context.Context, members []registry.ResolvedObject) (map[string]string, error) {
+	var common map[string]string
+	for _, member := range members {
+		manifests, err := member.Manifests(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range manifests {
+			if isAttestationManifest(desc) {
+				continue
+			}
+			annotations, err := remoteImageAnnotations(ctx, *member.At(desc))
+			if err != nil {
+				return nil, fmt.Errorf("failed fetching annotations of %s (%s): %w", desc.Digest, member.ImageRef, err)
+			}
+			if common == nil {
+				common = annotations
+				continue
+			}
+			for key, val := range common {
+				if annotations[key] != val {
+					delete(common, key)
+				}
+			}
+		}
+	}
+	if len(common) == 0 {
+		return nil, nil
+	}
+	return common, nil
+}
+
+// whether the given descriptor (from an index) is one of BuildKit's attestation manifests rather than an actual image
+func isAttestationManifest(desc imagespec.Descriptor) bool {
+	return desc.Annotations["vnd.docker.reference.type"] == "attestation-manifest"
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/docker-library/bashbrew/architecture"
+)
+
+// registers every architecture defined in the given file (see "architecture.ParseCustom")
+func registerCustomArchesFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	custom, err := architecture.ParseCustom(f)
+	if err != nil {
+		return fmt.Errorf("failed parsing %q: %w", file, err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(custom)) {
+		if err := architecture.Register(name, custom[name]); err != nil {
+			return fmt.Errorf("failed parsing %q: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// parses a list of "arch=value" mappings (ala "--arch-namespace"), ignoring empty values ("BASHBREW_ARCH_NAMESPACES=" should be the same as the empty list)
+func parseArchMappings(flag string, mappings []string) (map[string]string, error) {
+	ret := map[string]string{}
+	for _, mapping := range mappings {
+		if mapping == "" {
+			continue
+		}
+		mappingArch, value, ok := strings.Cut(mapping, "=")
+		if !ok {
+			return nil, fmt.Errorf(`invalid --%s value %q (expected "arch=value")`, flag, mapping)
+		}
+		ret[strings.TrimSpace(mappingArch)] = strings.TrimSpace(value)
+	}
+	return ret, nil
+}
+
+// the environment variables "--arch-docker-host" and "--arch-buildkit-host" control, and their values from before we started changing them (so architectures without a mapping get the original value back)
+var archHostEnv = sync.OnceValue(func() map[string]*string {
+	ret := map[string]*string{}
+	for _, env := range []string{"DOCKER_HOST", buildkitHostEnv} {
+		if val, ok := os.LookupEnv(env); ok {
+			ret[env] = &val
+		} else {
+			ret[env] = nil
+		}
+	}
+	return ret
+})
+
+// switches the current "arch" (and everything that depends on it, like which Docker daemon / BuildKit we talk to and which containerd store we use), resetting any caches whose contents are architecture-specific (see "build --arches")
+func setArch(newArch string) error {
+	newOCIArch, ok := architecture.SupportedArches[newArch]
+	if !ok {
+		return fmt.Errorf("invalid architecture: %q", newArch)
+	}
+
+	for env, orig := range archHostEnv() {
+		hosts := archDockerHosts
+		if env == buildkitHostEnv {
+			hosts = archBuildkitHosts
+		}
+		var err error
+		if host, ok := hosts[newArch]; ok {
+			err = os.Setenv(env, host)
+		} else if orig != nil {
+			err = os.Setenv(env, *orig)
+		} else {
+			err = os.Unsetenv(env)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	pinnedFromCache = map[string]string{}
+	dockerFromIdCache = map[string]string{
+		"scratch": "scratch",
+	}
+	haveOutputSkippedMessage = map[string]bool{}
+
+	arch = newArch
+	ociArch = newOCIArch
+	return nil
+}
+
+// parses "build --arches" (which can be given multiple times and/or comma-separated), deduplicating and validating the result
+func parseArchesFlag(values []string) ([]string, error) {
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, value := range values {
+		for a := range strings.SplitSeq(value, ",") {
+			a = strings.TrimSpace(a)
+			if a == "" || seen[a] {
+				continue
+			}
+			if _, ok := architecture.SupportedArches[a]; !ok {
+				return nil, fmt.Errorf("invalid architecture: %q", a)
+			}
+			seen[a] = true
+			ret = append(ret, a)
+		}
+	}
+	return ret, nil
+}
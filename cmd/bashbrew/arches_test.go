@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/docker-library/bashbrew/architecture"
+)
+
+func TestParseArchesFlag(t *testing.T) {
+	tests := map[string]struct {
+		values   []string
+		expected []string
+	}{
+		"single":     {[]string{"amd64"}, []string{"amd64"}},
+		"comma":      {[]string{"amd64, arm64v8"}, []string{"amd64", "arm64v8"}},
+		"repeated":   {[]string{"s390x", "amd64,arm64v8"}, []string{"s390x", "amd64", "arm64v8"}},
+		"duplicates": {[]string{"amd64,amd64", "amd64"}, []string{"amd64"}},
+		"empty":      {[]string{"", ",", " , "}, []string{}},
+		"windows":    {[]string{"windows-amd64"}, []string{"windows-amd64"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseArchesFlag(test.values)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("expected %q; got %q", test.expected, got)
+			}
+		})
+	}
+
+	for _, values := range [][]string{
+		{"amd64,x86_64"},
+		{"AMD64"},
+		{"amd64", "arm64v8=foo"},
+	} {
+		if got, err := parseArchesFlag(values); err == nil {
+			t.Errorf("%q: expected error; got %q", values, got)
+		}
+	}
+}
+
+func TestSetArch(t *testing.T) {
+	origArch, origOCIArch := arch, ociArch
+	origDockerHosts, origBuildkitHosts, origHostEnv := archDockerHosts, archBuildkitHosts, archHostEnv
+	origPinned, origFromIds, origSkipped := pinnedFromCache, dockerFromIdCache, haveOutputSkippedMessage
+	defer func() {
+		arch, ociArch = origArch, origOCIArch
+		archDockerHosts, archBuildkitHosts, archHostEnv = origDockerHosts, origBuildkitHosts, origHostEnv
+		pinnedFromCache, dockerFromIdCache, haveOutputSkippedMessage = origPinned, origFromIds, origSkipped
+	}()
+
+	// DOCKER_HOST was set before we started, BUILDKIT_HOST was not (and "t.Setenv" puts both back afterwards)
+	t.Setenv("DOCKER_HOST", "unix:///original.sock")
+	t.Setenv(buildkitHostEnv, "")
+	os.Unsetenv(buildkitHostEnv)
+	origDockerHost := "unix:///original.sock"
+	archHostEnv = func() map[string]*string {
+		return map[string]*string{"DOCKER_HOST": &origDockerHost, buildkitHostEnv: nil}
+	}
+
+	archDockerHosts = map[string]string{"arm64v8": "ssh://arm64-1"}
+	archBuildkitHosts = map[string]string{"arm64v8": "tcp://arm64-1:1234", "s390x": "tcp://s390x-1:1234"}
+
+	tests := []struct {
+		arch         string
+		dockerHost   string
+		buildkitHost *string
+	}{
+		{"arm64v8", "ssh://arm64-1", ptr("tcp://arm64-1:1234")},
+		{"s390x", "unix:///original.sock", ptr("tcp://s390x-1:1234")}, // (DOCKER_HOST goes back to what it was)
+		{"amd64", "unix:///original.sock", nil},                       // (BUILDKIT_HOST goes back to being unset)
+		{"arm64v8", "ssh://arm64-1", ptr("tcp://arm64-1:1234")},
+	}
+	for _, test := range tests {
+		// stale per-architecture state from the previous architecture
+		pinnedFromCache = map[string]string{"debian:bookworm": "debian@sha256:stale"}
+		dockerFromIdCache = map[string]string{"scratch": "scratch", "debian:bookworm": "sha256:stale"}
+		haveOutputSkippedMessage = map[string]bool{"foo": true}
+
+		if err := setArch(test.arch); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.arch, err)
+		}
+		if arch != test.arch {
+			t.Errorf("%s: expected arch %q; got %q", test.arch, test.arch, arch)
+		}
+		if expected := architecture.SupportedArches[test.arch]; !reflect.DeepEqual(ociArch, expected) {
+			t.Errorf("%s: expected OCI platform %v; got %v", test.arch, expected, ociArch)
+		}
+		if got := os.Getenv("DOCKER_HOST"); got != test.dockerHost {
+			t.Errorf("%s: expected DOCKER_HOST %q; got %q", test.arch, test.dockerHost, got)
+		}
+		got, ok := os.LookupEnv(buildkitHostEnv)
+		if test.buildkitHost == nil && ok {
+			t.Errorf("%s: expected BUILDKIT_HOST to be unset; got %q", test.arch, got)
+		} else if test.buildkitHost != nil && got != *test.buildkitHost {
+			t.Errorf("%s: expected BUILDKIT_HOST %q; got %q", test.arch, *test.buildkitHost, got)
+		}
+		if len(pinnedFromCache) != 0 {
+			t.Errorf("%s: expected pinnedFromCache to be reset; got %v", test.arch, pinnedFromCache)
+		}
+		if expected := map[string]string{"scratch": "scratch"}; !reflect.DeepEqual(dockerFromIdCache, expected) {
+			t.Errorf("%s: expected dockerFromIdCache to be reset; got %v", test.arch, dockerFromIdCache)
+		}
+		if len(haveOutputSkippedMessage) != 0 {
+			t.Errorf("%s: expected haveOutputSkippedMessage to be reset; got %v", test.arch, haveOutputSkippedMessage)
+		}
+	}
+
+	// an invalid architecture changes nothing
+	pinnedFromCache = map[string]string{"debian:bookworm": "debian@sha256:abc"}
+	if err := setArch("x86_64"); err == nil {
+		t.Errorf("expected error")
+	}
+	if arch != "arm64v8" || os.Getenv("DOCKER_HOST") != "ssh://arm64-1" || len(pinnedFromCache) != 1 {
+		t.Errorf("expected an invalid architecture to change nothing; got %q, %q, %v", arch, os.Getenv("DOCKER_HOST"), pinnedFromCache)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker-library/bashbrew/manifest"
+)
+
+const buildLogTimeFormat = "20060102T150405Z"
+
+// a per-entry (and per-architecture) log file for "build --log-dir" -- every build of the entry (intermediate stages, the image itself, reproducibility rebuilds) appends its full output, with every line prefixed by a timestamp
+type buildLog struct {
+	Path string
+
+	mu        sync.Mutex
+	f         *os.File
+	lineStart bool
+}
+
+// creates a new log file in the given directory for the given entry ("<repo>_<first tag>_<arch>_<timestamp>.log")
+func newBuildLog(dir string, r Repo, entry *manifest.Manifest2822Entry) (*buildLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := strings.Join([]string{
+		strings.ReplaceAll(r.RepoName, "/", "-"),
+		entry.Tags[0],
+		arch,
+		time.Now().UTC().Format(buildLogTimeFormat),
+	}, "_") + ".log"
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &buildLog{
+		Path:      path,
+		f:         f,
+		lineStart: true,
+	}, nil
+}
+
+func (l *buildLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		if l.lineStart {
+			if _, err := io.WriteString(l.f, time.Now().UTC().Format("2006-01-02T15:04:05.000000Z07:00")+" "); err != nil {
+				return 0, err
+			}
+			l.lineStart = false
+		}
+		line := p
+		if i := slices.Index(p, '\n'); i >= 0 {
+			line = p[:i+1]
+			l.lineStart = true
+		}
+		if _, err := l.f.Write(line); err != nil {
+			return 0, err
+		}
+		p = p[len(line):]
+	}
+	return n, nil
+}
+
+// writes a single (timestamped) line to the log
+func (l *buildLog) Logf(format string, a ...any) {
+	if l == nil {
+		return
+	}
+	fmt.Fprintf(l, strings.TrimSuffix(format, "\n")+"\n", a...)
+}
+
+func (l *buildLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// returns the given error with a pointer to the log file added (if there is one), for failure reports
+func (l *buildLog) WrapError(err error) error {
+	if l == nil || err == nil {
+		return err
+	}
+	l.Logf("error: %v", err)
+	return fmt.Errorf("%w (full log: %s)", err, l.Path)
+}
+
+// returns a writer that writes to both "w" and "log" (or just "w" if "log" is nil)
+func teeBuildLog(w io.Writer, log io.Writer) io.Writer {
+	if log == nil {
+		return w
+	}
+	return io.MultiWriter(w, log)
+}
+
+// removes old logs from the given directory: anything older than "maxAge" (if non-zero), and anything but the newest "keep" logs of each entry/architecture (if non-zero)
+func pruneBuildLogs(dir string, keep int, maxAge time.Duration) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	groups := map[string][]string{} // "<repo>_<tag>_<arch>" => names (sorted oldest to newest, thanks to the timestamp format)
+	for _, dirEntry := range entries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		i := strings.LastIndex(name, "_")
+		if i < 0 {
+			continue
+		}
+		if _, err := time.Parse(buildLogTimeFormat, strings.TrimSuffix(name[i+1:], ".log")); err != nil {
+			// not one of ours
+			continue
+		}
+
+		if maxAge > 0 {
+			info, err := dirEntry.Info()
+			if err != nil {
+				return err
+			}
+			if now.Sub(info.ModTime()) > maxAge {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		groups[name[:i]] = append(groups[name[:i]], name)
+	}
+
+	if keep > 0 {
+		for _, names := range groups {
+			if len(names) <= keep {
+				continue
+			}
+			for _, name := range names[:len(names)-keep] {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
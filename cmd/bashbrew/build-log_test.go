@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPruneBuildLogs(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	// name => age (the timestamps in the names are deliberately not what the mtimes say, since only the mtime counts for age, and only the name counts for order)
+	files := map[string]time.Duration{
+		"python_3.12_amd64_20240101T000000Z.log":          10 * day,
+		"python_3.12_amd64_20240102T000000Z.log":          5 * day,
+		"python_3.12_amd64_20240103T000000Z.log":          1 * day,
+		"python_3.12_arm64v8_20240101T000000Z.log":        10 * day,
+		"docker-library-foo_1_amd64_20240101T000000Z.log": 1 * day,
+
+		// not ours (and thus never touched, no matter how old)
+		"notes.log":                              30 * day,
+		"python_3.12_amd64_yesterday.log":        30 * day,
+		"python_3.12_amd64_20240101T000000Z":     30 * day,
+		"python_3.12_amd64_20240101T000000Z.txt": 30 * day,
+	}
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		for name, age := range files {
+			file := filepath.Join(dir, name)
+			if err := os.WriteFile(file, []byte("hello\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(file, now.Add(-age), now.Add(-age)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// a directory that looks like a log
+		if err := os.Mkdir(filepath.Join(dir, "python_3.12_amd64_20230101T000000Z.log"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	notOurs := []string{
+		"notes.log",
+		"python_3.12_amd64_20230101T000000Z.log",
+		"python_3.12_amd64_20240101T000000Z",
+		"python_3.12_amd64_20240101T000000Z.txt",
+		"python_3.12_amd64_yesterday.log",
+	}
+
+	tests := map[string]struct {
+		keep     int
+		maxAge   time.Duration
+		expected []string
+	}{
+		"nothing": {0, 0, []string{
+			"docker-library-foo_1_amd64_20240101T000000Z.log",
+			"python_3.12_amd64_20240101T000000Z.log",
+			"python_3.12_amd64_20240102T000000Z.log",
+			"python_3.12_amd64_20240103T000000Z.log",
+			"python_3.12_arm64v8_20240101T000000Z.log",
+		}},
+		"count only": {1, 0, []string{
+			"docker-library-foo_1_amd64_20240101T000000Z.log",
+			"python_3.12_amd64_20240103T000000Z.log",
+			"python_3.12_arm64v8_20240101T000000Z.log", // (each architecture is separate)
+		}},
+		"age only": {0, 7 * day, []string{
+			"docker-library-foo_1_amd64_20240101T000000Z.log",
+			"python_3.12_amd64_20240102T000000Z.log",
+			"python_3.12_amd64_20240103T000000Z.log",
+		}},
+		"both": {1, 3 * day, []string{
+			"docker-library-foo_1_amd64_20240101T000000Z.log",
+			"python_3.12_amd64_20240103T000000Z.log",
+		}},
+		"both (count wins)": {2, 30 * day, []string{
+			"docker-library-foo_1_amd64_20240101T000000Z.log",
+			"python_3.12_amd64_20240102T000000Z.log",
+			"python_3.12_amd64_20240103T000000Z.log",
+			"python_3.12_arm64v8_20240101T000000Z.log",
+		}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := setup(t)
+			if err := pruneBuildLogs(dir, test.keep, test.maxAge); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			expected := append(slices.Clone(notOurs), test.expected...)
+			slices.Sort(expected)
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %q; got %q", expected, got)
+			}
+		})
+	}
+
+	t.Run("missing directory", func(t *testing.T) {
+		if err := pruneBuildLogs(filepath.Join(t.TempDir(), "nope"), 1, day); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/docker-library/bashbrew/manifest"
+
+	"github.com/containerd/containerd/reference/docker"
+	bkclient "github.com/moby/buildkit/client"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// a build endpoint in the "--builder-pool" for a given architecture
+type poolWorker struct {
+	Arch string
+
+	// "buildkit" (a buildkitd address, ala "tcp://host:1234" -- anything "buildctl --addr" accepts) or "docker" (a DOCKER_HOST, ala "ssh://user@host")
+	Kind string
+	Host string
+
+	// for "buildkit" workers, from "?ca=...&cert=...&key=...&server-name=..." on the endpoint (see "bkclient.WithCredentials")
+	ClientOpts []bkclient.ClientOpt
+
+	// the result of the last health check ("nil" if it hasn't been checked yet; see "available")
+	health *error
+
+	builds int
+}
+
+func (w poolWorker) String() string {
+	return w.Kind + ":" + w.Host
+}
+
+// parses a single "--builder-pool" endpoint: "ssh://..." and "docker+SCHEME://..." are Docker hosts, and everything else (optionally prefixed with "buildkit+") is a buildkitd address
+func parsePoolWorker(workerArch, endpoint string) (*poolWorker, error) {
+	w := &poolWorker{Arch: workerArch}
+	switch {
+	case strings.HasPrefix(endpoint, "ssh://"):
+		w.Kind, w.Host = "docker", endpoint
+	case strings.HasPrefix(endpoint, "docker+"):
+		w.Kind, w.Host = "docker", strings.TrimPrefix(endpoint, "docker+")
+	default:
+		w.Kind, w.Host = "buildkit", strings.TrimPrefix(endpoint, "buildkit+")
+	}
+	if !strings.Contains(w.Host, "://") {
+		return nil, fmt.Errorf("invalid endpoint %q for %q (expected a URL, ala \"tcp://host:1234\" or \"ssh://user@host\")", endpoint, workerArch)
+	}
+
+	if w.Kind == "buildkit" {
+		if host, rawQuery, ok := strings.Cut(w.Host, "?"); ok {
+			query, err := url.ParseQuery(rawQuery)
+			if err != nil {
+				return nil, fmt.Errorf("invalid endpoint %q for %q: %w", endpoint, workerArch, err)
+			}
+			if query.Get("ca") == "" {
+				return nil, fmt.Errorf("invalid endpoint %q for %q: TLS options require \"ca\"", endpoint, workerArch)
+			}
+			w.Host = host
+			w.ClientOpts = append(w.ClientOpts, bkclient.WithCredentials(query.Get("server-name"), query.Get("ca"), query.Get("cert"), query.Get("key")))
+		}
+	}
+
+	return w, nil
+}
+
+// parses "--builder-pool" ("arch=endpoint", where each architecture can be listed any number of times)
+func parseBuilderPool(mappings []string) (map[string][]*poolWorker, error) {
+	pool := map[string][]*poolWorker{}
+	for _, mapping := range mappings {
+		if mapping == "" {
+			// "BASHBREW_BUILDER_POOL=" (should be the same as the empty list)
+			continue
+		}
+		workerArch, endpoint, ok := strings.Cut(mapping, "=")
+		if !ok {
+			return nil, fmt.Errorf(`invalid --builder-pool value %q (expected "arch=endpoint")`, mapping)
+		}
+		w, err := parsePoolWorker(strings.TrimSpace(workerArch), strings.TrimSpace(endpoint))
+		if err != nil {
+			return nil, err
+		}
+		pool[w.Arch] = append(pool[w.Arch], w)
+	}
+	return pool, nil
+}
+
+// runs the given command against the given DOCKER_HOST
+func poolDockerCommand(ctx context.Context, host string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = append(os.Environ(), "DOCKER_HOST="+host)
+	return cmd
+}
+
+// whether the worker is reachable (checked once per run, unless "recheck")
+func (w *poolWorker) available(recheck bool) error {
+	if w.health != nil && !recheck {
+		return *w.health
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var err error
+	switch w.Kind {
+	case "buildkit":
+		var bk *bkclient.Client
+		bk, err = bkclient.New(ctx, w.Host, append([]bkclient.ClientOpt{bkclient.WithFailFast()}, w.ClientOpts...)...)
+		if err == nil {
+			_, err = bk.Info(ctx)
+			bk.Close()
+		}
+	case "docker":
+		var out []byte
+		out, err = poolDockerCommand(ctx, w.Host, "version", "--format", "{{.Server.Version}}").CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		}
+	default:
+		err = fmt.Errorf("unknown worker kind %q", w.Kind)
+	}
+	if err != nil && debugFlag {
+		fmt.Fprintf(os.Stderr, "DEBUG: worker %s is unavailable: %v\n", w, err)
+	}
+
+	w.health = &err
+	return err
+}
+
+// whether the worker can build entries with the given "Builder:" value ("classic" entries need an actual Docker daemon; "buildkit" entries can use either)
+func (w poolWorker) supports(builder string) bool {
+	switch builder {
+	case "", "classic":
+		return w.Kind == "docker"
+	case "buildkit":
+		return true
+	}
+	return false
+}
+
+// "--builder-pool" (see "parseBuilderPool"); architecture => workers
+var builderPool = map[string][]*poolWorker{}
+
+// a "Builder" that dispatches builds of the entries it wraps to the "--builder-pool" workers for the current "arch" (see "entryBuilder") and collects the results into our containerd image store (and Docker, like "buildkitBuilder")
+type poolBuilder struct {
+	base    Builder
+	builder string // the entry's "Builder:" value
+}
+
+// returns the available worker for the current "arch" (that can build with the given "Builder:" value) with the fewest builds so far in this run, skipping "exclude"
+func poolWorkerFor(builder string, exclude map[*poolWorker]bool) (*poolWorker, error) {
+	var best *poolWorker
+	var errs []string
+	for _, w := range builderPool[arch] {
+		if exclude[w] || !w.supports(builder) {
+			continue
+		}
+		if err := w.available(false); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", w, err))
+			continue
+		}
+		if best == nil || w.builds < best.builds {
+			best = w
+		}
+	}
+	if best == nil {
+		if builder == "" {
+			builder = "classic"
+		}
+		return nil, fmt.Errorf("no available workers in --builder-pool for %q that support %q builds (unavailable: %q)", arch, builder, errs)
+	}
+	return best, nil
+}
+
+func (b poolBuilder) Build(r Repo, entry *manifest.Manifest2822Entry, tags []string, opts buildOptions) error {
+	tried := map[*poolWorker]bool{}
+	for {
+		w, err := poolWorkerFor(b.builder, tried)
+		if err != nil {
+			return err
+		}
+		tried[w] = true
+		w.builds++
+
+		fmt.Printf("Dispatching %s (%s) to %s\n", tags[0], r.EntryIdentifier(entry), w)
+		switch w.Kind {
+		case "buildkit":
+			err = buildkitHostBuild(w.Host, w.ClientOpts, r, entry, tags, opts)
+		case "docker":
+			err = w.dockerBuild(r, entry, tags, opts)
+		}
+		if err == nil {
+			return nil
+		}
+
+		// if the worker went away in the middle of our build, try another one (otherwise, it's a real build failure)
+		if availErr := w.available(true); availErr == nil {
+			return fmt.Errorf("failed building on %s: %w", w, err)
+		}
+		fmt.Fprintf(os.Stderr, "warning: worker %s became unavailable while building %s; trying another (%v)\n", w, r.EntryIdentifier(entry), err)
+	}
+}
+
+// builds on a remote Docker daemon (exactly like "dockerBuilder", just with a different DOCKER_HOST), then copies the result back into our containerd image store via "docker save" (and into Docker via "containerdDockerLoad")
+func (w *poolWorker) dockerBuild(r Repo, entry *manifest.Manifest2822Entry, tags []string, opts buildOptions) error {
+	froms, err := r.ArchDockerFroms(arch, entry)
+	if err != nil {
+		return err
+	}
+	if err := w.dockerSyncFroms(froms, opts); err != nil {
+		return fmt.Errorf("failed preparing FROMs on %s: %w", w, err)
+	}
+
+	restoreEnv, err := setenvs(map[string]string{
+		"DOCKER_HOST": w.Host,
+		// "BUILDX_BUILDER" refers to a builder of the local daemon
+		buildxBuilderEnv: "",
+	})
+	defer restoreEnv()
+	if err != nil {
+		return err
+	}
+
+	if err := (dockerBuilder{buildkit: entry.ArchBuilder(arch) == "buildkit"}).Build(r, entry, tags, opts); err != nil {
+		return err
+	}
+
+	desc, err := poolDockerSave(w.Host, tags)
+	if err != nil {
+		return fmt.Errorf("failed copying %q from %s: %w", tags[0], w, err)
+	}
+
+	restoreEnv() // back to the local daemon
+	fmt.Printf("Importing %s into Docker\n", desc.Digest)
+	phase := metrics.Entry(r, entry).Start("import")
+	return phase.End(containerdDockerLoad(*desc, tags))
+}
+
+// makes sure the worker has exactly the images the given FROMs refer to here, since that's what "buildRepos" pulled (or built) and calculated the "cache hash" against (see "dockerBuildUniqueBits"): parents built earlier in this run only exist locally, so they always get copied over, and everything else is pulled on the worker according to "--pull" (and then copied over anyway if it still doesn't match)
+func (w *poolWorker) dockerSyncFroms(froms []string, opts buildOptions) error {
+	ctx := context.Background()
+	workerId := func(ref string) string {
+		out, err := poolDockerCommand(ctx, w.Host, "inspect", "--type", "image", "--format", "{{.Id}}", ref).Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	for _, from := range froms {
+		if from == "scratch" {
+			continue
+		}
+		ref := from
+		if pinned, ok := opts.Pins[from]; ok {
+			ref = pinned
+		}
+
+		localId, ok := dockerFromIdCache[from]
+		if !ok || ref != from {
+			var err error
+			if localId, err = dockerInspect("{{.Id}}", ref); err != nil {
+				return err
+			}
+		}
+
+		pull := opts.Pull
+		if ref != from && pull == "never" {
+			// a digest can only ever be that exact image, and we can't "docker load" one (see below), so there's no harm in pulling it
+			pull = "missing"
+		}
+		if !opts.Built[from] && (pull == "always" || (pull == "missing" && workerId(ref) != localId)) {
+			fmt.Printf("Pulling %s on %s\n", ref, w)
+			if out, err := poolDockerCommand(ctx, w.Host, "pull", ref).CombinedOutput(); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed pulling %q on %s: %v\n%s\n", ref, w, err, strings.TrimSpace(string(out)))
+			}
+		}
+
+		if workerId(ref) == localId {
+			continue
+		}
+		if ref != from {
+			// "docker save" of a digest reference loads as an untagged image, which "FROM name@digest" won't find (and would try to pull again)
+			return fmt.Errorf("%q on %s does not match %s", ref, w, localId)
+		}
+		fmt.Printf("Copying %s to %s\n", from, w)
+		if err := poolDockerCopy(ctx, w.Host, from); err != nil {
+			return fmt.Errorf("failed copying %q to %s: %w", from, w, err)
+		}
+		if id := workerId(from); id != localId {
+			return fmt.Errorf("%q on %s is %q after copying (expected %q)", from, w, id, localId)
+		}
+	}
+	return nil
+}
+
+// "docker save" (from our Docker) piped straight into "docker load" on the given DOCKER_HOST
+func poolDockerCopy(ctx context.Context, host string, image string) error {
+	save := exec.CommandContext(ctx, "docker", "save", image)
+	saveStderr := &strings.Builder{}
+	save.Stderr = saveStderr
+	pipe, err := save.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	load := poolDockerCommand(ctx, host, "load", "--quiet")
+	load.Stdin = pipe
+	loadOut := &strings.Builder{}
+	load.Stdout = loadOut
+	load.Stderr = loadOut
+
+	if err := save.Start(); err != nil {
+		return err
+	}
+	defer save.Process.Kill()
+	if err := load.Run(); err != nil {
+		return fmt.Errorf("docker load: %w: %s", err, strings.TrimSpace(loadOut.String()))
+	}
+	if err := save.Wait(); err != nil {
+		return fmt.Errorf("docker save: %w: %s", err, strings.TrimSpace(saveStderr.String()))
+	}
+	return nil
+}
+
+// sets the given environment variables, returning a function that puts them all back the way they were
+func setenvs(env map[string]string) (func(), error) {
+	orig := map[string]*string{}
+	restore := func() {
+		for key, val := range orig {
+			if val != nil {
+				os.Setenv(key, *val)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+	}
+	for key, val := range env {
+		if prev, ok := os.LookupEnv(key); ok {
+			orig[key] = &prev
+		} else {
+			orig[key] = nil
+		}
+		if err := os.Setenv(key, val); err != nil {
+			return restore, err
+		}
+	}
+	return restore, nil
+}
+
+// "docker save" (from the given DOCKER_HOST) piped straight into our containerd image store, returning the descriptor of the first tag
+func poolDockerSave(host string, tags []string) (*imagespec.Descriptor, error) {
+	ref, err := docker.ParseNormalizedNamed(tags[0])
+	if err != nil {
+		return nil, err
+	}
+	want := docker.TagNameOnly(ref).String()
+
+	cmd := poolDockerCommand(context.Background(), host, append([]string{"save"}, tags...)...)
+	stderr := &strings.Builder{}
+	cmd.Stderr = stderr
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	defer cmd.Process.Kill()
+
+	imgs, err := containerdImageLoad(pipe)
+	if err != nil {
+		return nil, err
+	}
+	pipe.Close()
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	for _, img := range imgs {
+		if img.Name == want {
+			return &img.Target, nil
+		}
+	}
+	return nil, fmt.Errorf("%q not found in \"docker save\" output", want)
+}
+
+// the results of pool builds live in containerd (so we check there first), but there might also be something from a previous local build in Docker
+func (b poolBuilder) Lookup(tag string) (*builtImage, error) {
+	if img, err := containerdBuiltImageLookup(tag); img != nil || err != nil {
+		return img, err
+	}
+	return b.base.Lookup(tag)
+}
+
+func (b poolBuilder) Push(r Repo, entry *manifest.Manifest2822Entry, img *builtImage, tags []string, opts pushOptions) ([]string, error) {
+	return b.base.Push(r, entry, img, tags, opts)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePoolWorker(t *testing.T) {
+	tests := map[string]struct {
+		kind, host string
+		tls        bool
+	}{
+		"ssh://builder@arm64-1":                                            {"docker", "ssh://builder@arm64-1", false},
+		"docker+tcp://arm64-1:2376":                                        {"docker", "tcp://arm64-1:2376", false},
+		"tcp://arm64-1:1234":                                               {"buildkit", "tcp://arm64-1:1234", false},
+		"buildkit+tcp://arm64-1:1234":                                      {"buildkit", "tcp://arm64-1:1234", false},
+		"unix:///run/buildkit/buildkitd.sock":                              {"buildkit", "unix:///run/buildkit/buildkitd.sock", false},
+		"tcp://arm64-1:1234?ca=/ca.pem":                                    {"buildkit", "tcp://arm64-1:1234", true},
+		"tcp://arm64-1:1234?ca=/ca.pem&cert=/c&key=/k&server-name=arm64-1": {"buildkit", "tcp://arm64-1:1234", true},
+		"docker+tcp://arm64-1:2376?foo=bar":                                {"docker", "tcp://arm64-1:2376?foo=bar", false}, // (query strings only mean something for buildkitd)
+	}
+	for endpoint, expected := range tests {
+		t.Run(endpoint, func(t *testing.T) {
+			w, err := parsePoolWorker("arm64v8", endpoint)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if w.Arch != "arm64v8" || w.Kind != expected.kind || w.Host != expected.host {
+				t.Errorf("expected %s:%s; got %s (%q)", expected.kind, expected.host, w, w.Arch)
+			}
+			if tls := len(w.ClientOpts) > 0; tls != expected.tls {
+				t.Errorf("expected TLS %v; got %v", expected.tls, tls)
+			}
+		})
+	}
+
+	for _, endpoint := range []string{
+		"arm64-1:1234",                      // not a URL
+		"docker+arm64-1",                    // not a URL either
+		"tcp://arm64-1:1234?cert=/c&key=/k", // TLS without "ca"
+		"tcp://arm64-1:1234?ca=%zz",         // invalid query
+	} {
+		if w, err := parsePoolWorker("arm64v8", endpoint); err == nil {
+			t.Errorf("%q: expected error; got %s", endpoint, w)
+		}
+	}
+}
+
+func TestParseBuilderPool(t *testing.T) {
+	pool, err := parseBuilderPool([]string{
+		"arm64v8=ssh://builder@arm64-1",
+		" arm64v8 = tcp://arm64-2:1234 ",
+		"",
+		"s390x=tcp://s390x-1:1234",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"arm64v8": {"docker:ssh://builder@arm64-1", "buildkit:tcp://arm64-2:1234"},
+		"s390x":   {"buildkit:tcp://s390x-1:1234"},
+	}
+	if len(pool) != len(expected) {
+		t.Errorf("expected %d architectures; got %d", len(expected), len(pool))
+	}
+	for poolArch, workers := range expected {
+		if len(pool[poolArch]) != len(workers) {
+			t.Errorf("%s: expected %q; got %v", poolArch, workers, pool[poolArch])
+			continue
+		}
+		for i, w := range pool[poolArch] {
+			if w.String() != workers[i] || w.Arch != poolArch {
+				t.Errorf("%s: expected %q; got %s (%q)", poolArch, workers[i], w, w.Arch)
+			}
+		}
+	}
+
+	for _, mappings := range [][]string{
+		{"ssh://builder@arm64-1"},
+		{"arm64v8=arm64-1"},
+	} {
+		if pool, err := parseBuilderPool(mappings); err == nil {
+			t.Errorf("%q: expected error; got %v", mappings, pool)
+		}
+	}
+}
+
+func TestPoolWorkerFor(t *testing.T) {
+	origArch, origPool := arch, builderPool
+	defer func() { arch, builderPool = origArch, origPool }()
+	arch = "arm64v8"
+
+	// (health is preset so that nothing actually gets checked)
+	healthy := func(kind string, builds int) *poolWorker {
+		var err error
+		return &poolWorker{Arch: arch, Kind: kind, Host: "tcp://" + kind, health: &err, builds: builds}
+	}
+	down := func(kind string) *poolWorker {
+		err := errors.New("connection refused")
+		return &poolWorker{Arch: arch, Kind: kind, Host: "tcp://down", health: &err}
+	}
+
+	docker, busyDocker, buildkit, downDocker := healthy("docker", 0), healthy("docker", 5), healthy("buildkit", 1), down("docker")
+
+	tests := []struct {
+		name     string
+		workers  []*poolWorker
+		builder  string
+		exclude  []*poolWorker
+		expected *poolWorker // nil for an error
+	}{
+		{"fewest builds", []*poolWorker{busyDocker, buildkit, docker}, "buildkit", nil, docker},
+		{"classic needs docker", []*poolWorker{buildkit, busyDocker}, "", nil, busyDocker},
+		{"classic without docker", []*poolWorker{buildkit}, "classic", nil, nil},
+		{"skips unavailable", []*poolWorker{downDocker, busyDocker}, "", nil, busyDocker},
+		{"nothing available", []*poolWorker{downDocker}, "buildkit", nil, nil},
+		{"unknown builder", []*poolWorker{docker, buildkit}, "oci-import", nil, nil},
+		{"retry skips tried", []*poolWorker{docker, busyDocker, buildkit}, "buildkit", []*poolWorker{docker}, buildkit},
+		{"retry until exhausted", []*poolWorker{docker, buildkit}, "buildkit", []*poolWorker{docker, buildkit}, nil},
+		{"other architecture", nil, "buildkit", nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builderPool = map[string][]*poolWorker{arch: test.workers}
+			exclude := map[*poolWorker]bool{}
+			for _, w := range test.exclude {
+				exclude[w] = true
+			}
+			w, err := poolWorkerFor(test.builder, exclude)
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("expected error; got %s", w)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if w != test.expected {
+				t.Errorf("expected %s (%d builds); got %s (%d builds)", test.expected, test.expected.builds, w, w.builds)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/docker-library/bashbrew/manifest"
+
+	"github.com/containerd/containerd/errdefs"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Builder is the implementation of a "Builder:" value (see "registerBuilder"); "cmdBuild", "cmdPush", etc only ever talk to builders through this interface, so adding a new one doesn't require touching every command
+type Builder interface {
+	// builds the given entry (for the current "arch"), tagging the result with every one of "tags" (the first of which is always the "cache tag"; see "Repo.DockerCacheName") and making it available to Docker under all but the first
+	Build(r Repo, entry *manifest.Manifest2822Entry, tags []string, opts buildOptions) error
+
+	// looks up a previous build via the given tag (usually the "cache tag"), returning nil (and no error) if there isn't one
+	Lookup(tag string) (*builtImage, error)
+
+	// pushes a previous build (from "Lookup") to the given tags, returning the tags that were actually pushed (skipping any that already seem up-to-date); if "Lookup" found nothing, "img" has neither "Desc" nor "ID" (see "pushRepos"), and builders whose results live in Docker push whatever Docker has under each of "tags" (so "bashbrew tag" or "docker pull" followed by "bashbrew push" keeps working)
+	Push(r Repo, entry *manifest.Manifest2822Entry, img *builtImage, tags []string, opts pushOptions) ([]string, error)
+}
+
+type buildOptions struct {
+	// whether the entry is "FROM scratch" (and thus needs an explicit platform, since there's no base image to infer it from)
+	FromScratch bool
+
+	// whether to ignore any builder cache (for verifying reproducibility, for example)
+	NoCache bool
+
+	// the (intermediate) stage to build instead of the final image (see "Repo.ArchIntermediateStages"); builders without a notion of stages ignore this
+	Target string
+
+	// where to (also) write the full build output, if anywhere (see "build --log-dir")
+	Log io.Writer
+
+	// FROM value => the exact reference to build against instead (see "build --pin-froms" and "pinFrom"); builders without a Dockerfile ignore this
+	Pins map[string]string
+
+	// tags built (or tagged) earlier in this run, which only exist locally (see "buildRepos"); builders that don't build against our Docker daemon need to be handed these explicitly (see "poolWorker.dockerSyncFroms" and "buildkitHostBuild")
+	Built map[string]bool
+
+	// the "--pull" policy, for builders that don't build against our Docker daemon (which "buildRepos" already pulled into); see "poolWorker.dockerSyncFroms"
+	Pull string
+}
+
+type pushOptions struct {
+	DryRun bool
+
+	// skip the checks against the registry for whether a push is necessary (where they're expensive; see "--force")
+	Force bool
+}
+
+// a built image, which lives either in containerd's image store ("Desc") or in Docker ("ID"), depending on the builder
+type builtImage struct {
+	Tag  string
+	Desc *imagespec.Descriptor
+	ID   string
+}
+
+var builders = map[string]Builder{}
+
+// registers a "Builder:" implementation (intended to be called from "init" functions)
+func registerBuilder(name string, builder Builder) {
+	if _, ok := builders[name]; ok {
+		panic(fmt.Sprintf("builder %q registered twice", name))
+	}
+	builders[name] = builder
+}
+
+// returns the registered implementation of the given "Builder:" value
+func lookupBuilder(name string) (Builder, error) {
+	builder, ok := builders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown builder %q (known builders: %q)", name, knownBuilders())
+	}
+	return builder, nil
+}
+
+// returns the names of every registered builder (sorted; "" is the default builder, and thus not listed)
+func knownBuilders() []string {
+	names := []string{}
+	for name := range builders {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// returns the builder for the given entry on the current architecture (dispatching to the "--builder-pool", if there is one for this architecture)
+func entryBuilder(entry *manifest.Manifest2822Entry) (Builder, error) {
+	name := entry.ArchBuilder(arch)
+	builder, err := lookupBuilder(name)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "", "classic", "buildkit":
+		if len(builderPool[arch]) > 0 {
+			return poolBuilder{base: builder, builder: name}, nil
+		}
+	}
+	return builder, nil
+}
+
+// returns the digests that identify the contents of the image for comparing builds: the image manifest digests if it's in containerd (ignoring attestations, which record build timestamps by design), and the image ID otherwise
+func (img builtImage) Digests() ([]string, error) {
+	if img.Desc != nil {
+		return containerdImageManifestDigests(*img.Desc)
+	}
+	return []string{img.ID}, nil
+}
+
+// (re-)tags the image in Docker under every one of "tags"
+func (img builtImage) DockerTag(tags []string) error {
+	if img.Desc != nil {
+		fmt.Printf("Importing %s into Docker\n", img.Desc.Digest)
+		return containerdDockerLoad(*img.Desc, tags)
+	}
+	tags = slices.DeleteFunc(slices.Clone(tags), func(tag string) bool { return tag == img.Tag })
+	// https://github.com/docker-library/bashbrew/pull/61#discussion_r1044926620
+	// abusing "docker build" for "tag something a lot of times, but efficiently" 👀
+	return dockerBuild(tags, "", strings.NewReader("FROM "+img.Tag), dockerBuildOptions{})
+}
+
+// removes the given tag from everywhere a builder might have put it (containerd's image store and Docker; see "Builder.Build"), for throwaway builds like the ones "build --verify-reproducible" does
+func removeBuiltImageTag(tag string) error {
+	if err := containerdImageDelete(tag); err != nil {
+		return fmt.Errorf("failed deleting %q from containerd: %w", tag, err)
+	}
+	if img, err := dockerBuiltImageLookup(tag); err != nil {
+		return err
+	} else if img != nil {
+		if err := dockerRmi(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// looks up the given tag in containerd's image store
+func containerdBuiltImageLookup(tag string) (*builtImage, error) {
+	desc, err := containerdImageLookup(tag)
+	if errdefs.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed looking up %q in containerd: %w", tag, err)
+	}
+	if debugFlag {
+		fmt.Printf("Found %s (via %q) in containerd image store\n", desc.Digest, tag)
+	}
+	return &builtImage{Tag: tag, Desc: desc}, nil
+}
+
+// looks up the given tag in Docker
+func dockerBuiltImageLookup(tag string) (*builtImage, error) {
+	id, err := dockerInspect("{{.Id}}", tag)
+	if errdefs.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &builtImage{Tag: tag, ID: id}, nil
+}
+
+// pushes an image from containerd's image store to every one of "tags" that isn't already up-to-date
+func containerdBuiltImagePush(r Repo, entry *manifest.Manifest2822Entry, img *builtImage, tags []string, opts pushOptions) ([]string, error) {
+	if img.Desc == nil {
+		return nil, fmt.Errorf("%q is not in the containerd image store -- has it been built?", img.Tag)
+	}
+	desc := *img.Desc
+
+	skip, update, err := containerdPushFilter(desc, tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed looking up tags: %w", err)
+	}
+	if len(skip) > 0 && len(update) == 0 {
+		fmt.Fprintf(os.Stderr, "skipping %s (remote tags all up-to-date)\n", r.EntryIdentifier(entry))
+		return nil, nil
+	} else if len(skip) > 0 {
+		fmt.Fprintf(os.Stderr, "partially skipping %s (remote tags up-to-date: %s)\n", r.EntryIdentifier(entry), strings.Join(skip, ", "))
+	}
+	fmt.Printf("Pushing %s to %s\n", desc.Digest, strings.Join(update, ", "))
+	if !opts.DryRun {
+		n, err := containerdPush(desc, update)
+		metrics.Entry(r, entry).Current().AddBytes(n) // see "cmdPush"
+		if err != nil {
+			return nil, err
+		}
+	}
+	return update, nil
+}
+
+// pushes an image from Docker to every one of "tags" that isn't already up-to-date (via "docker push", so the image needs to be tagged appropriately in Docker already)
+func dockerBuiltImagePush(r Repo, entry *manifest.Manifest2822Entry, img *builtImage, tags []string, opts pushOptions) ([]string, error) {
+	pushed := []string{}
+TagsLoop:
+	for _, tag := range tags {
+		if !opts.Force {
+			localImageId, err := dockerInspect("{{.Id}}", tag)
+			if err != nil {
+				return pushed, fmt.Errorf("failed looking up local image ID for %q: %w", tag, err)
+			}
+			if debugFlag {
+				fmt.Printf("DEBUG: docker inspect %q -> %q\n", tag, localImageId)
+			}
+			if localImageId == "" {
+				return pushed, fmt.Errorf("local image for %q does not seem to exist (or has an empty ID somehow)", tag)
+			}
+			registryImageIds := fetchRegistryImageIds(tag)
+			if debugFlag {
+				fmt.Printf("DEBUG: registry inspect %q -> %+v\n", tag, registryImageIds)
+			}
+			for _, registryImageId := range registryImageIds {
+				if localImageId == registryImageId {
+					fmt.Fprintf(os.Stderr, "skipping %s (remote image matches local)\n", tag)
+					continue TagsLoop
+				}
+			}
+		}
+		fmt.Printf("Pushing %s\n", tag)
+		if !opts.DryRun {
+			out, err := dockerPush(tag)
+			if err != nil {
+				return pushed, fmt.Errorf("failed pushing %q: %w", tag, err)
+			}
+			if phase := metrics.Entry(r, entry).Current(); phase != nil {
+				// see "cmdPush" (and "containerdBuiltImagePush"); this costs a few registry requests, so we only bother when someone's going to look at the result
+				n, err := dockerPushedBytes(tag, out)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed counting bytes pushed for %q: %v\n", tag, err)
+				}
+				phase.AddBytes(n)
+			}
+		}
+		pushed = append(pushed, tag)
+	}
+	return pushed, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker-library/bashbrew/manifest"
+)
+
+func TestLookupBuilder(t *testing.T) {
+	if expected := []string{"buildkit", "classic", "oci-import"}; !reflect.DeepEqual(knownBuilders(), expected) {
+		t.Errorf("expected %q; got %q", expected, knownBuilders())
+	}
+
+	for name, expected := range map[string]Builder{
+		"":           dockerBuilder{},
+		"classic":    dockerBuilder{},
+		"buildkit":   buildkitBuilder{dockerBuilder{buildkit: true}},
+		"oci-import": ociImportBuilder{},
+	} {
+		builder, err := lookupBuilder(name)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		} else if builder != expected {
+			t.Errorf("%q: expected %#v; got %#v", name, expected, builder)
+		}
+	}
+
+	_, err := lookupBuilder("kaniko")
+	if expected := `unknown builder "kaniko" (known builders: ["buildkit" "classic" "oci-import"])`; err == nil || err.Error() != expected {
+		t.Errorf("expected error %q; got %v", expected, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering %q twice to panic", "classic")
+		}
+	}()
+	registerBuilder("classic", dockerBuilder{})
+}
+
+func TestEntryBuilder(t *testing.T) {
+	origArch, origPool := arch, builderPool
+	defer func() { arch, builderPool = origArch, origPool }()
+	arch = "arm64v8"
+
+	man, err := manifest.Parse(strings.NewReader(`
+Maintainers: Foo (@foo)
+GitRepo: https://example.com/foo.git
+GitCommit: 0123456789abcdef0123456789abcdef01234567
+Architectures: amd64, arm64v8
+
+Tags: default
+
+Tags: classic
+Builder: classic
+
+Tags: buildkit
+Builder: buildkit
+
+Tags: oci
+Builder: oci-import
+File: index.json
+
+Tags: mixed
+Builder: buildkit
+arm64v8-Builder: classic
+
+Tags: unknown
+Builder: kaniko
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]*manifest.Manifest2822Entry{}
+	for i := range man.Entries {
+		entries[man.Entries[i].Tags[0]] = &man.Entries[i]
+	}
+
+	worker := &poolWorker{Arch: "arm64v8", Kind: "buildkit", Host: "tcp://arm64-1:1234"}
+	tests := []struct {
+		name     string
+		pool     map[string][]*poolWorker
+		tag      string
+		expected Builder // nil for an error
+	}{
+		{"no pool", nil, "default", dockerBuilder{}},
+		{"no pool (buildkit)", nil, "buildkit", buildkitBuilder{dockerBuilder{buildkit: true}}},
+		{"pool", map[string][]*poolWorker{"arm64v8": {worker}}, "default", poolBuilder{base: dockerBuilder{}, builder: ""}},
+		{"pool (classic)", map[string][]*poolWorker{"arm64v8": {worker}}, "classic", poolBuilder{base: dockerBuilder{}, builder: "classic"}},
+		{"pool (buildkit)", map[string][]*poolWorker{"arm64v8": {worker}}, "buildkit", poolBuilder{base: buildkitBuilder{dockerBuilder{buildkit: true}}, builder: "buildkit"}},
+		{"pool (per-architecture builder)", map[string][]*poolWorker{"arm64v8": {worker}}, "mixed", poolBuilder{base: dockerBuilder{}, builder: "classic"}},
+		{"pool (oci-import builds locally)", map[string][]*poolWorker{"arm64v8": {worker}}, "oci", ociImportBuilder{}},
+		{"pool for another architecture", map[string][]*poolWorker{"amd64": {worker}}, "buildkit", buildkitBuilder{dockerBuilder{buildkit: true}}},
+		{"empty pool", map[string][]*poolWorker{"arm64v8": {}}, "default", dockerBuilder{}},
+		{"unknown", nil, "unknown", nil},
+		{"unknown (pool)", map[string][]*poolWorker{"arm64v8": {worker}}, "unknown", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builderPool = test.pool
+			builder, err := entryBuilder(entries[test.tag])
+			if test.expected == nil {
+				if err == nil || !strings.Contains(err.Error(), `unknown builder "kaniko"`) {
+					t.Errorf("expected unknown builder error; got %#v, %v", builder, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if builder != test.expected {
+				t.Errorf("expected %#v; got %#v", test.expected, builder)
+			}
+		})
+	}
+}
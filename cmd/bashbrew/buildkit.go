@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing/fstest"
+
+	"github.com/docker-library/bashbrew/manifest"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/namespaces"
+	bkclient "github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	"github.com/moby/buildkit/session"
+	sessioncontent "github.com/moby/buildkit/session/content"
+	"github.com/moby/buildkit/session/filesync"
+	"github.com/moby/buildkit/util/progress/progressui"
+	"github.com/moby/patternmatcher"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/tonistiigi/fsutil"
+	fsutiltypes "github.com/tonistiigi/fsutil/types"
+	"github.com/urfave/cli"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// "Builder: buildkit" -- via the BuildKit client directly (see "buildkitClientBuild") when "BUILDKIT_HOST" is set, and via "docker buildx build" otherwise
+type buildkitBuilder struct {
+	dockerBuilder
+}
+
+func (b buildkitBuilder) Build(r Repo, entry *manifest.Manifest2822Entry, tags []string, buildOpts buildOptions) error {
+	host := os.Getenv(buildkitHostEnv)
+	if host == "" {
+		return b.dockerBuilder.Build(r, entry, tags, buildOpts)
+	}
+	return buildkitHostBuild(host, nil, r, entry, tags, buildOpts)
+}
+
+// builds the given entry (for the current "arch") on the given buildkitd (see "buildkitClientBuild") and loads the result into Docker
+func buildkitHostBuild(host string, clientOpts []bkclient.ClientOpt, r Repo, entry *manifest.Manifest2822Entry, tags []string, buildOpts buildOptions) error {
+	opts, err := r.archDockerBuildOptions(arch, entry, buildOpts)
+	if err != nil {
+		return err
+	}
+
+	contextFS, err := r.archGitFS(arch, entry)
+	if err != nil {
+		return err
+	}
+
+	// buildkitd can't see anything we built earlier in this run (it'd go to the registry and find the previous build, if anything), so we hand it our copies directly
+	froms, err := r.ArchDockerFroms(arch, entry)
+	if err != nil {
+		return err
+	}
+	for _, from := range froms {
+		if !buildOpts.Built[from] {
+			continue
+		}
+		desc, err := containerdImageLookup(from)
+		if errdefs.IsNotFound(err) {
+			// built by Docker ("Builder: classic", for example), so it needs to get into containerd first
+			desc, err = poolDockerSave(os.Getenv("DOCKER_HOST"), []string{from})
+		}
+		if err != nil {
+			return fmt.Errorf(`failed looking up FROM %q (built earlier): %w`, from, err)
+		}
+		if opts.Contexts == nil {
+			opts.Contexts = map[string]imagespec.Descriptor{}
+		}
+		opts.Contexts[from] = *desc
+	}
+
+	dockerfileFS := contextFS
+	if len(buildOpts.Pins) > 0 {
+		df, err := r.archPinnedDockerfile(arch, entry, buildOpts.Pins)
+		if err != nil {
+			return fmt.Errorf(`failed pinning FROMs: %w`, err)
+		}
+		// BuildKit only ever looks for the Dockerfile in the "dockerfile" directory, so that's the only place we need to rewrite it (".dockerignore" comes from "context")
+		dockerfileFS = fstest.MapFS{
+			path.Clean(entry.ArchFile(arch)): &fstest.MapFile{Data: []byte(df), Mode: 0644},
+		}
+	}
+
+	desc, err := buildkitClientBuild(host, tags, entry.ArchFile(arch), contextFS, dockerfileFS, opts, clientOpts...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Importing %s into Docker\n", desc.Digest)
+	phase := metrics.Entry(r, entry).Start("import")
+	return phase.End(containerdDockerLoad(*desc, tags))
+}
+
+// the name of the session content store (see "sessioncontent.NewAttachable") we serve "dockerBuildOptions.Contexts" from
+const buildkitOCIStore = "bashbrew"
+
+// the equivalent of "dockerBuildxBuild" (with "BUILDX_BUILDER"), but talking to buildkitd directly: the build context is sent straight out of Git (no "git archive" tarball) and the result is written straight into our containerd content store (no OCI tarball), tagged with all of "tags"
+func buildkitClientBuild(host string, tags []string, file string, contextFS, dockerfileFS iofs.FS, opts dockerBuildOptions, clientOpts ...bkclient.ClientOpt) (*imagespec.Descriptor, error) {
+	dockerfileSyntax, ok := os.LookupEnv(dockerfileSyntaxEnv)
+	if !ok {
+		return nil, fmt.Errorf("missing %q", dockerfileSyntaxEnv)
+	}
+
+	if file == "" {
+		file = "Dockerfile"
+	}
+	frontendAttrs := map[string]string{
+		"filename":                  file,
+		"build-arg:BUILDKIT_SYNTAX": dockerfileSyntax,
+		"attest:provenance":         "mode=max",
+	}
+	for key, val := range opts.BuildArgs {
+		frontendAttrs["build-arg:"+key] = val
+	}
+	if sbomGenerator, ok := os.LookupEnv(sbomGeneratorEnv); ok {
+		frontendAttrs["attest:sbom"] = "generator=" + sbomGenerator
+	}
+	if opts.Platform != "" {
+		frontendAttrs["platform"] = opts.Platform
+	}
+	if opts.NoCache {
+		frontendAttrs["no-cache"] = ""
+	}
+	if opts.Target != "" {
+		frontendAttrs["target"] = opts.Target
+	}
+	for from, desc := range opts.Contexts {
+		// https://github.com/moby/buildkit/blob/v0.11.6/frontend/dockerfile/builder/build.go#L967-L1019 ("oci-layout://STORE@DIGEST", where "STORE" is one of the "oci:STORE" content stores below)
+		frontendAttrs["context:"+from] = "oci-layout://" + buildkitOCIStore + "@" + desc.Digest.String()
+	}
+
+	ctx, client, err := newContainerdClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	// NO: defer client.Close()
+
+	// make sure containerd doesn't garbage collect anything BuildKit writes before we get a chance to tag it
+	ctx, done, err := client.WithLease(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done(ctx)
+
+	exportStore, err := newBuildkitExportStore(ctx, client.ContentStore())
+	if err != nil {
+		return nil, err
+	}
+
+	bk, err := bkclient.New(ctx, host, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to %q: %w", host, err)
+	}
+	defer bk.Close()
+
+	info, err := bk.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed querying %q: %w", host, err)
+	}
+
+	exporterAttrs := buildkitOCIExporterAttrs(opts, buildkitVersionSupportsRewriteTimestamp(info.BuildkitVersion.Version))
+	exporterAttrs["tar"] = "false" // write into the "export" content store (below) instead of sending us a tarball
+
+	solveOpt := bkclient.SolveOpt{
+		Frontend:      "dockerfile.v0",
+		FrontendAttrs: frontendAttrs,
+		Exports: []bkclient.ExportEntry{{
+			Type:  bkclient.ExporterOCI,
+			Attrs: exporterAttrs,
+		}},
+		Session: []session.Attachable{
+			buildkitFileSync{
+				dirs: map[string]iofs.FS{
+					"context":    contextFS,
+					"dockerfile": dockerfileFS,
+				},
+				sent: opts.ContextBytes,
+			},
+			sessioncontent.NewAttachable(map[string]content.Store{
+				"export": exportStore,
+				// (see "opts.Contexts" above; the same store, since that's where builds end up)
+				"oci:" + buildkitOCIStore: exportStore,
+			}),
+		},
+	}
+
+	// see "dockerBuildxBuild" (we only show build output if it fails, unless "--debug")
+	var progress io.Writer = os.Stderr
+	buf := &bytes.Buffer{}
+	if !debugFlag {
+		progress = buf
+	}
+	if opts.Log != nil {
+		fmt.Fprintf(opts.Log, "$ buildctl --addr %q build --frontend %q (frontend attrs %q; exporter attrs %q)\n", host, solveOpt.Frontend, frontendAttrs, exporterAttrs)
+		progress = teeBuildLog(progress, opts.Log)
+	}
+
+	var resp *bkclient.SolveResponse
+	statusCh := make(chan *bkclient.SolveStatus)
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		var err error
+		resp, err = bk.Solve(egCtx, nil, solveOpt, statusCh)
+		return err
+	})
+	eg.Go(func() error {
+		_, err := progressui.DisplaySolveStatus(egCtx, "", nil, progress, statusCh)
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		if !debugFlag {
+			err = cli.NewMultiError(err, fmt.Errorf(`buildkit output:%s`, "\n"+buf.String()))
+		}
+		return nil, err
+	}
+
+	descJSON, err := base64.StdEncoding.DecodeString(resp.ExporterResponse[exptypes.ExporterImageDescriptorKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding image descriptor from buildkit: %w", err)
+	}
+	var desc imagespec.Descriptor
+	if err := json.Unmarshal(descJSON, &desc); err != nil {
+		return nil, fmt.Errorf("failed parsing image descriptor from buildkit: %w", err)
+	}
+	if desc.Digest == "" {
+		return nil, fmt.Errorf("buildkit did not return an image descriptor (%v)", resp.ExporterResponse)
+	}
+
+	if err := containerdTagImage(ctx, client, desc, tags); err != nil {
+		return nil, err
+	}
+
+	return &desc, nil
+}
+
+// a "content.Store" which applies our containerd namespace and lease (see "newContainerdClient" and "client.WithLease") to every call, since BuildKit calls the "export" store with the context of its session (which has neither, so the export would otherwise land in the wrong namespace, if any, unprotected from garbage collection)
+type buildkitExportStore struct {
+	content.Store
+	namespace string
+	lease     string
+}
+
+func newBuildkitExportStore(ctx context.Context, store content.Store) (buildkitExportStore, error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return buildkitExportStore{}, err
+	}
+	lease, _ := leases.FromContext(ctx)
+	return buildkitExportStore{Store: store, namespace: ns, lease: lease}, nil
+}
+
+func (s buildkitExportStore) scoped(ctx context.Context) context.Context {
+	ctx = namespaces.WithNamespace(ctx, s.namespace)
+	if s.lease != "" {
+		ctx = leases.WithLease(ctx, s.lease)
+	}
+	return ctx
+}
+
+func (s buildkitExportStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	return s.Store.Info(s.scoped(ctx), dgst)
+}
+
+func (s buildkitExportStore) Update(ctx context.Context, info content.Info, fieldpaths ...string) (content.Info, error) {
+	return s.Store.Update(s.scoped(ctx), info, fieldpaths...)
+}
+
+func (s buildkitExportStore) Walk(ctx context.Context, fn content.WalkFunc, filters ...string) error {
+	return s.Store.Walk(s.scoped(ctx), fn, filters...)
+}
+
+func (s buildkitExportStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	return s.Store.Delete(s.scoped(ctx), dgst)
+}
+
+func (s buildkitExportStore) ReaderAt(ctx context.Context, desc imagespec.Descriptor) (content.ReaderAt, error) {
+	return s.Store.ReaderAt(s.scoped(ctx), desc)
+}
+
+func (s buildkitExportStore) Status(ctx context.Context, ref string) (content.Status, error) {
+	return s.Store.Status(s.scoped(ctx), ref)
+}
+
+func (s buildkitExportStore) ListStatuses(ctx context.Context, filters ...string) ([]content.Status, error) {
+	return s.Store.ListStatuses(s.scoped(ctx), filters...)
+}
+
+func (s buildkitExportStore) Abort(ctx context.Context, ref string) error {
+	return s.Store.Abort(s.scoped(ctx), ref)
+}
+
+func (s buildkitExportStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	w, err := s.Store.Writer(s.scoped(ctx), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return buildkitExportWriter{Writer: w, store: s}, nil
+}
+
+// "Commit" is what adds the content to the lease, so it needs the same treatment (see "buildkitExportStore")
+type buildkitExportWriter struct {
+	content.Writer
+	store buildkitExportStore
+}
+
+func (w buildkitExportWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	return w.Writer.Commit(w.store.scoped(ctx), size, expected, opts...)
+}
+
+// a BuildKit session attachable that serves local directories ("context", "dockerfile") out of io/fs objects (usually from "gitfs") instead of the local filesystem
+type buildkitFileSync struct {
+	dirs map[string]iofs.FS
+
+	// if non-nil, counts the bytes of every file we actually send (see "dockerBuildOptions.ContextBytes")
+	sent io.Writer
+}
+
+func (dirs buildkitFileSync) Register(server *grpc.Server) {
+	filesync.RegisterFileSyncServer(server, dirs)
+}
+
+func (dirs buildkitFileSync) DiffCopy(stream filesync.FileSync_DiffCopyServer) error {
+	// https://github.com/moby/buildkit/blob/v0.11.6/session/filesync/filesync.go#L20-L27
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	first := func(key string) string {
+		if vals := md[key]; len(vals) > 0 {
+			return vals[0]
+		}
+		return ""
+	}
+
+	name := first("dir-name")
+	f, ok := dirs.dirs[name]
+	if !ok {
+		return status.Errorf(codes.NotFound, "no access allowed to dir %q", name)
+	}
+
+	excludes, err := patternmatcher.New(md["exclude-patterns"])
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid exclude patterns: %v", err)
+	}
+
+	return fsutil.Send(stream.Context(), stream, buildkitFS{
+		fs:       f,
+		includes: append(md["include-patterns"], md["followpaths"]...),
+		excludes: excludes,
+		sent:     dirs.sent,
+	}, nil)
+}
+
+func (dirs buildkitFileSync) TarStream(stream filesync.FileSync_TarStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "tarstream is not supported")
+}
+
+// adapts an io/fs to BuildKit's "fsutil.FS", applying the include/exclude patterns BuildKit asks for (".dockerignore", only the Dockerfile, etc)
+type buildkitFS struct {
+	fs       iofs.FS
+	includes []string
+	excludes *patternmatcher.PatternMatcher
+	sent     io.Writer // see "buildkitFileSync"
+}
+
+// whether the given path (or one of its parents) matches one of "includes" (or there are no "includes")
+func (f buildkitFS) included(p string) bool {
+	if len(f.includes) == 0 {
+		return true
+	}
+	for ; p != "." && p != "/"; p = path.Dir(p) {
+		for _, pattern := range f.includes {
+			if ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f buildkitFS) Walk(ctx context.Context, fn filepath.WalkFunc) error {
+	// directories which aren't explicitly included (or are excluded, but might have exceptions inside) are only sent if something inside them is (and need to be sent before it)
+	var pending []string
+	send := func(p string, info iofs.FileInfo) error {
+		stat := &fsutiltypes.Stat{
+			Path:    p,
+			Mode:    uint32(info.Mode()),
+			ModTime: info.ModTime().UnixNano(),
+		}
+		if !info.IsDir() {
+			stat.Size_ = info.Size()
+		}
+		if info.Mode()&iofs.ModeSymlink != 0 {
+			readlinkFS, ok := f.fs.(interface {
+				ReadLink(name string) (string, error)
+			})
+			if !ok {
+				return fmt.Errorf("filesystem contains symlinks but does not implement ReadLinkFS (needed for symlink %q)", p)
+			}
+			var err error
+			stat.Linkname, err = readlinkFS.ReadLink(p)
+			if err != nil {
+				return err
+			}
+		}
+		return fn(filepath.FromSlash(p), buildkitFileInfo{info, stat}, nil)
+	}
+
+	return iofs.WalkDir(f.fs, ".", func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("%q: %w", p, err)
+		}
+		if p == "." {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		for len(pending) > 0 && !strings.HasPrefix(p, pending[len(pending)-1]+"/") {
+			pending = pending[:len(pending)-1]
+		}
+
+		excluded, err := f.excludes.MatchesOrParentMatches(p)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			if d.IsDir() && f.excludes.Exclusions() {
+				pending = append(pending, p)
+				return nil
+			} else if d.IsDir() {
+				return iofs.SkipDir
+			}
+			return nil
+		}
+		if !f.included(p) {
+			if d.IsDir() {
+				pending = append(pending, p)
+			}
+			return nil
+		}
+
+		for _, dir := range pending {
+			info, err := iofs.Stat(f.fs, dir)
+			if err != nil {
+				return err
+			}
+			if err := send(dir, info); err != nil {
+				return err
+			}
+		}
+		pending = nil
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return send(p, info)
+	})
+}
+
+func (f buildkitFS) Open(p string) (io.ReadCloser, error) {
+	file, err := f.fs.Open(filepath.ToSlash(p))
+	if err != nil || f.sent == nil {
+		return file, err
+	}
+	return buildkitSentFile{Reader: io.TeeReader(file, f.sent), Closer: file}, nil
+}
+
+// a file whose contents are counted as they're read (and thus sent; see "buildkitFS.Open")
+type buildkitSentFile struct {
+	io.Reader
+	io.Closer
+}
+
+// "fsutil.Send" expects "Sys()" to be a "*types.Stat"
+type buildkitFileInfo struct {
+	iofs.FileInfo
+	stat *fsutiltypes.Stat
+}
+
+func (fi buildkitFileInfo) Sys() any {
+	return fi.stat
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/moby/patternmatcher"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.etcd.io/bbolt"
+)
+
+// the same setup as "newBuiltinContainerdServices", but in a temporary directory
+func newTestContainerdDB(t *testing.T) *metadata.DB {
+	t.Helper()
+	root := t.TempDir()
+	cs, err := local.NewStore(filepath.Join(root, "content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bbolt.Open(filepath.Join(root, "metadata.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return metadata.NewDB(db, cs, nil)
+}
+
+func TestBuildkitExportStore(t *testing.T) {
+	mdb := newTestContainerdDB(t)
+	leaseManager := metadata.NewLeaseManager(mdb)
+
+	ctx := namespaces.WithNamespace(context.Background(), "bashbrew-test")
+	lease, err := leaseManager.Create(ctx, leases.WithRandomID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = leases.WithLease(ctx, lease.ID)
+
+	store, err := newBuildkitExportStore(ctx, mdb.ContentStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// BuildKit writes with the context of its session, which has neither our namespace nor our lease
+	blob := []byte("exported by buildkit")
+	desc := imagespec.Descriptor{
+		MediaType: imagespec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+	}
+	if err := content.WriteBlob(context.Background(), store, "export-test", bytes.NewReader(blob), desc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Info(context.Background(), desc.Digest); err != nil {
+		t.Errorf("expected to find the export through the wrapper: %v", err)
+	}
+
+	// it should be visible to the rest of bashbrew (which uses "ctx")
+	if _, err := mdb.ContentStore().Info(ctx, desc.Digest); err != nil {
+		t.Fatalf("expected %s in our namespace: %v", desc.Digest, err)
+	}
+
+	// and survive garbage collection for as long as the lease does
+	if _, err := mdb.GarbageCollect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.ContentStore().Info(ctx, desc.Digest); err != nil {
+		t.Fatalf("expected %s to survive garbage collection while leased: %v", desc.Digest, err)
+	}
+	if err := leaseManager.Delete(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.GarbageCollect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.ContentStore().Info(ctx, desc.Digest); !errdefs.IsNotFound(err) {
+		t.Errorf("expected %s to be garbage collected once the lease is gone; got %v", desc.Digest, err)
+	}
+
+	if _, err := newBuildkitExportStore(context.Background(), mdb.ContentStore()); err == nil {
+		t.Errorf("expected an error without a namespace")
+	}
+}
+
+func TestBuildkitFSWalk(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"Dockerfile":             {Data: []byte("FROM scratch\n")},
+		".dockerignore":          {Data: []byte("docs\n")},
+		"README.md":              {Data: []byte("# hi\n")},
+		"docs/index.md":          {Data: []byte("# docs\n")},
+		"docs/keep/important.md": {Data: []byte("# important\n")},
+		"src/main.c":             {Data: []byte("int main() {}\n")},
+		"src/lib/util.c":         {Data: []byte("void util() {}\n")},
+		"src/lib/util.h":         {Data: []byte("void util();\n")},
+	}
+
+	tests := map[string]struct {
+		includes []string
+		excludes []string
+		expected []string
+	}{
+		"everything": {
+			expected: []string{".dockerignore", "Dockerfile", "README.md", "docs", "docs/index.md", "docs/keep", "docs/keep/important.md", "src", "src/lib", "src/lib/util.c", "src/lib/util.h", "src/main.c"},
+		},
+		"only the Dockerfile": {
+			includes: []string{"Dockerfile", ".dockerignore"},
+			expected: []string{".dockerignore", "Dockerfile"},
+		},
+		"included directory": {
+			includes: []string{"src/lib"},
+			expected: []string{"src", "src/lib", "src/lib/util.c", "src/lib/util.h"},
+		},
+		"included glob": {
+			includes: []string{"src/lib/*.h"},
+			expected: []string{"src", "src/lib", "src/lib/util.h"},
+		},
+		"excluded": {
+			excludes: []string{"docs", "*.md"},
+			expected: []string{".dockerignore", "Dockerfile", "src", "src/lib", "src/lib/util.c", "src/lib/util.h", "src/main.c"},
+		},
+		"excluded with an exception": {
+			excludes: []string{"docs", "!docs/keep"},
+			expected: []string{".dockerignore", "Dockerfile", "README.md", "docs", "docs/keep", "docs/keep/important.md", "src", "src/lib", "src/lib/util.c", "src/lib/util.h", "src/main.c"},
+		},
+		"included and excluded": {
+			includes: []string{"src"},
+			excludes: []string{"**/*.h"},
+			expected: []string{"src", "src/lib", "src/lib/util.c", "src/main.c"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			excludes, err := patternmatcher.New(test.excludes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sent := &bytes.Buffer{}
+			bkfs := buildkitFS{fs: mapFS, includes: test.includes, excludes: excludes, sent: sent}
+
+			walked := []string{}
+			err = bkfs.Walk(context.Background(), func(p string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if stat, ok := info.Sys().(interface{ GetPath() string }); !ok || stat.GetPath() != filepath.ToSlash(p) {
+					t.Errorf("%q: unexpected Sys(): %#v", p, info.Sys())
+				}
+				walked = append(walked, filepath.ToSlash(p))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(walked, test.expected) {
+				t.Errorf("expected %q; got %q", test.expected, walked)
+			}
+
+			var size int64
+			for _, p := range walked {
+				info, _ := fs.Stat(mapFS, p)
+				if info == nil || info.IsDir() {
+					continue
+				}
+				size += info.Size()
+				r, err := bkfs.Open(filepath.FromSlash(p))
+				if err != nil {
+					t.Errorf("%q: %v", p, err)
+					continue
+				}
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					t.Errorf("%q: %v", p, err)
+				}
+				r.Close()
+			}
+			if int64(sent.Len()) != size {
+				t.Errorf("expected %d bytes sent; got %d", size, sent.Len())
+			}
+		})
+	}
+}
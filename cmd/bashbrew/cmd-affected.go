@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker-library/bashbrew/manifest"
+
+	"github.com/urfave/cli"
+)
+
+func cmdAffected(c *cli.Context) error {
+	repos, err := repos(c.Bool("all"), c.Args()...)
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`failed gathering repo list`), err)
+	}
+
+	gitDir, err := filepath.Abs(c.String("git-dir"))
+	if err != nil {
+		return err
+	}
+	commits := c.String("commits")
+	if commits == "" {
+		return fmt.Errorf(`missing --commits`)
+	}
+
+	uniq := c.Bool("uniq")
+	applyConstraints := c.Bool("apply-constraints")
+	archFilter := c.Bool("arch-filter")
+	dockerfileFilter := c.Bool("dockerfile-filter")
+	children := c.BoolT("children")
+
+	changed, err := gitChangedFiles(gitDir, commits)
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`failed listing files changed in %q of %q`, commits, gitDir), err)
+	}
+	if debugFlag {
+		fmt.Printf("DEBUG: changed files: %q\n", changed)
+	}
+	rangeCommits, err := gitRangeCommits(gitDir, commits)
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`failed listing commits in %q of %q`, commits, gitDir), err)
+	}
+
+	// "plan" jobs (entry+architecture pairs) which are directly affected by the changed files
+	affected := map[string]bool{}
+	for _, repo := range repos {
+		r, err := fetch(repo)
+		if err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed fetching repo %q`, repo), err)
+		}
+
+		for _, entry := range r.Entries() {
+			if applyConstraints && r.SkipConstraints(entry) {
+				continue
+			}
+			if archFilter && !entry.HasArchitecture(arch) {
+				continue
+			}
+
+			entryArches := []string{arch}
+			if !applyConstraints && !archFilter {
+				entryArches = entry.Architectures
+			}
+
+			for _, entryArch := range entryArches {
+				// the changed paths only mean anything for entries built from a commit in the given range of the given repository (any other entry with the same "Directory" is some other repository, or doesn't include the changes)
+				if inRange, err := gitCommitIn(gitDir, entry.ArchGitCommit(entryArch), rangeCommits); err != nil {
+					return cli.NewMultiError(fmt.Errorf(`failed checking whether %q (tags %q, arch %q) is from %q of %q`, r.RepoName, entry.TagsString(), entryArch, commits, gitDir), err)
+				} else if !inRange {
+					continue
+				}
+
+				ok, err := r.archAffectedBy(entryArch, entry, changed, dockerfileFilter)
+				if err != nil {
+					return cli.NewMultiError(fmt.Errorf(`failed checking whether %q (tags %q, arch %q) is affected`, r.RepoName, entry.TagsString(), entryArch), err)
+				}
+				if ok {
+					affected[r.planJobName(entryArch, entry)] = true
+				}
+			}
+		}
+	}
+
+	return printRebuildOrder(repos, affected, children, uniq, applyConstraints, archFilter)
+}
+
+// prints the tags of every entry with an "affected" job (see "planJobName"), plus everything built FROM them (recursively, from anywhere in --library) if "children" is set, in build order
+func printRebuildOrder(repos []string, affected map[string]bool, children, uniq, applyConstraints, archFilter bool) error {
+	if children {
+		// "downstream" images could be anywhere in the library, so we need the whole graph
+		var err error
+		repos, err = allRepos()
+		if err != nil {
+			return err
+		}
+	}
+	p, err := planJobs(repos, uniq, applyConstraints, archFilter)
+	if err != nil {
+		return err
+	}
+
+	// the plan is in build order, so everything a job needs is already decided by the time we get to it
+	seen := map[string]bool{}
+	for _, job := range p.Jobs {
+		if !affected[job.Name] && children {
+			for _, need := range job.Needs {
+				if affected[need] {
+					affected[job.Name] = true
+					break
+				}
+			}
+		}
+		if !affected[job.Name] || seen[job.Entry] {
+			// (we only print each entry once, even if it's affected on several architectures)
+			continue
+		}
+		seen[job.Entry] = true
+		for _, tag := range job.Tags {
+			fmt.Println(tag)
+		}
+	}
+
+	return nil
+}
+
+func allRepos() ([]string, error) {
+	repos, err := repos(true)
+	if err != nil {
+		return nil, cli.NewMultiError(fmt.Errorf(`failed gathering ALL repos list`), err)
+	}
+	return repos, nil
+}
+
+// returns the files changed in the given commit range ("git diff" syntax, ala "abc123..HEAD") of the Git repository in the given directory (renames show up as both the old and the new path)
+func gitChangedFiles(dir string, commits string) ([]string, error) {
+	out, err := git("-C", dir, "diff", "--name-only", "--no-renames", "-z", commits, "--")
+	if err != nil {
+		return nil, err
+	}
+	return strings.FieldsFunc(string(out), func(r rune) bool { return r == 0 }), nil
+}
+
+// returns the set of commits in the given commit range (see "gitChangedFiles") of the Git repository in the given directory
+func gitRangeCommits(dir string, commits string) (map[string]bool, error) {
+	out, err := git("-C", dir, "rev-list", commits, "--")
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]bool{}
+	for _, commit := range strings.Fields(string(out)) {
+		ret[commit] = true
+	}
+	return ret, nil
+}
+
+// whether the given commit (possibly abbreviated) exists in the Git repository in the given directory and is one of "rangeCommits" (see "gitRangeCommits")
+func gitCommitIn(dir string, commit string, rangeCommits map[string]bool) (bool, error) {
+	if commit == "" {
+		return false, nil
+	}
+	if rangeCommits[commit] {
+		return true, nil
+	}
+	cmd := gitCommand("-C", dir, "rev-parse", "--verify", "--quiet", "--end-of-options", commit+"^{commit}")
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == 1 {
+			// "--verify --quiet" exits 1 (without output) when the commit doesn't exist (in this repository)
+			return false, nil
+		}
+		return false, err
+	}
+	return rangeCommits[strings.TrimSpace(string(out))], nil
+}
+
+// whether any of the given changed files (relative to the top of the entry's Git repository) affect the given entry: anything inside its "Directory", or with "dockerfileFilter", only its Dockerfile, ".dockerignore", and the sources of its "COPY"/"ADD" instructions
+func (r Repo) archAffectedBy(arch string, entry *manifest.Manifest2822Entry, changed []string, dockerfileFilter bool) (bool, error) {
+	dir := entry.ArchDirectory(arch)
+	file := entry.ArchFile(arch)
+
+	var sources []string
+	if dockerfileFilter && entry.ArchBuilder(arch) != "oci-import" { // an "oci-import" entry is nothing but its sources
+		meta, err := r.archDockerfileMetadata(arch, entry)
+		if err != nil {
+			return false, err
+		}
+		sources = append([]string{file, ".dockerignore", file + ".dockerignore"}, meta.Sources...)
+	}
+
+	for _, f := range changed {
+		rel := f
+		if dir != "." {
+			var ok bool
+			if rel, ok = strings.CutPrefix(f, dir+"/"); !ok {
+				continue
+			}
+		}
+		if sources == nil {
+			return true, nil
+		}
+		for _, src := range sources {
+			if src == "." {
+				return true, nil
+			}
+			// "COPY foo" copies everything in "foo/", and "COPY *.d" copies everything in "conf.d/" too
+			for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+				if ok, _ := path.Match(src, p); ok {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
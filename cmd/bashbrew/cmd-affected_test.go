@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitCommitIn(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	origCache := defaultCache
+	defer func() { defaultCache = origCache }()
+	defaultCache = t.TempDir()
+	if err := os.MkdirAll(gitCache(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := git(append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	run("init", "--quiet")
+	run("commit", "--quiet", "--allow-empty", "--message", "before")
+	before := run("rev-parse", "HEAD")
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("add", "Dockerfile")
+	run("commit", "--quiet", "--message", "change")
+	change := run("rev-parse", "HEAD")
+
+	rangeCommits, err := gitRangeCommits(dir, before+".."+change)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		change:      true,
+		change[:12]: true,  // abbreviated
+		before:      false, // before the range (doesn't include the changes)
+		"1111111111111111111111111111111111111111": false, // some other repository
+		"": false,
+	}
+	for commit, expected := range tests {
+		got, err := gitCommitIn(dir, commit, rangeCommits)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", commit, err)
+		} else if got != expected {
+			t.Errorf("%q: expected %v; got %v", commit, expected, got)
+		}
+	}
+}
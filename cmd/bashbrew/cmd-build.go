@@ -2,8 +2,13 @@ package main
 
 import (
 	"fmt"
+	"maps"
+	"os"
+	"slices"
 	"strings"
 
+	"github.com/docker-library/bashbrew/registry"
+
 	"github.com/urfave/cli"
 )
 
@@ -13,20 +18,106 @@ func cmdBuild(c *cli.Context) error {
 		return cli.NewMultiError(fmt.Errorf(`failed gathering repo list`), err)
 	}
 
-	repos, err = sortRepos(repos, true)
+	switch pull := c.String("pull"); pull {
+	case "always", "missing", "never":
+		// legit
+	default:
+		return fmt.Errorf(`invalid value for --pull: %q`, pull)
+	}
+	dryRun := c.Bool("dry-run")
+	if logDir := c.String("log-dir"); logDir != "" && !dryRun {
+		logKeep, logMaxAge := c.Int("log-keep"), c.Duration("log-max-age")
+		defer func() {
+			if err := pruneBuildLogs(logDir, logKeep, logMaxAge); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed pruning old build logs in %q: %v\n", logDir, err)
+			}
+		}()
+	}
+
+	buildArches, err := parseArchesFlag(c.StringSlice("arches"))
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`failed parsing "--arches"`), err)
+	}
+	putShared := c.Bool("put-shared")
+	if len(buildArches) == 0 {
+		if putShared {
+			return fmt.Errorf(`"--put-shared" requires "--arches"`)
+		}
+		return buildRepos(c, repos)
+	}
+
+	targetNamespace := c.String("target-namespace")
+	if targetNamespace == "" {
+		targetNamespace = namespace
+	}
+	pushOpts := pushOptions{
+		DryRun: dryRun,
+		Force:  c.Bool("force"),
+	}
+	var signer *registry.Signer
+	if putShared {
+		if targetNamespace == "" {
+			return fmt.Errorf(`either "--target-namespace" or "--namespace" is a required flag for "build --put-shared"`)
+		}
+		for _, buildArch := range buildArches {
+			if archNamespaces[buildArch] == "" {
+				return fmt.Errorf(`missing "--arch-namespace" for %q (required for "build --put-shared")`, buildArch)
+			}
+		}
+		if signKey := c.String("sign-key"); signKey != "" {
+			signer, err = registry.LoadSigner(signKey)
+			if err != nil {
+				return cli.NewMultiError(fmt.Errorf(`failed loading "--sign-key"`), err)
+			}
+		}
+	}
+
+	// one architecture at a time, but all in this one process (so everything shares the same Git clones, registry lookups, etc)
+	origArch := arch
+	for _, buildArch := range buildArches {
+		if err := setArch(buildArch); err != nil {
+			return err
+		}
+		fmt.Printf("Building architecture %s\n", arch)
+		if err := buildRepos(c, repos); err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed building architecture %q`, arch), err)
+		}
+		if putShared {
+			fmt.Printf("Pushing architecture %s to %s\n", arch, archNamespaces[arch])
+			if err := pushRepos(repos, archNamespaces[arch], c.Bool("uniq"), pushOpts, signer); err != nil {
+				return cli.NewMultiError(fmt.Errorf(`failed pushing architecture %q`, arch), err)
+			}
+		}
+	}
+	if err := setArch(origArch); err != nil {
+		return err
+	}
+
+	if putShared {
+		return putSharedRepos(repos, targetNamespace, false, pushOpts, signer)
+	}
+	return nil
+}
+
+// builds every entry of the given repos for the current "arch" (see "cmdBuild")
+func buildRepos(c *cli.Context, repos []string) error {
+	repos, err := sortRepos(repos, true)
 	if err != nil {
 		return cli.NewMultiError(fmt.Errorf(`failed sorting repo list`), err)
 	}
 
 	uniq := c.Bool("uniq")
 	pull := c.String("pull")
-	switch pull {
-	case "always", "missing", "never":
-		// legit
-	default:
-		return fmt.Errorf(`invalid value for --pull: %q`, pull)
-	}
 	dryRun := c.Bool("dry-run")
+	verifyReproducible := c.Bool("verify-reproducible")
+	cacheStages := c.Bool("cache-stages")
+	pinFroms := c.Bool("pin-froms")
+	logDir := c.String("log-dir")
+
+	var notReproducible []string
+
+	// tags we've built (or tagged) earlier in this run, which "--pin-froms" needs to leave alone (the registry doesn't have what we just built)
+	built := map[string]bool{}
 
 	for _, repo := range repos {
 		r, err := fetch(repo)
@@ -44,33 +135,67 @@ func cmdBuild(c *cli.Context) error {
 				continue
 			}
 
+			entryMetrics := metrics.Entry(*r, entry)
+
+			phase := entryMetrics.Start("fetch")
+			_, err := r.fetchGitRepo(arch, entry)
+			if err := phase.End(err); err != nil {
+				return cli.NewMultiError(fmt.Errorf(`failed fetching git repo for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
+			}
+
 			froms, err := r.DockerFroms(entry)
 			if err != nil {
 				return cli.NewMultiError(fmt.Errorf(`failed fetching/scraping FROM for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
 			}
 
 			fromScratch := false
+			pins := map[string]string{}
 			for _, from := range froms {
 				fromScratch = fromScratch || from == "scratch"
-				if from != "scratch" && pull != "never" {
+				if from == "scratch" {
+					continue
+				}
+
+				pullRef := from
+				if pinFroms && !built[from] {
+					pinned, err := pinFrom(from)
+					if err != nil {
+						return cli.NewMultiError(fmt.Errorf(`failed pinning FROM %q for %q (tags %q)`, from, r.RepoName, entry.TagsString()), err)
+					}
+					fmt.Printf("Pinning %s to %s (%s)\n", from, pinned, r.EntryIdentifier(entry))
+					pins[from] = pinned
+					pullRef = pinned
+				}
+
+				if pull != "never" {
 					doPull := false
 					switch pull {
 					case "always":
 						doPull = true
 					case "missing":
-						_, err := dockerInspect("{{.Id}}", from)
+						_, err := dockerInspect("{{.Id}}", pullRef)
 						doPull = (err != nil)
 					default:
 						return fmt.Errorf(`unexpected value for --pull: %s`, pull)
 					}
 					if doPull {
 						// TODO detect if "from" is something we've built (ie, "python:3-onbuild" is "FROM python:3" but we don't want to pull "python:3" if we "bashbrew build python")
-						fmt.Printf("Pulling %s (%s)\n", from, r.EntryIdentifier(entry))
+						fmt.Printf("Pulling %s (%s)\n", pullRef, r.EntryIdentifier(entry))
 						if !dryRun {
-							dockerPull(from)
+							phase := entryMetrics.Start("pull")
+							if err := phase.End(dockerPull(pullRef)); err != nil {
+								fmt.Fprintf(os.Stderr, "warning: failed pulling %q (%s): %v\n", pullRef, r.EntryIdentifier(entry), err)
+							}
 						}
 					}
 				}
+
+				if pullRef != from {
+					// the "cache hash" needs to be of what we're actually going to build on, not whatever the local tag happens to be
+					if id, err := dockerInspect("{{.Id}}", pullRef); err == nil && id != "" {
+						dockerFromIdCache[from] = id
+					}
+				}
 			}
 
 			cacheTag, err := r.DockerCacheName(entry)
@@ -80,83 +205,130 @@ func cmdBuild(c *cli.Context) error {
 			imageTags := r.Tags(namespace, uniq, entry)
 			tags := append([]string{cacheTag}, imageTags...)
 
-			// check whether we've already built this artifact
-			cachedDesc, err := containerdImageLookup(cacheTag)
+			builder, err := entryBuilder(entry)
 			if err != nil {
-				cachedDesc = nil
-				_, err = dockerInspect("{{.Id}}", cacheTag)
+				return cli.NewMultiError(fmt.Errorf(`failed looking up builder for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
 			}
-			if err != nil {
-				fmt.Printf("Building %s (%s)\n", cacheTag, r.EntryIdentifier(entry))
-				if !dryRun {
-					commit, err := r.fetchGitRepo(arch, entry)
-					if err != nil {
-						return cli.NewMultiError(fmt.Errorf(`failed fetching git repo for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
-					}
-
-					switch builder := entry.ArchBuilder(arch); builder {
-					case "buildkit", "classic", "":
-						var platform string
-						if fromScratch {
-							platform = ociArch.String()
-						}
-
-						archive, err := gitArchive(commit, entry.ArchDirectory(arch))
-						if err != nil {
-							return cli.NewMultiError(fmt.Errorf(`failed generating git archive for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
-						}
-						defer archive.Close()
+			buildOpts := buildOptions{FromScratch: fromScratch, Pins: pins, Built: built, Pull: pull}
 
-						if builder == "buildkit" {
-							err = dockerBuildxBuild(tags, entry.ArchFile(arch), archive, platform)
-						} else {
-							// TODO use "meta.StageNames" to do "docker build --target" so we can tag intermediate stages too for cache (streaming "git archive" directly to "docker build" makes that a little hard to accomplish without re-streaming)
-							err = dockerBuild(tags, entry.ArchFile(arch), archive, platform)
-						}
-						if err != nil {
-							return cli.NewMultiError(fmt.Errorf(`failed building %q (tags %q)`, r.RepoName, entry.TagsString()), err)
-						}
-
-						archive.Close() // be sure this happens sooner rather than later (defer might take a while, and we want to reap zombies more aggressively)
+			// check whether we've already built this artifact
+			cached, err := builder.Lookup(cacheTag)
+			if err != nil {
+				return cli.NewMultiError(fmt.Errorf(`failed looking up %q`, cacheTag), err)
+			}
 
-					case "oci-import":
-						desc, err := ociImportBuild(tags, commit, entry.ArchDirectory(arch), entry.ArchFile(arch))
-						if err != nil {
-							return cli.NewMultiError(fmt.Errorf(`failed oci-import build of %q (tags %q)`, r.RepoName, entry.TagsString()), err)
-						}
+			var log *buildLog
+			if logDir != "" && !dryRun && (cached == nil || verifyReproducible) {
+				log, err = newBuildLog(logDir, *r, entry)
+				if err != nil {
+					return cli.NewMultiError(fmt.Errorf(`failed creating build log for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
+				}
+				log.Logf("$ bashbrew %q", os.Args[1:])
+				for _, from := range slices.Sorted(maps.Keys(pins)) {
+					log.Logf("pinned %s to %s", from, pins[from])
+				}
+				buildOpts.Log = log
+				fmt.Printf("Logging %s to %s\n", r.EntryIdentifier(entry), log.Path)
+			}
 
-						fmt.Printf("Importing %s (%s) into Docker\n", r.EntryIdentifier(entry), desc.Digest)
-						err = containerdDockerLoad(*desc, imageTags)
-						if err != nil {
-							return cli.NewMultiError(fmt.Errorf(`failed oci-import into Docker of %q (tags %q)`, r.RepoName, entry.TagsString()), err)
+			if cached == nil {
+				if cacheStages {
+					stages, err := r.ArchIntermediateStages(arch, entry)
+					if err != nil {
+						return cli.NewMultiError(fmt.Errorf(`failed parsing stages of %q (tags %q)`, r.RepoName, entry.TagsString()), err)
+					}
+					for _, stage := range stages {
+						stageTag := dockerCacheStageTag(cacheTag, stage)
+						fmt.Printf("Building %s (stage %q of %s)\n", stageTag, stage, r.EntryIdentifier(entry))
+						if !dryRun {
+							// each of these re-streams the build context (and a failure in a later stage of a retry will get to skip straight past these thanks to the builder cache, which these tags keep alive)
+							stageOpts := buildOpts
+							stageOpts.Target = stage
+							phase := entryMetrics.Start("stage")
+							err := log.WrapError(phase.End(builder.Build(*r, entry, []string{stageTag}, stageOpts)))
+							if err != nil {
+								return cli.NewMultiError(fmt.Errorf(`failed building stage %q of %q (tags %q)`, stage, r.RepoName, entry.TagsString()), err)
+							}
 						}
+					}
+				}
 
-					default:
-						return cli.NewMultiError(fmt.Errorf(`unknown builder %q`, builder))
+				fmt.Printf("Building %s (%s)\n", cacheTag, r.EntryIdentifier(entry))
+				if !dryRun {
+					phase := entryMetrics.Start("build")
+					err := log.WrapError(phase.End(builder.Build(*r, entry, tags, buildOpts)))
+					if err != nil {
+						return cli.NewMultiError(fmt.Errorf(`failed building %q (tags %q)`, r.RepoName, entry.TagsString()), err)
 					}
 				}
 			} else {
 				fmt.Printf("Using %s (%s)\n", cacheTag, r.EntryIdentifier(entry))
 
 				if !dryRun {
-					if cachedDesc == nil {
-						// https://github.com/docker-library/bashbrew/pull/61#discussion_r1044926620
-						// abusing "docker build" for "tag something a lot of times, but efficiently" 👀
-						err := dockerBuild(imageTags, "", strings.NewReader("FROM "+cacheTag), "")
-						if err != nil {
-							return cli.NewMultiError(fmt.Errorf(`failed tagging %q: %q`, cacheTag, strings.Join(imageTags, ", ")), err)
-						}
+					phase := entryMetrics.Start("import")
+					err := phase.End(cached.DockerTag(tags))
+					if err != nil {
+						return cli.NewMultiError(fmt.Errorf(`failed tagging %q: %q`, cacheTag, strings.Join(imageTags, ", ")), err)
+					}
+				}
+			}
+
+			if verifyReproducible {
+				verifyTag := "bashbrew/reproducible:" + strings.TrimPrefix(cacheTag, "bashbrew/cache:")
+				fmt.Printf("Rebuilding %s as %s to verify reproducibility (%s)\n", cacheTag, verifyTag, r.EntryIdentifier(entry))
+				if !dryRun {
+					noCacheOpts := buildOpts
+					noCacheOpts.NoCache = true
+					phase := entryMetrics.Start("verify")
+					err := log.WrapError(phase.End(builder.Build(*r, entry, []string{verifyTag}, noCacheOpts)))
+					if err != nil {
+						return cli.NewMultiError(fmt.Errorf(`failed rebuilding %q (tags %q)`, r.RepoName, entry.TagsString()), err)
+					}
+					expected, err := builtImageDigests(builder, cacheTag)
+					if err != nil {
+						return cli.NewMultiError(fmt.Errorf(`failed looking up %q`, cacheTag), err)
+					}
+					actual, err := builtImageDigests(builder, verifyTag)
+					if err != nil {
+						return cli.NewMultiError(fmt.Errorf(`failed looking up %q`, verifyTag), err)
+					}
+					// the rebuild is only for comparing (and a full extra image per entry adds up quickly), so get rid of it right away -- the digests above are all we need to report a mismatch
+					if err := removeBuiltImageTag(verifyTag); err != nil {
+						return cli.NewMultiError(fmt.Errorf(`failed removing %q`, verifyTag), err)
+					}
+					if slices.Equal(expected, actual) {
+						fmt.Printf("Reproducible %s (%s)\n", r.EntryIdentifier(entry), strings.Join(actual, ", "))
 					} else {
-						fmt.Printf("Importing %s into Docker\n", cachedDesc.Digest)
-						err = containerdDockerLoad(*cachedDesc, tags)
-						if err != nil {
-							return cli.NewMultiError(fmt.Errorf(`failed (re-)import into Docker of %q (tags %q)`, r.RepoName, entry.TagsString()), err)
-						}
+						fmt.Fprintf(os.Stderr, "warning: %s is NOT reproducible (%s vs %s)\n", r.EntryIdentifier(entry), strings.Join(expected, ", "), strings.Join(actual, ", "))
+						notReproducible = append(notReproducible, r.EntryIdentifier(entry))
 					}
+					log.Logf("reproducible: %t (%s vs %s)", slices.Equal(expected, actual), strings.Join(expected, ", "), strings.Join(actual, ", "))
 				}
 			}
+
+			log.Close()
+
+			for _, tag := range append(r.Tags("", false, entry), imageTags...) {
+				built[tag] = true
+			}
 		}
 	}
 
+	if len(notReproducible) > 0 {
+		return fmt.Errorf(`not reproducible: %q`, notReproducible)
+	}
+
 	return nil
 }
+
+// returns the "Digests" of the given builder's build under the given tag
+func builtImageDigests(builder Builder, tag string) ([]string, error) {
+	img, err := builder.Lookup(tag)
+	if err != nil {
+		return nil, err
+	}
+	if img == nil {
+		return nil, fmt.Errorf("no build found for %q", tag)
+	}
+	return img.Digests()
+}
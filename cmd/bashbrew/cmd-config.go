@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+)
+
+// a flag's effective value (see "effectiveFlags")
+type effectiveFlag struct {
+	Key    string `json:"key"`
+	Flag   string `json:"flag"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// returns the effective value of every global flag (and every flag of "cmd", if given) for the given command and repos, and where each value comes from: the command line (global flags only), the environment, the "flags" file, or the default
+func effectiveFlags(c *cli.Context, cmd string, repos []string) ([]effectiveFlag, error) {
+	flags := []flagsConfigFlag{}
+	for _, f := range rootApp.Flags {
+		flags = append(flags, flagsConfigFlag{Name: flagName(f), Flag: f, Global: true})
+	}
+	if cmd != "" {
+		command := rootApp.Command(cmd)
+		if command == nil {
+			return nil, fmt.Errorf("unknown command %q", cmd)
+		}
+		cmd = command.Name
+		for _, f := range command.Flags {
+			flags = append(flags, flagsConfigFlag{Name: flagName(f), Flag: f})
+		}
+	}
+
+	fromConfig := flagsConfig.Lookup(cmd, repos)
+
+	ret := []effectiveFlag{}
+	for _, f := range flags {
+		if flagsConfigExcludedFlags[f.Name] {
+			continue
+		}
+		f.Key = flagsConfigFlagKey(f.Name)
+		eff := effectiveFlag{Key: f.Key, Flag: f.Name}
+
+		envVar, envVal := "", ""
+		for env := range strings.SplitSeq(flagEnvVars[f.Name], ",") {
+			if val, ok := os.LookupEnv(env); ok && env != "" {
+				envVar, envVal = env, val
+				break
+			}
+		}
+
+		if val, ok := fromConfig[f.Name]; ok {
+			eff.Value, eff.Source = strings.Join(f.values(val.Value), ", "), val.Source
+		} else {
+			eff.Value, eff.Source = f.defaultValue(), "default"
+		}
+		if envVar != "" {
+			eff.Value, eff.Source = envVal, "$"+envVar
+		}
+		if f.Global && c.GlobalIsSet(f.Name) {
+			// ("GlobalIsSet" includes the environment, but the command line wins)
+			eff.Value = formatFlagValue(c.GlobalGeneric(f.Name).(flag.Value))
+			if envVar == "" || eff.Value != strings.Join(f.values(envVal), ", ") {
+				eff.Source = "--" + f.Name
+			}
+		}
+
+		ret = append(ret, eff)
+	}
+	return ret, nil
+}
+
+func cmdConfigShow(c *cli.Context) error {
+	flags, err := effectiveFlags(c, c.String("command"), flagsConfigRepos(c.Args()))
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		out, err := json.Marshal(flags)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 1, 8, 2, ' ', 0)
+	for _, f := range flags {
+		fmt.Fprintf(tw, "%s: %s\t# %s\n", f.Key, f.Value, f.Source)
+	}
+	return tw.Flush()
+}
+
+func cmdConfigGet(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf(`expected a KEY (ala "Namespace" or "target-namespace")`)
+	}
+	key := args[0]
+
+	f, ok := flagsConfigLookupKey(rootApp, key)
+	if !ok {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	cmd := c.String("command")
+	if !f.Global && cmd == "" {
+		return fmt.Errorf(`%s is not a global flag (specify which command via "--command")`, f.Key)
+	}
+
+	flags, err := effectiveFlags(c, cmd, flagsConfigRepos(args[1:]))
+	if err != nil {
+		return err
+	}
+	for _, eff := range flags {
+		if eff.Flag == f.Name {
+			fmt.Println(eff.Value)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a flag of %q", f.Key, cmd)
+}
+
+func cmdConfigSet(c *cli.Context) error {
+	args := c.Args()
+	if len(args) != 2 {
+		return fmt.Errorf(`expected exactly a KEY and a VALUE (an empty VALUE removes KEY)`)
+	}
+	key, value := args[0], args[1]
+
+	f, ok := flagsConfigLookupKey(rootApp, key)
+	if !ok {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	if err := f.validate(value); err != nil {
+		return err
+	}
+
+	commands := []string{}
+	for _, cmd := range c.StringSlice("command") {
+		command := rootApp.Command(cmd)
+		if command == nil {
+			return fmt.Errorf("unknown command %q", cmd)
+		}
+		if !f.Global && lookupFlag(command.Flags, f.Name) == nil {
+			return fmt.Errorf("%s is not a flag of %q", f.Key, command.Name)
+		}
+		commands = append(commands, command.Name)
+	}
+	repos := c.StringSlice("repo")
+
+	file := filepath.Join(configPath, "flags")
+	contents, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		contents, err = []byte(fmt.Sprintf("Version: %d\n", flagsConfigVersion)), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	newContents := setFlagsConfigValue(rootApp, string(contents), commands, repos, f, value)
+
+	// make sure we're not about to write something we can't read back
+	newConfig, err := ParseFlagsConfig(strings.NewReader(newContents))
+	if err == nil {
+		newConfig.File = file
+		err = newConfig.Resolve(rootApp)
+	}
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`refusing to update %q`, file), err)
+	}
+
+	if err := os.MkdirAll(configPath, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(newContents), 0644)
+}
+
+// sets "f" to "value" (or removes it, if "value" is empty) in the paragraph of the given "flags" file contents with exactly the given Commands and Repos (adding a new paragraph if there isn't one), preserving everything else (comments, the order of paragraphs and keys, etc)
+func setFlagsConfigValue(app *cli.App, contents string, commands, repos []string, f flagsConfigFlag, value string) string {
+	// split into paragraphs (keeping comments with the lines they're next to)
+	paragraphs := [][]string{}
+	lines := strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+	cur := []string{}
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			if len(cur) > 0 {
+				paragraphs = append(paragraphs, cur)
+				cur = []string{}
+			}
+			continue
+		}
+		cur = append(cur, line)
+	}
+	if len(cur) > 0 {
+		paragraphs = append(paragraphs, cur)
+	}
+
+	// returns the key of a "Key: value" line (or "" for comments and continuation lines)
+	lineKey := func(line string) string {
+		if line == "" || line[0] == '#' || line[0] == ' ' || line[0] == '\t' {
+			return ""
+		}
+		key, _, _ := strings.Cut(line, ":")
+		return strings.TrimSpace(key)
+	}
+	// returns the values of a (possibly multi-line) list field, for comparing "Commands" and "Repos"
+	listValue := func(paragraph []string, key string) []string {
+		val := ""
+		inKey := false
+		for _, line := range paragraph {
+			if k := lineKey(line); k != "" {
+				inKey = k == key
+				if inKey {
+					_, v, _ := strings.Cut(line, ":")
+					val += v
+				}
+			} else if inKey && line[0] != '#' {
+				val += line
+			}
+		}
+		ret := []string{}
+		for bit := range strings.SplitSeq(val, ",") {
+			if bit = strings.TrimSpace(bit); bit != "" {
+				if cmd := app.Command(bit); key == "Commands" && cmd != nil {
+					bit = cmd.Name
+				}
+				ret = append(ret, bit)
+			}
+		}
+		slices.Sort(ret)
+		return ret
+	}
+
+	wantCommands := slices.Sorted(slices.Values(commands))
+	wantRepos := slices.Sorted(slices.Values(repos))
+	newLine := f.Key + ": " + value
+
+	// remove the key from every paragraph for exactly these Commands and Repos (since later paragraphs win, leaving it in any of them could shadow the new value), then put the new value in the last of them (where the key was, if it was there)
+	last, lastLine := -1, -1
+	for i, paragraph := range paragraphs {
+		if !slices.Equal(listValue(paragraph, "Commands"), wantCommands) || !slices.Equal(listValue(paragraph, "Repos"), wantRepos) {
+			continue
+		}
+		last, lastLine = i, len(paragraph)
+
+		newParagraph := []string{}
+		inKey := false
+		for _, line := range paragraph {
+			if k := lineKey(line); k != "" {
+				lineFlag, ok := flagsConfigLookupKey(app, k)
+				inKey = ok && lineFlag.Name == f.Name
+				if inKey {
+					lastLine = len(newParagraph)
+					continue
+				}
+			} else if inKey && line[0] != '#' {
+				// continuation of the value we're removing
+				continue
+			}
+			newParagraph = append(newParagraph, line)
+		}
+		lastLine = min(lastLine, len(newParagraph))
+		paragraphs[i] = newParagraph
+	}
+
+	if value != "" {
+		if last < 0 {
+			paragraph := []string{}
+			if len(commands) > 0 {
+				paragraph = append(paragraph, "Commands: "+strings.Join(commands, ", "))
+			}
+			if len(repos) > 0 {
+				paragraph = append(paragraph, "Repos: "+strings.Join(repos, ", "))
+			}
+			paragraphs = append(paragraphs, append(paragraph, newLine))
+		} else {
+			paragraphs[last] = slices.Insert(paragraphs[last], lastLine, newLine)
+		}
+	}
+
+	ret := []string{}
+	for _, paragraph := range paragraphs {
+		if len(paragraph) > 0 {
+			ret = append(ret, strings.Join(paragraph, "\n"))
+		}
+	}
+	return strings.Join(ret, "\n\n") + "\n"
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker-library/bashbrew/pkg/depgraph"
+
+	"github.com/urfave/cli"
+)
+
+// the architectures an edge should be labeled with in the "dot" and "mermaid" formats (none if it applies to every architecture of the child, which is the overwhelmingly common case)
+func graphEdgeLabel(g *depgraph.Graph, e *depgraph.Edge) string {
+	if n := g.Node(e.To); n != nil && len(e.Arches) == len(n.Arches) {
+		return ""
+	}
+	return strings.Join(e.Arches, ", ")
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// a DOT "double-quoted string" (https://graphviz.org/doc/info/lang.html), whose only escape is `\"` (and `\\`, so a trailing backslash doesn't eat the closing quote); everything else, including non-ASCII, goes in verbatim (unlike "strconv.Quote", whose "\u00e9" and "\x00" DOT doesn't understand)
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+func writeGraphDot(w io.Writer, g *depgraph.Graph) error {
+	fmt.Fprintln(w, "digraph bashbrew {")
+	fmt.Fprintln(w, "\trankdir=LR;")
+	for _, n := range g.Nodes() {
+		// ("\n" is DOT's own line break escape in labels, so it can't go through "dotQuote")
+		attrs := []string{`label="` + dotEscaper.Replace(n.ID) + `\n` + dotEscaper.Replace(strings.Join(n.Arches, ", ")) + `"`}
+		if n.External {
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(w, "\t%s [%s];\n", dotQuote(n.ID), strings.Join(attrs, ", "))
+	}
+	for _, e := range g.Edges() {
+		attrs := ""
+		if label := graphEdgeLabel(g, e); label != "" {
+			attrs = " [label=" + dotQuote(label) + "]"
+		}
+		fmt.Fprintf(w, "\t%s -> %s%s;\n", dotQuote(e.From), dotQuote(e.To), attrs)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func writeGraphMermaid(w io.Writer, g *depgraph.Graph) error {
+	// Mermaid node IDs can't contain most of the characters in a tag, so we number them instead
+	ids := map[string]string{}
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+	}
+	fmt.Fprintln(w, "graph LR")
+	for i, n := range g.Nodes() {
+		ids[n.ID] = "n" + strconv.Itoa(i)
+		if n.External {
+			fmt.Fprintf(w, "\t%s([%s])\n", ids[n.ID], quote(n.ID))
+		} else {
+			fmt.Fprintf(w, "\t%s[%s]\n", ids[n.ID], quote(n.ID))
+		}
+	}
+	for _, e := range g.Edges() {
+		arrow := "-->"
+		if label := graphEdgeLabel(g, e); label != "" {
+			arrow += "|" + quote(label) + "|"
+		}
+		if _, err := fmt.Fprintf(w, "\t%s %s %s\n", ids[e.From], arrow, ids[e.To]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdGraph(c *cli.Context) error {
+	repos, err := repos(c.Bool("all"), c.Args()...)
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`failed gathering repo list`), err)
+	}
+
+	applyConstraints := c.Bool("apply-constraints")
+	archFilter := c.Bool("arch-filter")
+	roots := c.StringSlice("root")
+	depth := c.Int("depth")
+	format := c.String("format")
+
+	g, err := entryGraph(repos, applyConstraints, archFilter)
+	if err != nil {
+		return err
+	}
+	if len(roots) > 0 {
+		ids := []string{}
+		for _, root := range roots {
+			nodes := resolveGraphArg(g, root)
+			if len(nodes) < 1 {
+				return fmt.Errorf(`failed to resolve root %q as a repo or tag in the graph`, root)
+			}
+			for _, n := range nodes {
+				ids = append(ids, n.ID)
+			}
+		}
+		g = g.Subgraph(g.Descendants(ids, nil, depth))
+	}
+
+	switch format {
+	case "dot":
+		return writeGraphDot(os.Stdout, g)
+	case "mermaid":
+		return writeGraphMermaid(os.Stdout, g)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(map[string]any{
+			"nodes": g.Nodes(),
+			"edges": g.Edges(),
+		})
+	default:
+		return fmt.Errorf(`unknown --format %q (expected "dot", "mermaid", or "json")`, format)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/docker-library/bashbrew/pkg/depgraph"
+
+	"github.com/urfave/cli"
+)
+
+// "app:1" is FROM "café:1" everywhere, and FROM an (awkwardly named) external image only on amd64
+func testGraph() *depgraph.Graph {
+	g := depgraph.New()
+	for _, id := range []string{"café:1", "app:1"} {
+		g.AddNode(id, id[:len(id)-2], nil)
+		for _, a := range []string{"amd64", "arm64v8"} {
+			g.AddTags(id, a, id)
+		}
+	}
+	g.AddFrom("app:1", "amd64", "café:1")
+	g.AddFrom("app:1", "arm64v8", "café:1")
+	g.AddFrom("app:1", "amd64", `example.com/odd"\`)
+	return g
+}
+
+func TestDotQuote(t *testing.T) {
+	tests := map[string]string{
+		"python:3":     `"python:3"`,
+		"café":         `"café"`, // (not "caf\u00e9")
+		`say "hi"`:     `"say \"hi\""`,
+		`trailing\`:    `"trailing\\"`,
+		"null\x00byte": "\"null\x00byte\"",
+	}
+	for s, expected := range tests {
+		if got := dotQuote(s); got != expected {
+			t.Errorf("%q: expected %s; got %s", s, expected, got)
+		}
+	}
+}
+
+func TestWriteGraphDot(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := writeGraphDot(buf, testGraph()); err != nil {
+		t.Fatal(err)
+	}
+	expected := `digraph bashbrew {
+	rankdir=LR;
+	"café:1" [label="café:1\namd64, arm64v8"];
+	"app:1" [label="app:1\namd64, arm64v8"];
+	"example.com/odd\"\\" [label="example.com/odd\"\\\namd64", style=dashed];
+	"café:1" -> "app:1";
+	"example.com/odd\"\\" -> "app:1" [label="amd64"];
+}
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestWriteGraphMermaid(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := writeGraphMermaid(buf, testGraph()); err != nil {
+		t.Fatal(err)
+	}
+	expected := `graph LR
+	n0["café:1"]
+	n1["app:1"]
+	n2(["example.com/odd#quot;\"])
+	n0 --> n1
+	n2 -->|"amd64"| n1
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestCmdGraph(t *testing.T) {
+	origArch, origNamespace := arch, namespace
+	defer func() { arch, namespace = origArch, origNamespace }()
+	arch, namespace = "amd64", ""
+	testPlanLibrary(t)
+
+	app := cli.NewApp()
+	app.Commands = []cli.Command{{
+		Name: "graph",
+		Flags: []cli.Flag{
+			cli.BoolFlag{Name: "all"},
+			cli.BoolFlag{Name: "apply-constraints"},
+			cli.BoolFlag{Name: "arch-filter"},
+			cli.StringSliceFlag{Name: "root"},
+			cli.IntFlag{Name: "depth"},
+			cli.StringFlag{Name: "format", Value: "dot"},
+		},
+		Action: cmdGraph,
+	}}
+
+	tests := map[string]struct {
+		args     []string
+		expected string
+	}{
+		"dot": {
+			args: []string{"top", "app", "base"},
+			expected: `digraph bashbrew {
+	rankdir=LR;
+	"top:1" [label="top:1\namd64"];
+	"app:1" [label="app:1\namd64, arm64v8, s390x"];
+	"base:a" [label="base:a\namd64"];
+	"base:b" [label="base:b\narm64v8"];
+	"base:shared" [label="base:shared\ns390x", style=dashed];
+	"app:1" -> "top:1";
+	"base:a" -> "app:1" [label="amd64"];
+	"base:b" -> "app:1" [label="arm64v8"];
+	"base:shared" -> "app:1" [label="s390x"];
+}
+`,
+		},
+		"json root": {
+			// (everything built FROM "app:1", on any architecture)
+			args: []string{"--format", "json", "--root", "app:1", "top", "app", "base"},
+			expected: `{
+	"edges": [
+		{
+			"from": "app:1",
+			"to": "top:1",
+			"arches": [
+				"amd64"
+			],
+			"refs": [
+				"app:1"
+			]
+		}
+	],
+	"nodes": [
+		{
+			"id": "top:1",
+			"repo": "top",
+			"tags": [
+				"top:1"
+			],
+			"arches": [
+				"amd64"
+			]
+		},
+		{
+			"id": "app:1",
+			"repo": "app",
+			"tags": [
+				"app:1"
+			],
+			"arches": [
+				"amd64",
+				"arm64v8",
+				"s390x"
+			]
+		}
+	]
+}
+`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := captureStdout(t, func() error {
+				return app.Run(append([]string{"bashbrew", "graph"}, test.args...))
+			})
+			if got != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, got)
+			}
+		})
+	}
+}
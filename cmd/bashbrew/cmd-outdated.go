@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+
+	"github.com/docker-library/bashbrew/registry"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	baseStatusUpToDate = "up-to-date"
+	baseStatusOutdated = "outdated"
+	baseStatusUnknown  = "unknown"
+)
+
+type baseStatus struct {
+	Ref    string `json:"ref"`
+	Base   string `json:"base"`
+	Status string `json:"status"`
+
+	Method   string `json:"method,omitempty"` // see "registry.BaseStatus"
+	Recorded string `json:"recorded,omitempty"`
+	Current  string `json:"current,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+func (s baseStatus) details() string {
+	switch {
+	case s.Error != "":
+		return s.Error
+	case s.Method == "layers":
+		return "compared layers against " + s.Current
+	case s.Status == baseStatusOutdated:
+		return fmt.Sprintf("built on %s, now %s", s.Recorded, s.Current)
+	}
+	return s.Current
+}
+
+func cmdOutdated(c *cli.Context) error {
+	repos, err := repos(c.Bool("all"), c.Args()...)
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`failed gathering repo list`), err)
+	}
+
+	targetNamespace := c.String("target-namespace")
+	doJson := c.Bool("json")
+	buildOrder := c.Bool("build-order")
+	uniq := c.Bool("uniq")
+
+	// see "cmdRemoteStatus"
+	if targetNamespace == "" {
+		targetNamespace = namespace
+	}
+	if targetNamespace == "" {
+		return fmt.Errorf(`either "--target-namespace" or "--namespace" is a required flag for "outdated"`)
+	}
+	imageNamespace := targetNamespace
+	if archNamespace := archNamespaces[arch]; archNamespace != "" {
+		imageNamespace = archNamespace
+	}
+
+	ctx := context.Background()
+
+	var tw *tabwriter.Writer
+	if !doJson && !buildOrder {
+		tw = tabwriter.NewWriter(os.Stdout, 1, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "REF\tBASE\tSTATUS\tDETAILS")
+	}
+	counts := map[string]int{}
+	outdated := map[string]bool{} // "plan" jobs (see "planJobName") whose base has moved
+	for _, repo := range repos {
+		r, err := fetch(repo)
+		if err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed fetching repo %q`, repo), err)
+		}
+
+		imageRepo := path.Join(imageNamespace, r.RepoName)
+		for _, entry := range r.Entries() {
+			if r.SkipConstraints(entry) {
+				continue
+			}
+
+			// the base of the image is the FROM of the last stage (which is what "ArchAnnotations" records; the FROMs of other stages don't leave a trace in the image for us to compare against)
+			from, err := r.ArchLastStageFrom(arch, entry)
+			if err != nil {
+				return cli.NewMultiError(fmt.Errorf(`failed fetching/scraping FROM for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
+			}
+			if from == "scratch" {
+				// nothing to move
+				continue
+			}
+
+			s := baseStatus{
+				Ref:    imageRepo + ":" + entry.Tags[0],
+				Base:   from,
+				Status: baseStatusUnknown,
+			}
+			if bs, err := registry.CheckBase(ctx, s.Ref, from, arch); err != nil {
+				s.Error = err.Error()
+			} else {
+				s.Method = bs.Method
+				s.Recorded = bs.Recorded.String()
+				s.Current = bs.Current.String()
+				if bs.Outdated {
+					s.Status = baseStatusOutdated
+					outdated[r.planJobName(arch, entry)] = true
+				} else {
+					s.Status = baseStatusUpToDate
+				}
+			}
+			counts[s.Status]++
+
+			switch {
+			case buildOrder:
+				if s.Error != "" {
+					fmt.Fprintf(os.Stderr, "warning: failed checking base of %q: %s\n", s.Ref, s.Error)
+				}
+			case doJson:
+				out, err := json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(out))
+			default:
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Ref, s.Base, s.Status, s.details())
+			}
+		}
+	}
+
+	if buildOrder {
+		// everything that's outdated, and everything built FROM it, in the order it needs to be rebuilt
+		return printRebuildOrder(repos, outdated, true, uniq, true, false)
+	}
+
+	if !doJson {
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%d %s, %d %s, %d %s\n",
+			counts[baseStatusUpToDate], baseStatusUpToDate,
+			counts[baseStatusOutdated], baseStatusOutdated,
+			counts[baseStatusUnknown], baseStatusUnknown,
+		)
+	}
+
+	return nil
+}
@@ -1,11 +1,8 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 
-	"github.com/docker-library/bashbrew/manifest"
-
 	"github.com/urfave/cli"
 )
 
@@ -20,72 +17,64 @@ func cmdParents(c *cli.Context) error {
 	archFilter := c.Bool("arch-filter")
 	depth := c.Int("depth")
 
+	// the arguments go first so they "own" their tags even if they aren't in --library (a local file, for example)
+	g, err := ancestorGraph(repos, applyConstraints, archFilter)
+	if err != nil {
+		return err
+	}
+
 	// used in conjunction with "uniq" to make sure we print a given tag once and only once when enabled
 	seen := map[string]struct{}{}
 
 	for _, repo := range repos {
-		lookup := []string{repo}
+		r, err := fetch(repo)
+		if err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed fetching repo %q`, repo), err)
+		}
+
+		lookup := []string{}
 		lookupArches := dedupeSlice[string]{} // this gets filled with the Architectures of the entries of the specified "repo" (such that we can then filter the architectures of the parents of the parents appropriately to prevent "orientdb" from having "mcr.microsoft.com/windows/servercore" as a parent due to being "FROM eclipse-temurin:8-jdk" but with a Linux-limited set of supported architectures)
+		for _, entry := range r.Entries() {
+			if applyConstraints && r.SkipConstraints(entry) {
+				continue
+			}
+			if archFilter && !entry.HasArchitecture(arch) {
+				continue
+			}
+			node := g.Node(r.Tags(namespace, false, entry)[0])
+			lookup = append(lookup, node.ID)
+			for _, nodeArch := range node.Arches {
+				lookupArches.add(nodeArch)
+			}
+		}
+
+		walked := map[string]bool{} // so a (broken) cyclic graph doesn't walk forever
+		for _, tag := range lookup {
+			walked[tag] = true
+		}
 		for d := depth; len(lookup) > 0 && (depth == 0 || d > 0); d-- {
 			nextLookup := dedupeSlice[string]{}
-			for _, repo := range lookup {
-				r, err := fetch(repo)
-				if err != nil {
-					var (
-						manifestNotFoundErr manifest.ManifestNotFoundError
-						tagNotFoundErr      manifest.TagNotFoundError
-					)
-					if d != depth && (errors.As(err, &manifestNotFoundErr) || errors.As(err, &tagNotFoundErr)) {
-						// if this repo isn't one of the original top-level arguments and our error is just that it's not a supported tag, walk no further ("FROM mcr.microsoft.com/...", etc)
-						continue
+			for _, tag := range lookup {
+				// parents that aren't supported tags ("FROM mcr.microsoft.com/...", etc) are "external" nodes, which have no parents of their own, so the walk stops there
+				froms := dedupeSlice[string]{}
+				for _, edge := range g.Parents(tag, lookupArches.slice()) {
+					if !walked[edge.From] {
+						walked[edge.From] = true
+						nextLookup.add(edge.From)
 					}
-					return cli.NewMultiError(fmt.Errorf(`failed fetching repo %q`, repo), err)
-				}
-				for _, entry := range r.Entries() {
-					if applyConstraints && r.SkipConstraints(entry) {
-						continue
-					}
-					if archFilter && !entry.HasArchitecture(arch) {
-						continue
-					}
-
-					if d == depth {
-						if !applyConstraints && !archFilter {
-							for _, entryArch := range entry.Architectures {
-								lookupArches.add(entryArch)
-							}
-						} else {
-							lookupArches.add(arch)
-						}
+					// we print the FROM values as they're written (which might be a different tag of the entry that "edge.From" is named after)
+					for _, from := range edge.Refs {
+						froms.add(from)
 					}
-
-					entryFroms := dedupeSlice[string]{}
-					for _, lookupArch := range lookupArches.slice() {
-						if !entry.HasArchitecture(lookupArch) {
+				}
+				for _, from := range froms.slice() {
+					if uniq {
+						if _, ok := seen[from]; ok {
 							continue
 						}
-						froms, err := r.ArchDockerFroms(lookupArch, entry)
-						if err != nil {
-							return cli.NewMultiError(fmt.Errorf(`failed fetching/scraping FROM for %q (tags %q, arch %q)`, r.RepoName, entry.TagsString(), lookupArch), err)
-						}
-						for _, from := range froms {
-							if from == "scratch" {
-								// "scratch" isn't really anyone's actual parent (it's a special-case built-in)
-								continue
-							}
-							entryFroms.add(from)
-						}
-					}
-					for _, from := range entryFroms.slice() {
-						nextLookup.add(from)
-						if uniq {
-							if _, ok := seen[from]; ok {
-								continue
-							}
-							seen[from] = struct{}{}
-						}
-						fmt.Println(from)
+						seen[from] = struct{}{}
 					}
+					fmt.Println(from)
 				}
 			}
 			lookup = nextLookup.slice()
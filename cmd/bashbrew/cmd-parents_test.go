@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker-library/bashbrew/manifest"
+	"github.com/docker-library/bashbrew/pkg/dockerfile"
+
+	"github.com/urfave/cli"
+)
+
+// writes the given manifests to a temporary "--library" and pre-populates the Git/Dockerfile caches so that the given Dockerfiles (by "Directory") are what every entry is FROM (without fetching anything)
+func testLibrary(t *testing.T, manifests map[string]string, dockerfiles map[string]string) {
+	t.Helper()
+
+	origLibrary, origRepoCache, origGitRepoCache, origMetaCache := defaultLibrary, repoCache, gitRepoCache, dockerfileMetadataCache
+	t.Cleanup(func() {
+		defaultLibrary, repoCache, gitRepoCache, dockerfileMetadataCache = origLibrary, origRepoCache, origGitRepoCache, origMetaCache
+	})
+	defaultLibrary = t.TempDir()
+	repoCache = map[string]*Repo{}
+	gitRepoCache = map[string]string{}
+	dockerfileMetadataCache = map[string]dockerfile.Metadata{}
+
+	for name, contents := range manifests {
+		if err := os.WriteFile(filepath.Join(defaultLibrary, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		man, err := manifest.Parse(strings.NewReader(contents))
+		if err != nil {
+			continue // (deliberately broken)
+		}
+		for _, entry := range man.Entries {
+			for _, entryArch := range entry.Architectures {
+				commit := entry.ArchGitCommit(entryArch)
+				gitRepoCache[strings.Join([]string{entry.ArchGitRepo(entryArch), entry.ArchGitFetch(entryArch), commit}, "\n")] = commit
+				meta, err := dockerfile.Parse(dockerfiles[entry.ArchDirectory(entryArch)])
+				if err != nil {
+					t.Fatal(err)
+				}
+				dockerfileMetadataCache[commit+"\n"+path.Join(entry.ArchDirectory(entryArch), entry.ArchFile(entryArch))] = meta
+			}
+		}
+	}
+}
+
+func TestCmdParents(t *testing.T) {
+	origArch, origNamespace := arch, namespace
+	defer func() { arch, namespace = origArch, origNamespace }()
+	arch, namespace = "amd64", ""
+
+	const header = "Maintainers: Foo (@foo)\nGitRepo: https://example.com/foo.git\nGitCommit: 0123456789abcdef0123456789abcdef01234567\n\n"
+	testLibrary(t, map[string]string{
+		"base":      header + "Tags: 1, latest\nArchitectures: amd64, arm64v8\nDirectory: base\n",
+		"mid":       header + "Tags: 1\nArchitectures: amd64, arm64v8\nDirectory: mid\n",
+		"app":       header + "Tags: 1\nArchitectures: amd64\nDirectory: app\n",
+		"unrelated": header + "Tags: 1\nArchitectures: amd64\nDirectory: unrelated\n",
+		"broken":    "this is not a valid manifest\n",
+	}, map[string]string{
+		"base":      "FROM scratch\n",
+		"mid":       "FROM base:latest\n",
+		"app":       "FROM mid:1 AS build\nFROM example.com/distroless:base\n",
+		"unrelated": "FROM app:1\n",
+	})
+
+	// only what "app" is (transitively) FROM gets fetched (so "broken" and "unrelated" don't matter)
+	g, err := ancestorGraph([]string{"app"}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, n := range g.Nodes() {
+		ids = append(ids, n.ID)
+	}
+	if expected := []string{"app:1", "mid:1", "base:1", "example.com/distroless:base"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected nodes %q; got %q", expected, ids)
+	}
+
+	app := cli.NewApp()
+	app.Commands = []cli.Command{{
+		Name: "parents",
+		Flags: []cli.Flag{
+			cli.BoolFlag{Name: "apply-constraints"},
+			cli.BoolFlag{Name: "arch-filter"},
+			cli.IntFlag{Name: "depth"},
+			cli.BoolFlag{Name: "uniq"},
+		},
+		Action: cmdParents,
+	}}
+	tests := map[string][]string{
+		"app":            {"mid:1", "example.com/distroless:base", "base:latest"},
+		"app --depth=1":  {"mid:1", "example.com/distroless:base"},
+		"mid:1":          {"base:latest"},
+		"base":           {},
+		"unrelated:1":    {"app:1", "mid:1", "example.com/distroless:base", "base:latest"},
+		"app unrelated":  {"mid:1", "example.com/distroless:base", "base:latest", "app:1", "mid:1", "example.com/distroless:base", "base:latest"},
+		"--uniq app mid": {"mid:1", "example.com/distroless:base", "base:latest"},
+	}
+	for args, expected := range tests {
+		t.Run(args, func(t *testing.T) {
+			got := strings.Fields(captureStdout(t, func() error {
+				return app.Run(append([]string{"bashbrew", "parents"}, strings.Fields(args)...))
+			}))
+			if !reflect.DeepEqual(got, expected) && !(len(got) == 0 && len(expected) == 0) {
+				t.Errorf("expected %q; got %q", expected, got)
+			}
+		})
+	}
+
+	// the arguments themselves still have to be valid (and "cli" wants to exit for us on a "MultiError")
+	origExiter, origErrWriter := cli.OsExiter, cli.ErrWriter
+	defer func() { cli.OsExiter, cli.ErrWriter = origExiter, origErrWriter }()
+	cli.OsExiter, cli.ErrWriter = func(int) {}, io.Discard
+	if err := app.Run([]string{"bashbrew", "parents", "broken"}); err == nil {
+		t.Errorf("expected error for a broken argument")
+	}
+}
+
+func captureStdout(t *testing.T, f func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origStdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	err = f()
+	os.Stdout = origStdout
+	w.Close()
+	ret := <-out
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/docker-library/bashbrew/architecture"
+	"github.com/docker-library/bashbrew/manifest"
+	"github.com/docker-library/bashbrew/pkg/depgraph"
+
+	"github.com/urfave/cli"
+	"pault.ag/go/topsort"
+)
+
+// a single entry+architecture build in a "bashbrew plan"
+type planJob struct {
+	// "repo:tag (arch)" (unique within a plan, and what "Needs" refers to)
+	Name string `json:"name"`
+
+	Repo        string   `json:"repo"`
+	Entry       string   `json:"entry"`
+	Arch        string   `json:"arch"`
+	Platform    string   `json:"platform,omitempty"`
+	Tags        []string `json:"tags"`
+	Builder     string   `json:"builder"`
+	Directory   string   `json:"directory"`
+	File        string   `json:"file"`
+	Constraints []string `json:"constraints"`
+	Froms       []string `json:"froms"`
+
+	// the jobs that build one of our "Froms" (and thus need to finish before this one can start)
+	Needs []string `json:"needs"`
+
+	// with "--waves", which group of jobs this one can run in parallel with (every job's "Needs" are in earlier waves)
+	Wave *int `json:"wave,omitempty"`
+}
+
+type plan struct {
+	Jobs  []*planJob `json:"jobs"`
+	Waves [][]string `json:"waves,omitempty"`
+}
+
+func cmdPlan(c *cli.Context) error {
+	repos, err := repos(c.Bool("all"), c.Args()...)
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`failed gathering repo list`), err)
+	}
+
+	uniq := c.Bool("uniq")
+	applyConstraints := c.Bool("apply-constraints")
+	archFilter := c.Bool("arch-filter")
+	waves := c.Bool("waves")
+	format := c.String("format")
+	switch format {
+	case "json", "github-actions":
+		// legit
+	default:
+		return fmt.Errorf(`invalid value for --format: %q`, format)
+	}
+
+	p, err := planJobs(repos, uniq, applyConstraints, archFilter)
+	if err != nil {
+		return err
+	}
+	if waves {
+		p.assignWaves()
+	}
+
+	var out any = p
+	if format == "github-actions" {
+		// https://docs.github.com/en/actions/using-jobs/using-a-matrix-for-your-jobs#expanding-or-adding-matrix-configurations ("strategy: ${{ fromJSON(needs.plan.outputs.strategy) }}"); a matrix can't express dependencies between its jobs, so with "--waves" this is a list of strategies, one per wave, for a chain of workflow jobs to "needs:" each other
+		strategy := func(jobs []*planJob) any {
+			return map[string]any{
+				"fail-fast": false,
+				"matrix": map[string]any{
+					"include": jobs,
+				},
+			}
+		}
+		if waves {
+			byName := map[string]*planJob{}
+			for _, job := range p.Jobs {
+				byName[job.Name] = job
+			}
+			strategies := []any{}
+			for _, wave := range p.Waves {
+				jobs := []*planJob{}
+				for _, name := range wave {
+					jobs = append(jobs, byName[name])
+				}
+				strategies = append(strategies, strategy(jobs))
+			}
+			out = strategies
+		} else {
+			out = strategy(p.Jobs)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(out)
+}
+
+// gathers a job for every entry+architecture of the given repos (only the current architecture with "applyConstraints" or "archFilter", like "bashbrew children"), connected via their FROM lines and sorted such that every job comes after all of its "Needs"
+func planJobs(repos []string, uniq, applyConstraints, archFilter bool) (*plan, error) {
+	g, err := entryGraph(repos, applyConstraints, archFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	order := []*planJob{}
+	network := topsort.NewNetwork()
+
+	// (the same repo listed twice, or "foo" and "foo:bar", is fine, since "entryGraph" only has one node per entry)
+	for _, n := range g.Nodes() {
+		if n.External {
+			// not something we're planning to build (so it's a pull instead)
+			continue
+		}
+		ge := n.Value.(graphEntry)
+		for _, entryArch := range n.Arches {
+			job, err := ge.Repo.planJob(entryArch, ge.Entry, uniq)
+			if err != nil {
+				return nil, err
+			}
+			order = append(order, job)
+			network.AddNode(job.Name, job)
+
+			// FROM values are resolved per-architecture by "entryGraph", so SharedTags end up depending on the right job
+			for _, edge := range g.Parents(n.ID, []string{entryArch}) {
+				from := g.Node(edge.From)
+				if from.External {
+					continue
+				}
+				fromGE := from.Value.(graphEntry)
+				job.Needs = append(job.Needs, fromGE.Repo.planJobName(entryArch, fromGE.Entry))
+			}
+		}
+	}
+
+	for _, job := range order {
+		for _, need := range job.Needs {
+			if err := network.AddEdge(need, job.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	nodes, err := network.Sort()
+	if err != nil {
+		if cycles := g.Cycles(); len(cycles) > 0 {
+			// the jobs can only be cyclic if the entries are (and the entries' cycles are much easier to read)
+			return nil, &depgraph.CycleError{Cycles: cycles}
+		}
+		return nil, err
+	}
+
+	p := &plan{Jobs: []*planJob{}}
+	for _, node := range nodes {
+		p.Jobs = append(p.Jobs, node.Value.(*planJob))
+	}
+	return p, nil
+}
+
+// "repo:tag (arch)"
+func (r Repo) planJobName(entryArch string, entry *manifest.Manifest2822Entry) string {
+	return r.EntryIdentifier(entry) + " (" + entryArch + ")"
+}
+
+func (r Repo) planJob(entryArch string, entry *manifest.Manifest2822Entry, uniq bool) (*planJob, error) {
+	froms, err := r.ArchDockerFroms(entryArch, entry)
+	if err != nil {
+		return nil, cli.NewMultiError(fmt.Errorf(`failed fetching/scraping FROM for %q (tags %q, arch %q)`, r.RepoName, entry.TagsString(), entryArch), err)
+	}
+
+	job := &planJob{
+		Name:        r.planJobName(entryArch, entry),
+		Repo:        r.RepoName,
+		Entry:       r.EntryIdentifier(entry),
+		Arch:        entryArch,
+		Tags:        r.Tags(namespace, uniq, entry),
+		Builder:     entry.ArchBuilder(entryArch),
+		Directory:   entry.ArchDirectory(entryArch),
+		File:        entry.ArchFile(entryArch),
+		Constraints: append([]string{}, entry.Constraints...),
+		Froms:       froms,
+		Needs:       []string{},
+	}
+	if ociArch, ok := architecture.SupportedArches[entryArch]; ok {
+		job.Platform = ociArch.String()
+	}
+	return job, nil
+}
+
+// groups the (already sorted) jobs into "waves" of jobs that can run in parallel: every job goes in the wave right after the latest of its "Needs"
+func (p *plan) assignWaves() {
+	waveOf := map[string]int{}
+	p.Waves = [][]string{}
+	for _, job := range p.Jobs {
+		wave := 0
+		for _, need := range job.Needs {
+			wave = max(wave, waveOf[need]+1)
+		}
+		waveOf[job.Name] = wave
+		job.Wave = &wave
+		if wave == len(p.Waves) {
+			p.Waves = append(p.Waves, []string{})
+		}
+		p.Waves[wave] = append(p.Waves[wave], job.Name)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker-library/bashbrew/pkg/depgraph"
+
+	"github.com/urfave/cli"
+)
+
+func testPlanLibrary(t *testing.T) {
+	t.Helper()
+	const header = "Maintainers: Foo (@foo)\nGitRepo: https://example.com/foo.git\nGitCommit: 0123456789abcdef0123456789abcdef01234567\n\n"
+	testLibrary(t, map[string]string{
+		// "base:shared" is a different entry on each architecture (and doesn't exist at all on s390x)
+		"base": header + "Tags: a\nSharedTags: shared\nArchitectures: amd64\nDirectory: base/a\n\nTags: b\nSharedTags: shared\nArchitectures: arm64v8\nDirectory: base/b\n",
+		"app":  header + "Tags: 1\nArchitectures: amd64, arm64v8, s390x\nDirectory: app\n",
+		"top":  header + "Tags: 1\nArchitectures: amd64\nDirectory: top\nBuilder: buildkit\n",
+
+		"cycle": header + "Tags: 1\nArchitectures: amd64\nDirectory: cycle/1\n\nTags: 2\nArchitectures: amd64\nDirectory: cycle/2\n",
+	}, map[string]string{
+		"base/a":  "FROM scratch\n",
+		"base/b":  "FROM scratch\n",
+		"app":     "FROM base:shared\n",
+		"top":     "FROM app:1\n",
+		"cycle/1": "FROM cycle:2\n",
+		"cycle/2": "FROM cycle:1\n",
+	})
+}
+
+func TestPlanWaves(t *testing.T) {
+	origArch, origNamespace := arch, namespace
+	defer func() { arch, namespace = origArch, origNamespace }()
+	arch, namespace = "amd64", ""
+	testPlanLibrary(t)
+
+	p, err := planJobs([]string{"top", "app", "base"}, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.assignWaves()
+
+	needs := map[string][]string{}
+	for _, job := range p.Jobs {
+		needs[job.Name] = job.Needs
+		if job.Wave == nil {
+			t.Errorf("%q: no wave assigned", job.Name)
+		}
+	}
+	expectedNeeds := map[string][]string{
+		"base:a (amd64)":   {},
+		"base:b (arm64v8)": {},
+		"app:1 (amd64)":    {"base:a (amd64)"},
+		"app:1 (arm64v8)":  {"base:b (arm64v8)"},
+		"app:1 (s390x)":    {}, // (there's no "base:shared" on s390x, so that's a pull)
+		"top:1 (amd64)":    {"app:1 (amd64)"},
+	}
+	if !reflect.DeepEqual(needs, expectedNeeds) {
+		t.Errorf("expected needs %q; got %q", expectedNeeds, needs)
+	}
+
+	// every job comes after what it needs
+	for i, job := range p.Jobs {
+		for _, need := range job.Needs {
+			if j := slices.IndexFunc(p.Jobs, func(job *planJob) bool { return job.Name == need }); j < 0 || j > i {
+				t.Errorf("%q: needs %q, which is at %d (not before %d)", job.Name, need, j, i)
+			}
+		}
+	}
+
+	waves := [][]string{}
+	for _, wave := range p.Waves {
+		wave = slices.Clone(wave)
+		slices.Sort(wave)
+		waves = append(waves, wave)
+	}
+	expectedWaves := [][]string{
+		{"app:1 (s390x)", "base:a (amd64)", "base:b (arm64v8)"},
+		{"app:1 (amd64)", "app:1 (arm64v8)"},
+		{"top:1 (amd64)"},
+	}
+	if !reflect.DeepEqual(waves, expectedWaves) {
+		t.Errorf("expected waves %q; got %q", expectedWaves, waves)
+	}
+	for _, job := range p.Jobs {
+		if !slices.Contains(p.Waves[*job.Wave], job.Name) {
+			t.Errorf("%q: wave %d does not contain it", job.Name, *job.Wave)
+		}
+	}
+
+	// the current architecture only (ala "--arch-filter")
+	p, err = planJobs([]string{"top", "app", "base"}, false, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, job := range p.Jobs {
+		names = append(names, job.Name)
+	}
+	if expected := []string{"base:a (amd64)", "app:1 (amd64)", "top:1 (amd64)"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %q; got %q", expected, names)
+	}
+
+	var cycleErr *depgraph.CycleError
+	if _, err := planJobs([]string{"cycle"}, false, false, false); !errors.As(err, &cycleErr) {
+		t.Errorf("expected a cycle error; got %v", err)
+	} else if !strings.Contains(err.Error(), "cycle:1") || !strings.Contains(err.Error(), "cycle:2") {
+		t.Errorf("expected both entries in %q", err)
+	}
+}
+
+func TestPlanGitHubActions(t *testing.T) {
+	origArch, origNamespace := arch, namespace
+	defer func() { arch, namespace = origArch, origNamespace }()
+	arch, namespace = "amd64", ""
+	testPlanLibrary(t)
+
+	app := cli.NewApp()
+	app.Commands = []cli.Command{{
+		Name: "plan",
+		Flags: []cli.Flag{
+			cli.BoolFlag{Name: "all"},
+			cli.BoolFlag{Name: "uniq"},
+			cli.BoolFlag{Name: "apply-constraints"},
+			cli.BoolFlag{Name: "arch-filter"},
+			cli.BoolFlag{Name: "waves"},
+			cli.StringFlag{Name: "format", Value: "json"},
+		},
+		Action: cmdPlan,
+	}}
+	got := captureStdout(t, func() error {
+		return app.Run([]string{"bashbrew", "plan", "--format", "github-actions", "--waves", "--arch-filter", "top", "app", "base"})
+	})
+	expected := `[
+	{
+		"fail-fast": false,
+		"matrix": {
+			"include": [
+				{
+					"name": "base:a (amd64)",
+					"repo": "base",
+					"entry": "base:a",
+					"arch": "amd64",
+					"platform": "linux/amd64",
+					"tags": [
+						"base:a",
+						"base:shared"
+					],
+					"builder": "",
+					"directory": "base/a",
+					"file": "Dockerfile",
+					"constraints": [],
+					"froms": [
+						"scratch"
+					],
+					"needs": [],
+					"wave": 0
+				}
+			]
+		}
+	},
+	{
+		"fail-fast": false,
+		"matrix": {
+			"include": [
+				{
+					"name": "app:1 (amd64)",
+					"repo": "app",
+					"entry": "app:1",
+					"arch": "amd64",
+					"platform": "linux/amd64",
+					"tags": [
+						"app:1"
+					],
+					"builder": "",
+					"directory": "app",
+					"file": "Dockerfile",
+					"constraints": [],
+					"froms": [
+						"base:shared"
+					],
+					"needs": [
+						"base:a (amd64)"
+					],
+					"wave": 1
+				}
+			]
+		}
+	},
+	{
+		"fail-fast": false,
+		"matrix": {
+			"include": [
+				{
+					"name": "top:1 (amd64)",
+					"repo": "top",
+					"entry": "top:1",
+					"arch": "amd64",
+					"platform": "linux/amd64",
+					"tags": [
+						"top:1"
+					],
+					"builder": "buildkit",
+					"directory": "top",
+					"file": "Dockerfile",
+					"constraints": [],
+					"froms": [
+						"app:1"
+					],
+					"needs": [
+						"app:1 (amd64)"
+					],
+					"wave": 2
+				}
+			]
+		}
+	}
+]
+`
+	if got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/docker-library/bashbrew/registry"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/urfave/cli"
+)
+
+func cmdPromote(c *cli.Context) error {
+	repos, err := repos(c.Bool("all"), c.Args()...)
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`failed gathering repo list`), err)
+	}
+
+	uniq := c.Bool("uniq")
+	targetNamespace := c.String("target-namespace")
+	dryRun := c.Bool("dry-run")
+	force := c.Bool("force")
+
+	if namespace == "" || targetNamespace == "" {
+		return fmt.Errorf(`both "--namespace" and "--target-namespace" are required flags for "promote"`)
+	}
+	if namespace == targetNamespace {
+		return fmt.Errorf(`"--namespace" and "--target-namespace" are identical (%q); nothing to promote`, namespace)
+	}
+
+	ctx := context.Background()
+
+	for _, repo := range repos {
+		r, err := fetch(repo)
+		if err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed fetching repo %q`, repo), err)
+		}
+
+		// SharedTags can show up in several entries, but we only need to copy them once
+		tags := dedupeSlice[string]{}
+		for _, entry := range r.Entries() {
+			// copying is registry-to-registry (whole indexes at a time), so the architecture we happen to be running on is irrelevant; only an explicit "--constraint" (or "--exclusive-constraints") narrows what we promote
+			if (len(constraints) > 0 || exclusiveConstraints) && r.SkipUnsatisfiedConstraints(entry) {
+				continue
+			}
+			for _, tag := range r.Tags("", uniq, entry) {
+				tags.add(tag)
+			}
+		}
+
+		for _, tag := range tags.slice() {
+			sourceTag := path.Join(namespace, tag)
+			targetTag := path.Join(targetNamespace, tag)
+
+			if !force {
+				sourceObj, err := registry.Resolve(ctx, sourceTag)
+				if err != nil {
+					return cli.NewMultiError(fmt.Errorf(`failed resolving %q`, sourceTag), err)
+				}
+				targetObj, err := registry.Resolve(ctx, targetTag)
+				if err != nil && !errdefs.IsNotFound(err) {
+					return cli.NewMultiError(fmt.Errorf(`failed resolving %q`, targetTag), err)
+				}
+				if err == nil && targetObj.Desc.Digest == sourceObj.Desc.Digest {
+					fmt.Fprintf(os.Stderr, "skipping %s (already %s)\n", targetTag, sourceObj.Desc.Digest)
+					continue
+				}
+			}
+
+			fmt.Printf("Promoting %s to %s\n", sourceTag, targetTag)
+			if !dryRun {
+				desc, err := registry.Copy(ctx, sourceTag, targetTag)
+				if err != nil {
+					return cli.NewMultiError(fmt.Errorf(`failed promoting %q to %q`, sourceTag, targetTag), err)
+				}
+				if debugFlag {
+					fmt.Printf("DEBUG: promoted %s (%s)\n", desc.Digest, desc.MediaType)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -2,9 +2,9 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"path"
-	"strings"
+
+	"github.com/docker-library/bashbrew/registry"
 
 	"github.com/urfave/cli"
 )
@@ -17,8 +17,10 @@ func cmdPush(c *cli.Context) error {
 
 	uniq := c.Bool("uniq")
 	targetNamespace := c.String("target-namespace")
-	dryRun := c.Bool("dry-run")
-	force := c.Bool("force")
+	opts := pushOptions{
+		DryRun: c.Bool("dry-run"),
+		Force:  c.Bool("force"),
+	}
 
 	if targetNamespace == "" {
 		targetNamespace = namespace
@@ -27,6 +29,19 @@ func cmdPush(c *cli.Context) error {
 		return fmt.Errorf(`either "--target-namespace" or "--namespace" is a required flag for "push"`)
 	}
 
+	var signer *registry.Signer
+	if signKey := c.String("sign-key"); signKey != "" {
+		signer, err = registry.LoadSigner(signKey)
+		if err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed loading "--sign-key"`), err)
+		}
+	}
+
+	return pushRepos(repos, targetNamespace, uniq, opts, signer)
+}
+
+// pushes every entry of the given repos (for the current "arch") to "targetNamespace" (see "cmdPush")
+func pushRepos(repos []string, targetNamespace string, uniq bool, opts pushOptions, signer *registry.Signer) error {
 	for _, repo := range repos {
 		r, err := fetch(repo)
 		if err != nil {
@@ -55,67 +70,36 @@ func cmdPush(c *cli.Context) error {
 				return cli.NewMultiError(fmt.Errorf(`failed calculating "cache hash" for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
 			}
 
-			// if the appropriate "bashbrew/cache:xxx" image exists in the containerd image store, we should prefer that (the nature of the cache hash should make this assumption safe)
-			desc, err := containerdImageLookup(cacheTag)
-			if err == nil {
-				if debugFlag {
-					fmt.Printf("Found %s (via %q) in containerd image store\n", desc.Digest, cacheTag)
-				}
-				skip, update, err := containerdPushFilter(*desc, tags)
-				if err != nil {
-					return cli.NewMultiError(fmt.Errorf(`failed looking up tags for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
-				}
-				if len(skip) > 0 && len(update) == 0 {
-					fmt.Fprintf(os.Stderr, "skipping %s (remote tags all up-to-date)\n", r.EntryIdentifier(entry))
-					continue
-				} else if len(skip) > 0 {
-					fmt.Fprintf(os.Stderr, "partially skipping %s (remote tags up-to-date: %s)\n", r.EntryIdentifier(entry), strings.Join(skip, ", "))
-				}
-				fmt.Printf("Pushing %s to %s\n", desc.Digest, strings.Join(update, ", "))
-				if !dryRun {
-					err := containerdPush(*desc, update)
-					if err != nil {
-						return cli.NewMultiError(fmt.Errorf(`failed pushing %q`, r.EntryIdentifier(entry)), err)
-					}
-				}
-				continue
+			builder, err := entryBuilder(entry)
+			if err != nil {
+				return cli.NewMultiError(fmt.Errorf(`failed looking up builder for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
+			}
+			img, err := builder.Lookup(cacheTag)
+			if err != nil {
+				return cli.NewMultiError(fmt.Errorf(`failed looking up %q`, cacheTag), err)
+			}
+			if img == nil {
+				// not built here (or the cache tag is gone), but the images might still have been tagged ("bashbrew tag") or pulled, so let the builder push whatever it can find under "tags" (see "Builder.Push")
+				img = &builtImage{Tag: cacheTag}
 			}
 
-			switch builder := entry.ArchBuilder(arch); builder {
-			case "oci-import":
-				// if after all that checking above, we still didn't push, then we must've failed to lookup
-				return cli.NewMultiError(fmt.Errorf(`failed looking up descriptor for %q (tags %q)`, r.RepoName, entry.TagsString()), err)
-
-			default:
-			TagsLoop:
-				for _, tag := range tags {
-					if !force {
-						localImageId, err := dockerInspect("{{.Id}}", tag)
-						if err != nil {
-							return cli.NewMultiError(fmt.Errorf(`failed looking up local image ID for %q`, tag), err)
-						}
-						if debugFlag {
-							fmt.Printf("DEBUG: docker inspect %q -> %q\n", tag, localImageId)
-						}
-						if localImageId == "" {
-							return fmt.Errorf("local image for %q does not seem to exist (or has an empty ID somehow)", tag)
-						}
-						registryImageIds := fetchRegistryImageIds(tag)
-						if debugFlag {
-							fmt.Printf("DEBUG: registry inspect %q -> %+v\n", tag, registryImageIds)
-						}
-						for _, registryImageId := range registryImageIds {
-							if localImageId == registryImageId {
-								fmt.Fprintf(os.Stderr, "skipping %s (remote image matches local)\n", tag)
-								continue TagsLoop
-							}
-						}
-					}
-					fmt.Printf("Pushing %s\n", tag)
-					if !dryRun {
-						err = dockerPush(tag)
-						if err != nil {
-							return cli.NewMultiError(fmt.Errorf(`failed pushing %q`, tag), err)
+			phase := metrics.Entry(*r, entry).Start("push")
+			pushed, err := builder.Push(*r, entry, img, tags, opts)
+			if err := phase.End(err); err != nil {
+				return cli.NewMultiError(fmt.Errorf(`failed pushing %q`, r.EntryIdentifier(entry)), err)
+			}
+
+			if signer != nil && len(pushed) > 0 {
+				// for images pushed from containerd, every pushed tag points to the same object in the same repository, so signing one signs them all (and signing is idempotent, so doing it for each tag of a "docker push" costs little more than a lookup)
+				signTags := pushed
+				if img.Desc != nil {
+					signTags = pushed[:1]
+				}
+				for _, tag := range signTags {
+					fmt.Printf("Signing %s\n", tag)
+					if !opts.DryRun {
+						if err := signRegistryImage(signer, tag); err != nil {
+							return cli.NewMultiError(fmt.Errorf(`failed signing %q`, tag), err)
 						}
 					}
 				}
@@ -1,27 +1,29 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
 	"reflect"
 	"strings"
 
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/urfave/cli"
 
 	"github.com/docker-library/bashbrew/architecture"
 	"github.com/docker-library/bashbrew/manifest"
+	"github.com/docker-library/bashbrew/registry"
 )
 
 var errPutShared404 = fmt.Errorf("nothing to push")
 
-func entriesToManifestToolYaml(singleArch bool, r Repo, entries ...*manifest.Manifest2822Entry) (string, []string, error) {
-	var yaml strings.Builder
+// returns the (resolved) per-architecture images that make up the index for the given entries, and the list of manifest digests we expect that index to end up with (see "registry.PutIndex")
+func entriesToIndexMembers(ctx context.Context, singleArch bool, r Repo, entries ...*manifest.Manifest2822Entry) ([]registry.ResolvedObject, []string, error) {
+	members := []registry.ResolvedObject{}
 	remoteDigests := []string{}
-	entryIdentifiers := []string{}
+	expected := 0
 	for _, entry := range entries {
-		entryIdentifiers = append(entryIdentifiers, r.EntryIdentifier(entry))
-
 		for _, entryArch := range entry.Architectures {
 			if singleArch && entryArch != arch {
 				continue
@@ -42,44 +44,45 @@ func entriesToManifestToolYaml(singleArch bool, r Repo, entries ...*manifest.Man
 			}
 
 			archImage := fmt.Sprintf("%s/%s:%s", archNamespace, r.RepoName, entry.Tags[0])
+			expected++
 
-			// keep track of how many images we expect to push successfully in this manifest list (and what their manifest digests are)
-			// for non-manifest-list tags, this will be exactly 1 and for failed lookups it'll be 0
-			// (and if one of _these_ tags is a manifest list, we've goofed somewhere)
+			// keep track of how many images we expect to push successfully in this index (and what their manifest digests are)
+			// for non-index tags, this will be exactly 1 and for failed lookups it'll be 0
+			// (and if one of _these_ tags is an index, it's probably an image with attestations, which get flattened into our index)
 			archImageDigests := fetchRegistryManiestListDigests(archImage)
 			if len(archImageDigests) != 1 {
 				fmt.Fprintf(os.Stderr, "warning: expected 1 image for %q; got %d\n", archImage, len(archImageDigests))
 			}
+			if len(archImageDigests) == 0 {
+				// (the equivalent of "manifest-tool push from-spec --ignore-missing")
+				continue
+			}
 			remoteDigests = append(remoteDigests, archImageDigests...)
 
-			fmt.Fprintf(&yaml, "  - image: %s\n", archImage)
-			fmt.Fprintf(&yaml, "    platform:\n")
-			fmt.Fprintf(&yaml, "      os: %s\n", ociArch.OS)
-			fmt.Fprintf(&yaml, "      architecture: %s\n", ociArch.Architecture)
-			if ociArch.Variant != "" {
-				fmt.Fprintf(&yaml, "      variant: %s\n", ociArch.Variant)
+			obj, err := registry.Resolve(ctx, archImage)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed resolving %q: %w", archImage, err)
 			}
+			if obj.IsImageManifest() {
+				platform := imagespec.Platform(ociArch)
+				osVersion, err := r.ArchOSVersion(entryArch, entry)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed determining OSVersion of %q: %w", archImage, err)
+				}
+				// (this is only a fallback -- "registry.PutIndex" prefers the "os.version" of the image config, which is authoritative)
+				platform.OSVersion = osVersion
+				obj.Desc.Platform = &platform
+			}
+			members = append(members, *obj)
 		}
 	}
 
-	if yaml.Len() == 0 {
-		// we're not even going to try pushing something, so let's inform the caller of that to skip the unnecessary call to "manifest-tool"
-		return "", nil, errPutShared404
+	if expected == 0 {
+		// we're not even going to try pushing something, so let's inform the caller of that to skip the unnecessary push
+		return nil, nil, errPutShared404
 	}
 
-	return "manifests:\n" + yaml.String(), remoteDigests, nil
-}
-
-func tagsToManifestToolYaml(repo string, tags ...string) string {
-	var yaml strings.Builder
-	fmt.Fprintf(&yaml, "image: %s:%s\n", repo, tags[0])
-	if len(tags) > 1 {
-		yaml.WriteString("tags:\n")
-		for _, tag := range tags[1:] {
-			fmt.Fprintf(&yaml, "  - %s\n", tag)
-		}
-	}
-	return yaml.String()
+	return members, remoteDigests, nil
 }
 
 func cmdPutShared(c *cli.Context) error {
@@ -88,10 +91,12 @@ func cmdPutShared(c *cli.Context) error {
 		return cli.NewMultiError(fmt.Errorf(`failed gathering repo list`), err)
 	}
 
-	dryRun := c.Bool("dry-run")
 	targetNamespace := c.String("target-namespace")
-	force := c.Bool("force")
 	singleArch := c.Bool("single-arch")
+	opts := pushOptions{
+		DryRun: c.Bool("dry-run"),
+		Force:  c.Bool("force"),
+	}
 
 	if targetNamespace == "" {
 		targetNamespace = namespace
@@ -100,6 +105,21 @@ func cmdPutShared(c *cli.Context) error {
 		return fmt.Errorf(`either "--target-namespace" or "--namespace" is a required flag for "put-shared"`)
 	}
 
+	var signer *registry.Signer
+	if signKey := c.String("sign-key"); signKey != "" {
+		signer, err = registry.LoadSigner(signKey)
+		if err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed loading "--sign-key"`), err)
+		}
+	}
+
+	return putSharedRepos(repos, targetNamespace, singleArch, opts, signer)
+}
+
+// puts the indexes of every entry (and SharedTags group) of the given repos into "targetNamespace", from the per-architecture images in "archNamespaces" (see "cmdPutShared")
+func putSharedRepos(repos []string, targetNamespace string, singleArch bool, opts pushOptions, signer *registry.Signer) error {
+	ctx := context.Background()
+
 	for _, repo := range repos {
 		r, err := fetch(repo)
 		if err != nil {
@@ -112,7 +132,7 @@ func cmdPutShared(c *cli.Context) error {
 
 		if !singleArch {
 			// handle all multi-architecture tags first (regardless of whether they have SharedTags)
-			// turn them into SharedTagGroup objects so all manifest-tool invocations can be handled by a single process/loop
+			// turn them into SharedTagGroup objects so all index pushes can be handled by a single loop
 			for _, entry := range r.Entries() {
 				entryCopy := *entry
 				sharedTagGroups = append(sharedTagGroups, manifest.SharedTagGroup{
@@ -135,7 +155,7 @@ func cmdPutShared(c *cli.Context) error {
 
 		failed := []string{}
 		for _, group := range sharedTagGroups {
-			yaml, expectedRemoteDigests, err := entriesToManifestToolYaml(singleArch, *r, group.Entries...)
+			members, expectedRemoteDigests, err := entriesToIndexMembers(ctx, singleArch, *r, group.Entries...)
 			if err == errPutShared404 {
 				fmt.Fprintf(os.Stderr, "skipping %s (nothing to push)\n", fmt.Sprintf("%s:%s", targetRepo, group.SharedTags[0]))
 				continue
@@ -151,7 +171,7 @@ func cmdPutShared(c *cli.Context) error {
 			tagsToPush := []string{}
 			for _, tag := range group.SharedTags {
 				image := fmt.Sprintf("%s:%s", targetRepo, tag)
-				if !force {
+				if !opts.Force {
 					remoteDigests := fetchRegistryManiestListDigests(image)
 					if len(expectedRemoteDigests) == 0 && remoteDigests == nil {
 						// https://github.com/golang/go/issues/12918 ...
@@ -163,23 +183,42 @@ func cmdPutShared(c *cli.Context) error {
 						continue
 					}
 				}
-				tagsToPush = append(tagsToPush, tag)
+				tagsToPush = append(tagsToPush, image)
 			}
 
 			if len(tagsToPush) == 0 {
 				continue
 			}
 
-			groupIdentifier := fmt.Sprintf("%s:%s", targetRepo, tagsToPush[0])
+			groupIdentifier := tagsToPush[0]
 			fmt.Printf("Putting %s\n", groupIdentifier)
-			if !dryRun {
-				tagYaml := tagsToManifestToolYaml(targetRepo, tagsToPush...) + yaml
-				if err := manifestToolPushFromSpec(tagYaml); err != nil {
+			if len(members) == 0 {
+				// an empty index is never what anyone wants (and is what "manifest-tool" would refuse to push too)
+				fmt.Fprintf(os.Stderr, "warning: failed putting %s, skipping (collecting errors)\n", groupIdentifier)
+				failed = append(failed, fmt.Sprintf("- %s: none of the expected images exist", groupIdentifier))
+				continue
+			}
+			if !opts.DryRun {
+				annotations, err := indexAnnotations(ctx, members)
+				if err == nil {
+					_, err = registry.PutIndex(ctx, tagsToPush, members, annotations)
+				}
+				if err != nil {
 					fmt.Fprintf(os.Stderr, "warning: failed putting %s, skipping (collecting errors)\n", groupIdentifier)
 					failed = append(failed, fmt.Sprintf("- %s: %v", groupIdentifier, err))
 					continue
 				}
 			}
+			if signer != nil {
+				fmt.Printf("Signing %s\n", groupIdentifier)
+				if !opts.DryRun {
+					if err := signRegistryImage(signer, groupIdentifier); err != nil {
+						fmt.Fprintf(os.Stderr, "warning: failed signing %s, skipping (collecting errors)\n", groupIdentifier)
+						failed = append(failed, fmt.Sprintf("- %s: %v", groupIdentifier, err))
+						continue
+					}
+				}
+			}
 		}
 		if len(failed) > 0 {
 			return fmt.Errorf("failed putting groups:\n%s", strings.Join(failed, "\n"))
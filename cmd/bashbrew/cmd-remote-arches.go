@@ -61,7 +61,12 @@ func cmdRemoteArches(c *cli.Context) error {
 			sort.Strings(keys)
 			for _, arch := range keys {
 				for _, obj := range arches[arch] {
-					fmt.Printf("  %s -> %s\n", arch, obj.Desc.Digest)
+					if osVersion := obj.Desc.Platform.OSVersion; osVersion != "" {
+						// so "ltsc2019" and "ltsc2022" images (both "windows-amd64") can be told apart
+						fmt.Printf("  %s -> %s (%s)\n", arch, obj.Desc.Digest, osVersion)
+					} else {
+						fmt.Printf("  %s -> %s\n", arch, obj.Desc.Digest)
+					}
 				}
 			}
 		}
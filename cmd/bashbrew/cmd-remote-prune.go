@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/docker-library/bashbrew/registry"
+
+	"github.com/urfave/cli"
+)
+
+// returns the first pattern (ala "path.Match") in the list that matches the given tag (or the empty string)
+func matchTagPattern(patterns []string, tag string) (string, error) {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, tag)
+		if err != nil {
+			return "", fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return pattern, nil
+		}
+	}
+	return "", nil
+}
+
+func cmdRemotePrune(c *cli.Context) error {
+	repos, err := repos(c.Bool("all"), c.Args()...)
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`failed gathering repo list`), err)
+	}
+
+	targetNamespace := c.String("target-namespace")
+	allow := c.StringSlice("allow")
+	deny := c.StringSlice("deny")
+	doDelete := c.Bool("delete")
+
+	if targetNamespace == "" {
+		targetNamespace = namespace
+	}
+	if targetNamespace == "" {
+		return fmt.Errorf(`either "--target-namespace" or "--namespace" is a required flag for "remote prune"`)
+	}
+
+	ctx := context.Background()
+
+	for _, repo := range repos {
+		r, err := fetch(repo)
+		if err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed fetching repo %q`, repo), err)
+		}
+		if r.TagName != "" {
+			return fmt.Errorf(`"remote prune" needs to see every tag of a repo to act safely (got %q)`, repo)
+		}
+
+		// every tag produced by *any* current entry (regardless of architecture or constraints, since other builders may be responsible for publishing them)
+		current := map[string]bool{}
+		for _, entry := range r.Entries() {
+			for _, tag := range entry.Tags {
+				current[tag] = true
+			}
+			for _, tag := range entry.SharedTags {
+				current[tag] = true
+			}
+		}
+
+		targetRepo := path.Join(targetNamespace, r.RepoName)
+		remoteTags, err := registry.ListTags(ctx, targetRepo)
+		if err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed listing tags of %q`, targetRepo), err)
+		}
+		slices.Sort(remoteTags)
+
+		failed := []error{}
+		for _, tag := range remoteTags {
+			if current[tag] {
+				continue
+			}
+			image := targetRepo + ":" + tag
+
+			if len(allow) > 0 {
+				pattern, err := matchTagPattern(allow, tag)
+				if err != nil {
+					return err
+				}
+				if pattern == "" {
+					fmt.Fprintf(os.Stderr, "keeping %s (not matched by any --allow pattern)\n", image)
+					continue
+				}
+			}
+			pattern, err := matchTagPattern(deny, tag)
+			if err != nil {
+				return err
+			}
+			if pattern != "" {
+				fmt.Fprintf(os.Stderr, "keeping %s (matched --deny pattern %q)\n", image, pattern)
+				continue
+			}
+
+			// this line is printed regardless of "--delete" so that the (mandatory) dry-run output and the real run look the same
+			fmt.Printf("Deleting %s\n", image)
+			if doDelete {
+				if err := registry.DeleteTag(ctx, image); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed deleting %s, skipping (collecting errors)\n", image)
+					failed = append(failed, fmt.Errorf(`failed deleting %q: %w`, image, err))
+				}
+			}
+		}
+		if len(failed) > 0 {
+			return cli.NewMultiError(failed...)
+		}
+	}
+
+	if !doDelete {
+		fmt.Fprintf(os.Stderr, "(dry-run; nothing was deleted -- use --delete to actually delete the tags listed above)\n")
+	}
+
+	return nil
+}
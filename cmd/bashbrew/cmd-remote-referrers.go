@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker-library/bashbrew/registry"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli"
+)
+
+func cmdRemoteReferrers(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("expected at least one argument")
+	}
+	artifactType := c.String("artifact-type")
+	doJson := c.Bool("json")
+
+	var pub crypto.PublicKey
+	if verifyKey := c.String("verify-key"); verifyKey != "" {
+		var err error
+		pub, err = registry.LoadPublicKey(verifyKey)
+		if err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed loading "--verify-key"`), err)
+		}
+	}
+
+	ctx := context.Background()
+	unverified := []string{}
+	for _, arg := range args {
+		img, err := registry.Resolve(ctx, arg)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", arg, err)
+		}
+
+		referrers, err := img.Referrers(ctx, artifactType)
+		if err != nil {
+			return fmt.Errorf("failed to query referrers of %s: %w", arg, err)
+		}
+
+		var verified []ocispec.Descriptor
+		if pub != nil {
+			verified, err = img.Signatures(ctx, pub)
+			if err != nil {
+				return fmt.Errorf("failed to verify signatures of %s: %w", arg, err)
+			}
+			if len(verified) == 0 {
+				unverified = append(unverified, img.ImageRef)
+			}
+		}
+
+		if doJson {
+			ret := struct {
+				Ref       string               `json:"ref"`
+				Desc      ocispec.Descriptor   `json:"desc"`
+				Referrers []ocispec.Descriptor `json:"referrers"`
+				Verified  []ocispec.Descriptor `json:"verified,omitempty"`
+			}{
+				Ref:       img.ImageRef,
+				Desc:      img.Desc,
+				Referrers: referrers,
+				Verified:  verified,
+			}
+			out, err := json.Marshal(ret)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		} else {
+			fmt.Printf("%s -> %s\n", img.ImageRef, img.Desc.Digest)
+			for _, desc := range referrers {
+				fmt.Printf("  %s -> %s\n", desc.ArtifactType, desc.Digest)
+			}
+			for _, desc := range verified {
+				fmt.Printf("  verified: %s\n", desc.Digest)
+			}
+		}
+	}
+
+	if len(unverified) > 0 {
+		return fmt.Errorf("no valid signatures found for:\n- %s", strings.Join(unverified, "\n- "))
+	}
+
+	return nil
+}
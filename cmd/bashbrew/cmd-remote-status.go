@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker-library/bashbrew/manifest"
+	"github.com/docker-library/bashbrew/registry"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/urfave/cli"
+)
+
+const (
+	remoteStatusUpToDate = "up-to-date"
+	remoteStatusStale    = "stale"
+	remoteStatusMissing  = "missing"
+	remoteStatusUnknown  = "unknown"
+	remoteStatusExtra    = "extra"
+)
+
+type remoteStatus struct {
+	Ref    string `json:"ref"`
+	Kind   string `json:"kind"` // "image" (what "push" would push), "index" (what "put-shared" would put), or "tag" (a tag in the registry that no entry produces)
+	Status string `json:"status"`
+
+	Expected []string `json:"expected,omitempty"`
+	Actual   []string `json:"actual,omitempty"`
+
+	// for "index" objects, the per-architecture digests we expected but did not find (Missing) and the ones we found but did not expect (Extra)
+	Missing []string `json:"missing,omitempty"`
+	Extra   []string `json:"extra,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// compares what "bashbrew push" would push for the given entry (on the current architecture) to what the registry has for the given tag
+func remoteImageStatus(ctx context.Context, r Repo, entry *manifest.Manifest2822Entry, tag string) remoteStatus {
+	ret := remoteStatus{
+		Ref:    tag,
+		Kind:   "image",
+		Status: remoteStatusUnknown,
+	}
+
+	cacheTag, err := r.DockerCacheName(entry)
+	if err != nil {
+		ret.Error = fmt.Sprintf("failed calculating cache hash: %v", err)
+		return ret
+	}
+
+	builder, err := entryBuilder(entry)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	img, err := builder.Lookup(cacheTag)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	if img == nil {
+		ret.Error = fmt.Sprintf("no local build of %q found", cacheTag)
+		return ret
+	}
+
+	// see "cmdPush" -- builds in the containerd image store get pushed as-is (so we can compare digests), builds in Docker get pushed by "docker push" (so we can only compare image IDs)
+	if desc := img.Desc; desc != nil {
+		ret.Expected = []string{desc.Digest.String()}
+		obj, err := registry.Resolve(ctx, tag)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				ret.Status = remoteStatusMissing
+			} else {
+				ret.Error = err.Error()
+			}
+			return ret
+		}
+		ret.Actual = []string{obj.Desc.Digest.String()}
+		if obj.Desc.MediaType == desc.MediaType && obj.Desc.Digest == desc.Digest && obj.Desc.Size == desc.Size {
+			ret.Status = remoteStatusUpToDate
+		} else {
+			ret.Status = remoteStatusStale
+		}
+		return ret
+	}
+
+	localImageId := img.ID
+	ret.Expected = []string{localImageId}
+
+	registryImageIds := fetchRegistryImageIds(tag)
+	if len(registryImageIds) == 0 {
+		ret.Status = remoteStatusMissing
+		return ret
+	}
+	ret.Actual = registryImageIds
+	if slices.Contains(registryImageIds, localImageId) {
+		ret.Status = remoteStatusUpToDate
+	} else {
+		ret.Status = remoteStatusStale
+	}
+	return ret
+}
+
+// compares the list of digests "bashbrew put-shared" would put in the index for the given tag ("expected") to the list of digests the registry has ("actual"; nil if the index does not exist)
+func remoteIndexStatus(image string, expected, actual []string) remoteStatus {
+	ret := remoteStatus{
+		Ref:      image,
+		Kind:     "index",
+		Status:   remoteStatusUpToDate,
+		Expected: expected,
+		Actual:   actual,
+	}
+	if ret.Actual == nil {
+		ret.Status = remoteStatusMissing
+		return ret
+	}
+	for _, digest := range expected {
+		if !slices.Contains(ret.Actual, digest) {
+			ret.Missing = append(ret.Missing, digest)
+		}
+	}
+	for _, digest := range ret.Actual {
+		if !slices.Contains(expected, digest) {
+			ret.Extra = append(ret.Extra, digest)
+		}
+	}
+	if len(ret.Missing) > 0 || len(ret.Extra) > 0 {
+		ret.Status = remoteStatusStale
+	}
+	return ret
+}
+
+// returns a status for every tag of "remoteTags" (sorted) that no entry of the given repo produces (regardless of architecture or constraints, since other builders may be responsible for publishing them; see "cmdRemotePrune")
+func remoteExtraTags(targetRepo string, m *manifest.Manifest2822, remoteTags []string) []remoteStatus {
+	current := map[string]bool{}
+	for _, entry := range m.Entries {
+		for _, tag := range entry.Tags {
+			current[tag] = true
+		}
+		for _, tag := range entry.SharedTags {
+			current[tag] = true
+		}
+	}
+
+	ret := []remoteStatus{}
+	for _, tag := range slices.Sorted(slices.Values(remoteTags)) {
+		if current[tag] {
+			continue
+		}
+		ret = append(ret, remoteStatus{
+			Ref:    targetRepo + ":" + tag,
+			Kind:   "tag",
+			Status: remoteStatusExtra,
+		})
+	}
+	return ret
+}
+
+func (s remoteStatus) details() string {
+	switch {
+	case s.Error != "":
+		return s.Error
+	case len(s.Missing) > 0 || len(s.Extra) > 0:
+		details := []string{}
+		if len(s.Missing) > 0 {
+			details = append(details, "missing "+strings.Join(s.Missing, ", "))
+		}
+		if len(s.Extra) > 0 {
+			details = append(details, "extra "+strings.Join(s.Extra, ", "))
+		}
+		return strings.Join(details, "; ")
+	case s.Status == remoteStatusStale:
+		return fmt.Sprintf("expected %s, found %s", strings.Join(s.Expected, ", "), strings.Join(s.Actual, ", "))
+	case s.Status == remoteStatusExtra:
+		return "not produced by any entry"
+	}
+	return strings.Join(s.Expected, ", ")
+}
+
+func cmdRemoteStatus(c *cli.Context) error {
+	repos, err := repos(c.Bool("all"), c.Args()...)
+	if err != nil {
+		return cli.NewMultiError(fmt.Errorf(`failed gathering repo list`), err)
+	}
+
+	targetNamespace := c.String("target-namespace")
+	putShared := c.Bool("put-shared")
+	singleArch := c.Bool("single-arch")
+	doJson := c.Bool("json")
+
+	if targetNamespace == "" {
+		targetNamespace = namespace
+	}
+	if targetNamespace == "" {
+		return fmt.Errorf(`either "--target-namespace" or "--namespace" is a required flag for "remote status"`)
+	}
+
+	if putShared && len(archNamespaces) == 0 {
+		return fmt.Errorf(`"--arch-namespace" is required for "remote status --put-shared" (see "put-shared")`)
+	}
+
+	ctx := context.Background()
+
+	var tw *tabwriter.Writer
+	if !doJson {
+		tw = tabwriter.NewWriter(os.Stdout, 1, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "REF\tKIND\tSTATUS\tDETAILS")
+	}
+	counts := map[string]int{}
+	report := func(s remoteStatus) error {
+		counts[s.Status]++
+		if doJson {
+			out, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+		_, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Ref, s.Kind, s.Status, s.details())
+		return err
+	}
+
+	for _, repo := range repos {
+		r, err := fetch(repo)
+		if err != nil {
+			return cli.NewMultiError(fmt.Errorf(`failed fetching repo %q`, repo), err)
+		}
+
+		// "push" and "put-shared" both publish to "--target-namespace" (the former the per-architecture images, the latter the indexes of the images "push" put in each "--arch-namespace")
+		targetRepo := path.Join(targetNamespace, r.RepoName)
+
+		if !putShared {
+			for _, entry := range r.Entries() {
+				if r.SkipConstraints(entry) {
+					continue
+				}
+				// we only ever push "Tags" directly (see "cmd-push.go")
+				for _, tag := range entry.Tags {
+					if err := report(remoteImageStatus(ctx, *r, entry, targetRepo+":"+tag)); err != nil {
+						return err
+					}
+				}
+			}
+		} else {
+			// see "cmdPutShared" for how these groups are built
+			sharedTagGroups := []manifest.SharedTagGroup{}
+			if !singleArch {
+				for _, entry := range r.Entries() {
+					entryCopy := *entry
+					sharedTagGroups = append(sharedTagGroups, manifest.SharedTagGroup{
+						SharedTags: entry.Tags,
+						Entries:    []*manifest.Manifest2822Entry{&entryCopy},
+					})
+				}
+			}
+			if r.TagName == "" {
+				sharedTagGroups = append(sharedTagGroups, r.Manifest.GetSharedTagGroups()...)
+			}
+			for _, group := range sharedTagGroups {
+				_, expectedRemoteDigests, err := entriesToIndexMembers(ctx, singleArch, *r, group.Entries...)
+				if err == errPutShared404 {
+					continue
+				} else if err != nil {
+					return err
+				}
+				for _, tag := range group.SharedTags {
+					image := targetRepo + ":" + tag
+					if err := report(remoteIndexStatus(image, expectedRemoteDigests, fetchRegistryManiestListDigests(image))); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		// only a full repo tells us every tag that should exist
+		if r.TagName != "" {
+			continue
+		}
+		remoteTags, err := registry.ListTags(ctx, targetRepo)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return cli.NewMultiError(fmt.Errorf(`failed listing tags of %q`, targetRepo), err)
+		}
+		for _, s := range remoteExtraTags(targetRepo, r.Manifest, remoteTags) {
+			if err := report(s); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !doJson {
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%d %s, %d %s, %d %s, %d %s, %d %s\n",
+			counts[remoteStatusUpToDate], remoteStatusUpToDate,
+			counts[remoteStatusStale], remoteStatusStale,
+			counts[remoteStatusMissing], remoteStatusMissing,
+			counts[remoteStatusUnknown], remoteStatusUnknown,
+			counts[remoteStatusExtra], remoteStatusExtra,
+		)
+	}
+
+	return nil
+}
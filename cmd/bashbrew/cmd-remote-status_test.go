@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker-library/bashbrew/manifest"
+)
+
+func TestRemoteIndexStatus(t *testing.T) {
+	tests := map[string]struct {
+		expected, actual []string
+		status           string
+		missing, extra   []string
+	}{
+		"up-to-date": {
+			expected: []string{"sha256:a", "sha256:b"},
+			actual:   []string{"sha256:b", "sha256:a"},
+			status:   remoteStatusUpToDate,
+		},
+		"missing index": {
+			expected: []string{"sha256:a"},
+			actual:   nil,
+			status:   remoteStatusMissing,
+		},
+		"empty index": {
+			expected: []string{"sha256:a"},
+			actual:   []string{},
+			status:   remoteStatusStale,
+			missing:  []string{"sha256:a"},
+		},
+		"stale": {
+			expected: []string{"sha256:a", "sha256:b"},
+			actual:   []string{"sha256:a", "sha256:c"},
+			status:   remoteStatusStale,
+			missing:  []string{"sha256:b"},
+			extra:    []string{"sha256:c"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := remoteIndexStatus("example.com/foo:bar", test.expected, test.actual)
+			if s.Status != test.status {
+				t.Errorf("expected status %q; got %q", test.status, s.Status)
+			}
+			if !reflect.DeepEqual(s.Missing, test.missing) {
+				t.Errorf("expected missing %q; got %q", test.missing, s.Missing)
+			}
+			if !reflect.DeepEqual(s.Extra, test.extra) {
+				t.Errorf("expected extra %q; got %q", test.extra, s.Extra)
+			}
+		})
+	}
+}
+
+func TestRemoteExtraTags(t *testing.T) {
+	m, err := manifest.Parse(strings.NewReader(`Maintainers: Foo <bar@example.com> (@baz)
+GitRepo: https://github.com/docker-library/foo.git
+GitCommit: 0123456789abcdef0123456789abcdef01234567
+
+Tags: 1.0.1, 1.0, 1
+SharedTags: latest
+
+Tags: 1.0.1-alpine, 1.0-alpine
+Architectures: arm64v8
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extra := remoteExtraTags("example.com/foo", m, []string{"old", "1", "latest", "1.0-alpine", "0.9", "1.0.1", "1-alpine"})
+	refs := []string{}
+	for _, s := range extra {
+		if s.Kind != "tag" || s.Status != remoteStatusExtra {
+			t.Errorf("unexpected kind/status for %q: %q/%q", s.Ref, s.Kind, s.Status)
+		}
+		refs = append(refs, s.Ref)
+	}
+	if expected := []string{"example.com/foo:0.9", "example.com/foo:1-alpine", "example.com/foo:old"}; !reflect.DeepEqual(refs, expected) {
+		t.Errorf("expected %q; got %q", expected, refs)
+	}
+
+	if extra := remoteExtraTags("example.com/foo", m, []string{"1", "latest"}); len(extra) != 0 {
+		t.Errorf("expected no extra tags; got %+v", extra)
+	}
+}
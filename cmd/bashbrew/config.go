@@ -3,176 +3,401 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"slices"
+	"sort"
 	"strings"
+	"unicode"
 
 	"github.com/docker-library/bashbrew/pkg/stripper"
 	"github.com/urfave/cli"
 	"pault.ag/go/debian/control"
 )
 
+// the current version of the "flags" file format ("Version: 1"); files without a "Version" get the old leniency (unknown keys and invalid values are only warnings, since they used to be silently ignored)
+const flagsConfigVersion = 1
+
+// a single paragraph of the "flags" file: "Commands" and "Repos" limit which invocations it applies to, and every other field is the value of a flag (see "flagsConfigKeyFlag")
 type FlagsConfigEntry struct {
 	control.Paragraph
 
 	Commands []string `delim:"," strip:"\n\r\t "`
+	Repos    []string `delim:"," strip:"\n\r\t "`
+
+	Version int
 
-	Library    string
-	Cache      string
-	Debug      string
-	Unique     string
-	BuildOrder string
-	Pull       string
+	// flag name => raw value (see "FlagsConfig.Resolve")
+	Flags map[string]string `control:"-"`
+}
 
-	Arch                 string
-	Namespace            string
-	Constraints          []string `delim:"," strip:"\n\r\t "`
-	ExclusiveConstraints string
-	ApplyConstraints     string
+type FlagsConfig struct {
+	File    string
+	Version int
+	Entries []FlagsConfigEntry
 
-	// a list of "arch=namespace" mappings for pushing indexes (manifest lists)
-	ArchNamespaces []string `delim:"," strip:"\n\r\t "`
+	// flag name => definition, for every flag any entry sets (see "Resolve")
+	flags map[string]flagsConfigFlag
 }
 
-type FlagsConfig map[string]FlagsConfigEntry
+// a flag that can be set from the "flags" file
+type flagsConfigFlag struct {
+	Name   string
+	Key    string // the canonical "flags" file key (see "flagsConfigFlagKey")
+	Flag   cli.Flag
+	Global bool
+}
 
-func (dst *FlagsConfigEntry) Apply(src FlagsConfigEntry) {
-	if src.Library != "" {
-		dst.Library = src.Library
-	}
-	if src.Cache != "" {
-		dst.Cache = src.Cache
+// a resolved value (see "FlagsConfig.Lookup" and "cmdConfigShow")
+type flagsConfigValue struct {
+	Value  string
+	Source string
+}
+
+// "flags" file keys whose names don't match their flags (mostly plurals of list flags, from before keys were derived from flag names)
+var flagsConfigKeyAliases = map[string]string{
+	"Unique":            "uniq",
+	"Constraints":       "constraint",
+	"CustomArches":      "custom-arch",
+	"ArchNamespaces":    "arch-namespace",
+	"ArchDockerHosts":   "arch-docker-host",
+	"ArchBuildkitHosts": "arch-buildkit-host",
+}
+
+// flags which make no sense in the "flags" file
+var flagsConfigExcludedFlags = map[string]bool{
+	"config":  true,
+	"help":    true,
+	"version": true,
+
+	"generate-bash-completion": true,
+
+	// (these are read before any command's flags, so the "flags" file is too late)
+	"metrics":            true,
+	"metrics-prometheus": true,
+	"metrics-otlp":       true,
+}
+
+// "TargetNamespace" => "target-namespace"
+func flagsConfigKeyFlag(key string) string {
+	if name, ok := flagsConfigKeyAliases[key]; ok {
+		return name
 	}
-	if src.Debug != "" {
-		dst.Debug = src.Debug
+	ret := &strings.Builder{}
+	for i, r := range key {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				ret.WriteRune('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		ret.WriteRune(r)
 	}
-	if src.Unique != "" {
-		dst.Unique = src.Unique
+	return ret.String()
+}
+
+// "target-namespace" => "TargetNamespace" (the inverse of "flagsConfigKeyFlag")
+func flagsConfigFlagKey(name string) string {
+	for key, alias := range flagsConfigKeyAliases {
+		if alias == name {
+			return key
+		}
 	}
-	if src.BuildOrder != "" {
-		dst.BuildOrder = src.BuildOrder
+	ret := &strings.Builder{}
+	for bit := range strings.SplitSeq(name, "-") {
+		if bit != "" {
+			ret.WriteString(strings.ToUpper(bit[:1]) + bit[1:])
+		}
 	}
-	if src.Pull != "" {
-		dst.Pull = src.Pull
+	return ret.String()
+}
+
+// returns the flag with the given name (or alias) from the given list, or nil
+func lookupFlag(flags []cli.Flag, name string) cli.Flag {
+	for _, f := range flags {
+		for fName := range strings.SplitSeq(f.GetName(), ",") {
+			if strings.TrimSpace(fName) == name {
+				return f
+			}
+		}
 	}
-	if src.Arch != "" {
-		dst.Arch = src.Arch
+	return nil
+}
+
+// the canonical name of the given flag (the first of "uniq, unique")
+func flagName(f cli.Flag) string {
+	name, _, _ := strings.Cut(f.GetName(), ",")
+	return strings.TrimSpace(name)
+}
+
+// returns the definition of the flag the given "flags" file key (or flag name) refers to: a global flag, or a flag of any (top-level) command
+func flagsConfigLookupKey(app *cli.App, key string) (flagsConfigFlag, bool) {
+	name := key
+	if !strings.Contains(key, "-") && strings.ToLower(key) != key {
+		name = flagsConfigKeyFlag(key)
 	}
-	if src.Namespace != "" {
-		dst.Namespace = src.Namespace
+	if flagsConfigExcludedFlags[name] {
+		return flagsConfigFlag{}, false
 	}
-	if len(src.Constraints) > 0 {
-		dst.Constraints = src.Constraints[:]
+	if f := lookupFlag(app.Flags, name); f != nil {
+		return flagsConfigFlag{Name: flagName(f), Key: flagsConfigFlagKey(flagName(f)), Flag: f, Global: true}, true
 	}
-	if src.ExclusiveConstraints != "" {
-		dst.ExclusiveConstraints = src.ExclusiveConstraints
+	for _, cmd := range app.Commands {
+		if f := lookupFlag(cmd.Flags, name); f != nil {
+			return flagsConfigFlag{Name: flagName(f), Key: flagsConfigFlagKey(flagName(f)), Flag: f}, true
+		}
 	}
-	if src.ApplyConstraints != "" {
-		dst.ApplyConstraints = src.ApplyConstraints
+	return flagsConfigFlag{}, false
+}
+
+// splits a raw "flags" file value into the individual values to "Set" on the flag (lists are comma-separated)
+func (f flagsConfigFlag) values(raw string) []string {
+	switch f.Flag.(type) {
+	case cli.StringSliceFlag, cli.IntSliceFlag, cli.Int64SliceFlag:
+		ret := []string{}
+		for val := range strings.SplitSeq(raw, ",") {
+			if val = strings.Trim(val, "\n\r\t "); val != "" {
+				ret = append(ret, val)
+			}
+		}
+		return ret
 	}
-	if len(src.ArchNamespaces) > 0 {
-		dst.ArchNamespaces = src.ArchNamespaces[:]
+	if raw = strings.TrimSpace(raw); raw == "" {
+		return nil
 	}
+	return []string{raw}
 }
 
-func (config FlagsConfigEntry) Vars() map[string]map[string]any {
-	return map[string]map[string]any{
-		"global": {
-			"library": config.Library,
-			"cache":   config.Cache,
-			"debug":   config.Debug,
+// a scratch "flag.FlagSet" with just the given flag (for validating values and getting defaults)
+func (f flagsConfigFlag) flagSet() *flag.FlagSet {
+	set := flag.NewFlagSet(f.Name, flag.ContinueOnError)
+	set.SetOutput(io.Discard)
+	f.Flag.Apply(set)
+	return set
+}
 
-			"arch":                  config.Arch,
-			"namespace":             config.Namespace,
-			"constraint":            config.Constraints,
-			"exclusive-constraints": config.ExclusiveConstraints,
+// returns an error if the given raw value isn't valid for the flag ("maybe" for a boolean, for example)
+func (f flagsConfigFlag) validate(raw string) error {
+	set := f.flagSet()
+	for _, val := range f.values(raw) {
+		if err := set.Set(f.Name, val); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %w", val, f.Key, err)
+		}
+	}
+	return nil
+}
 
-			"arch-namespace": config.ArchNamespaces,
-		},
+// formats the value of a flag the way the "flags" file would
+func formatFlagValue(val flag.Value) string {
+	getter, ok := val.(flag.Getter)
+	if !ok {
+		return val.String()
+	}
+	switch v := getter.Get().(type) {
+	case cli.StringSlice:
+		return strings.Join(v, ", ")
+	case cli.IntSlice:
+		strs := []string{}
+		for _, i := range v {
+			strs = append(strs, fmt.Sprint(i))
+		}
+		return strings.Join(strs, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// the default value of the flag (ignoring the environment)
+func (f flagsConfigFlag) defaultValue() string {
+	return formatFlagValue(f.flagSet().Lookup(f.Name).Value)
+}
 
-		"local": {
-			"uniq":        config.Unique,
-			"build-order": config.BuildOrder,
-			"pull":        config.Pull,
+// a human-readable description of where the entry lives and what it applies to
+func (entry FlagsConfigEntry) scope() string {
+	ret := []string{}
+	if len(entry.Commands) > 0 {
+		ret = append(ret, "Commands: "+strings.Join(entry.Commands, ", "))
+	}
+	if len(entry.Repos) > 0 {
+		ret = append(ret, "Repos: "+strings.Join(entry.Repos, ", "))
+	}
+	return strings.Join(ret, "; ")
+}
 
-			"apply-constraints": config.ApplyConstraints,
-		},
+// whether the entry applies to the given command and repos, and how specifically (entries for both a command and repos win over entries for repos, which win over entries for a command, which win over entries for everything; see "FlagsConfig.Lookup")
+func (entry FlagsConfigEntry) matches(cmd string, repos []string) (int, bool) {
+	specificity := 0
+	if len(entry.Commands) > 0 {
+		if !slices.Contains(entry.Commands, cmd) {
+			return 0, false
+		}
+		specificity += 1
 	}
+	if len(entry.Repos) > 0 {
+		if len(repos) == 0 {
+			return 0, false
+		}
+		for _, repo := range repos {
+			if !slices.ContainsFunc(entry.Repos, func(pattern string) bool {
+				matched, _ := path.Match(pattern, repo)
+				return matched || pattern == repo
+			}) {
+				return 0, false
+			}
+		}
+		specificity += 2
+	}
+	return specificity, true
 }
 
-func (config FlagsConfig) ApplyTo(cmd string, c *cli.Context) error {
-	entry := config[""]
-	if cmd != "" {
-		entry.Apply(config[cmd])
-	}
-	vars := entry.Vars()
-	if cmd == "" {
-		vars["local"] = vars["global"]
-		vars["global"] = nil
-	}
-	for key, val := range vars["global"] {
-		if !c.GlobalIsSet(key) && !isEnvVarSet(flagEnvVars[key]) {
-			switch val.(type) {
-			case string:
-				strVal := val.(string)
-				if strVal == "" {
-					continue
-				}
-				c.GlobalSet(key, strVal)
-			case []string:
-				strSlice := val.([]string)
-				if len(strSlice) == 0 {
-					continue
-				}
-				for _, valBit := range strSlice {
-					c.GlobalSet(key, valBit)
-				}
-			default:
-				return fmt.Errorf(`unknown value type %T (%s: %+v)`, val, key, val)
+// the repo names the given command arguments refer to (for matching "Repos"; see "FlagsConfigEntry.matches")
+func flagsConfigRepos(args []string) []string {
+	ret := []string{}
+	for _, arg := range args {
+		repo := arg
+		if isSelector(arg) {
+			s, err := parseSelector(arg)
+			if err != nil || s.repo == "" {
+				continue
 			}
+			repo = s.repo
+		} else if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") && !strings.HasSuffix(repo[:i], ":/") {
+			// see "manifest.Fetch"
+			repo = repo[:i]
 		}
+		ret = append(ret, path.Base(repo))
+	}
+	return ret
+}
+
+// resolves every key of every entry to a flag (see "flagsConfigLookupKey") and validates values, commands, etc; problems are errors for versioned files and warnings otherwise
+func (config *FlagsConfig) Resolve(app *cli.App) error {
+	if config.Version > flagsConfigVersion {
+		return fmt.Errorf("%q is Version %d, but this bashbrew only supports up to Version %d", config.File, config.Version, flagsConfigVersion)
 	}
-	for key, val := range vars["local"] {
-		if !c.IsSet(key) && !isEnvVarSet(flagEnvVars[key]) {
-			switch val.(type) {
-			case string:
-				strVal := val.(string)
-				if strVal == "" {
-					continue
-				}
-				c.Set(key, strVal)
-			case []string:
-				strSlice := val.([]string)
-				if len(strSlice) == 0 {
-					continue
-				}
-				for _, valBit := range strSlice {
-					c.Set(key, valBit)
-				}
-			default:
-				return fmt.Errorf(`unknown value type %T (%s: %+v)`, val, key, val)
+
+	problems := []string{}
+	config.flags = map[string]flagsConfigFlag{}
+	for i := range config.Entries {
+		entry := &config.Entries[i]
+		where := fmt.Sprintf("paragraph %d", i+1)
+		if scope := entry.scope(); scope != "" {
+			where += " (" + scope + ")"
+		}
+
+		cmds := []*cli.Command{}
+		for j, cmdName := range entry.Commands {
+			cmd := app.Command(cmdName)
+			if cmd == nil {
+				problems = append(problems, fmt.Sprintf("%s: unknown command %q", where, cmdName))
+				continue
+			}
+			entry.Commands[j] = cmd.Name // "ls" => "list"
+			cmds = append(cmds, cmd)
+		}
+		if entry.Version != 0 && (len(entry.Commands) > 0 || len(entry.Repos) > 0) {
+			problems = append(problems, fmt.Sprintf("%s: Version only makes sense in a paragraph without Commands or Repos", where))
+		}
+
+		entry.Flags = map[string]string{}
+		for _, key := range entry.Paragraph.Order {
+			switch key {
+			case "Commands", "Repos", "Version":
+				continue
+			}
+			f, ok := flagsConfigLookupKey(app, key)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown key %q", where, key))
+				continue
+			}
+			if !f.Global && len(cmds) > 0 && !slices.ContainsFunc(cmds, func(cmd *cli.Command) bool {
+				return lookupFlag(cmd.Flags, f.Name) != nil
+			}) {
+				problems = append(problems, fmt.Sprintf("%s: %s is not a flag of any of %q", where, key, entry.Commands))
+				continue
+			}
+			val := entry.Paragraph.Values[key]
+			if err := f.validate(val); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", where, err))
+				continue
 			}
+			entry.Flags[f.Name] = val
+			config.flags[f.Name] = f
 		}
 	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	if config.Version > 0 {
+		return fmt.Errorf("invalid %q:\n- %s", config.File, strings.Join(problems, "\n- "))
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "warning: %q %s (ignoring; this will be an error with \"Version: %d\")\n", config.File, problem, flagsConfigVersion)
+	}
 	return nil
 }
 
-// https://github.com/urfave/cli/blob/73aa67b7a20db7514b1c086866469c49bc93a569/altsrc/flag.go#L237-L251
-func isEnvVarSet(envVars string) bool {
-	for envVar := range strings.SplitSeq(envVars, ",") {
-		envVar = strings.TrimSpace(envVar)
-		if envVal := os.Getenv(envVar); envVal != "" {
-			// TODO: Can't use this for bools as
-			// set means that it was true or false based on
-			// Bool flag type, should work for other types
-			if len(envVal) > 0 {
-				return true
-			}
+// returns the merged values (flag name => value) of every entry which applies to the given command and repos (see "FlagsConfigEntry.matches")
+func (config FlagsConfig) Lookup(cmd string, repos []string) map[string]flagsConfigValue {
+	type match struct {
+		specificity int
+		index       int
+	}
+	matches := []match{}
+	for i, entry := range config.Entries {
+		if specificity, ok := entry.matches(cmd, repos); ok {
+			matches = append(matches, match{specificity, i})
 		}
 	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].specificity < matches[j].specificity
+	})
+
+	ret := map[string]flagsConfigValue{}
+	for _, m := range matches {
+		entry := config.Entries[m.index]
+		source := fmt.Sprintf("%s (paragraph %d", config.File, m.index+1)
+		if scope := entry.scope(); scope != "" {
+			source += "; " + scope
+		}
+		source += ")"
+		for name, val := range entry.Flags {
+			ret[name] = flagsConfigValue{Value: val, Source: source}
+		}
+	}
+	return ret
+}
 
-	return false
+// sets any flags of the given command which weren't set explicitly (on the command line or via the environment) to their values from the config
+func (config FlagsConfig) ApplyTo(cmd string, c *cli.Context) error {
+	for name, val := range config.Lookup(cmd, flagsConfigRepos(c.Args())) {
+		f := config.flags[name]
+
+		var set func(name, value string) error
+		if f.Global {
+			if c.GlobalIsSet(name) {
+				continue
+			}
+			set = c.GlobalSet
+		} else {
+			if lookupFlag(c.Command.Flags, name) == nil || c.IsSet(name) {
+				// (a flag of some other command, from a paragraph without "Commands")
+				continue
+			}
+			set = c.Set
+		}
+
+		for _, v := range f.values(val.Value) {
+			if err := set(name, v); err != nil {
+				return fmt.Errorf("failed applying %s from %s: %w", f.Key, val.Source, err)
+			}
+		}
+	}
+	return nil
 }
 
 func (ce FlagsConfigEntry) String() string {
@@ -193,6 +418,7 @@ func NewFlagsConfig() *FlagsConfig {
 
 func ParseFlagsConfigFile(file string) (*FlagsConfig, error) {
 	config := NewFlagsConfig()
+	config.File = file
 	return config, config.ParseFile(file)
 }
 
@@ -229,15 +455,13 @@ func (c *FlagsConfig) Parse(readerIn io.Reader) error {
 			return err
 		}
 
-		if len(entry.Commands) == 0 {
-			entry.Commands = []string{""}
+		// ignore empty paragraphs (blank lines at the start, excess blank lines between paragraphs, excess blank lines at EOF)
+		if len(entry.Paragraph.Order) == 0 {
+			continue
 		}
 
-		for _, command := range entry.Commands {
-			targetEntry := (*c)[command]
-			targetEntry.Apply(entry)
-			(*c)[command] = targetEntry
-		}
+		c.Version = max(c.Version, entry.Version)
+		c.Entries = append(c.Entries, entry)
 	}
 
 	return nil
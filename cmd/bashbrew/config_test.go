@@ -0,0 +1,281 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// a small stand-in for "rootApp" with a few of each kind of flag
+func testFlagsConfigApp() *cli.App {
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "namespace"},
+		cli.StringFlag{Name: "target-namespace"},
+		cli.StringSliceFlag{Name: "constraint"},
+		cli.StringSliceFlag{Name: "arch-namespace"},
+		cli.BoolFlag{Name: "debug"},
+		cli.StringFlag{Name: "config"},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:    "list",
+			Aliases: []string{"ls"},
+			Flags:   []cli.Flag{cli.BoolFlag{Name: "uniq, unique"}},
+		},
+		{
+			Name:  "build",
+			Flags: []cli.Flag{cli.BoolFlag{Name: "uniq, unique"}, cli.StringFlag{Name: "pull", Value: "missing"}},
+		},
+		{
+			Name:  "push",
+			Flags: []cli.Flag{cli.BoolFlag{Name: "dry-run"}},
+		},
+	}
+	return app
+}
+
+func TestSetFlagsConfigValue(t *testing.T) {
+	app := testFlagsConfigApp()
+
+	tests := []struct {
+		name     string
+		contents string
+		commands []string
+		repos    []string
+		key      string
+		value    string
+		expected string
+	}{
+		{
+			name:     "insert",
+			contents: "Version: 1\n# where we pull from\nNamespace: foo\n",
+			key:      "target-namespace",
+			value:    "bar",
+			expected: "Version: 1\n# where we pull from\nNamespace: foo\nTargetNamespace: bar\n",
+		},
+		{
+			name:     "replace in place",
+			contents: "Version: 1\n\n# for pushing\nCommands: push\nNamespace: foo\nDryRun: true\n",
+			commands: []string{"push"},
+			key:      "Namespace",
+			value:    "bar",
+			expected: "Version: 1\n\n# for pushing\nCommands: push\nNamespace: bar\nDryRun: true\n",
+		},
+		{
+			name:     "remove",
+			contents: "Version: 1\n\nCommands: push\n# bye\nDryRun: true\nNamespace: foo\n",
+			commands: []string{"push"},
+			key:      "dry-run",
+			value:    "",
+			expected: "Version: 1\n\nCommands: push\n# bye\nNamespace: foo\n",
+		},
+		{
+			name:     "remove multi-line value",
+			contents: "Constraints: a,\n b,\n\tc\n# still here\nNamespace: foo\n",
+			key:      "Constraints",
+			value:    "",
+			expected: "# still here\nNamespace: foo\n",
+		},
+		{
+			name:     "remove missing",
+			contents: "Version: 1\nNamespace: foo\n",
+			key:      "TargetNamespace",
+			value:    "",
+			expected: "Version: 1\nNamespace: foo\n",
+		},
+		{
+			name:     "several matching paragraphs",
+			contents: "Version: 1\nNamespace: a\n\nCommands: push\nNamespace: b\n\nArchNamespaces: amd64 = x\nNamespace: c\n",
+			key:      "namespace",
+			value:    "d",
+			// (removed from all of them, so that an earlier paragraph can't shadow the new value, and the new value goes where the last one was)
+			expected: "Version: 1\n\nCommands: push\nNamespace: b\n\nArchNamespaces: amd64 = x\nNamespace: d\n",
+		},
+		{
+			name:     "new paragraph",
+			contents: "Version: 1\nNamespace: foo\n",
+			commands: []string{"list"},
+			repos:    []string{"python"},
+			key:      "uniq",
+			value:    "true",
+			expected: "Version: 1\nNamespace: foo\n\nCommands: list\nRepos: python\nUnique: true\n",
+		},
+		{
+			name:     "aliases and order",
+			contents: "Commands: ls, build\nRepos: python, pypy\nUniq: false\n\nCommands: list\nUniq: false\n",
+			commands: []string{"build", "list"},
+			repos:    []string{"pypy", "python"},
+			key:      "Unique",
+			value:    "true",
+			expected: "Commands: ls, build\nRepos: python, pypy\nUnique: true\n\nCommands: list\nUniq: false\n",
+		},
+		{
+			name:     "repos are not commands",
+			contents: "Repos: python\nNamespace: foo\n",
+			key:      "namespace",
+			value:    "bar",
+			expected: "Repos: python\nNamespace: foo\n\nNamespace: bar\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, ok := flagsConfigLookupKey(app, test.key)
+			if !ok {
+				t.Fatalf("unknown key %q", test.key)
+			}
+			got := setFlagsConfigValue(app, test.contents, test.commands, test.repos, f, test.value)
+			if got != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, got)
+			}
+
+			// and whatever we write has to be readable
+			config, err := ParseFlagsConfig(strings.NewReader(got))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.File = "flags"
+			if err := config.Resolve(app); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestFlagsConfigResolve(t *testing.T) {
+	app := testFlagsConfigApp()
+
+	problems := map[string]string{
+		"unknown key":         "Colour: blue\n",
+		"invalid value":       "Debug: maybe\n",
+		"unknown command":     "Commands: frobnicate\nNamespace: foo\n",
+		"not a flag of":       "Commands: list\nDryRun: true\n",
+		"excluded flag":       "Config: /etc/bashbrew\n",
+		"problems everywhere": "Namespace: foo\n\nRepos: python\nColour: blue\n",
+	}
+	for name, contents := range problems {
+		t.Run(name, func(t *testing.T) {
+			// without "Version", problems are only warnings (and the problematic keys are ignored)
+			config, err := ParseFlagsConfig(strings.NewReader(contents))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.File = "flags"
+			if err := config.Resolve(app); err != nil {
+				t.Errorf("unversioned: unexpected error: %v", err)
+			}
+			for _, entry := range config.Entries {
+				for name := range entry.Flags {
+					if name != "namespace" {
+						t.Errorf("unversioned: %q should've been ignored", name)
+					}
+				}
+			}
+
+			// with "Version: 1", they're errors
+			config, err = ParseFlagsConfig(strings.NewReader("Version: 1\n\n" + contents))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.File = "flags"
+			if err := config.Resolve(app); err == nil {
+				t.Errorf("versioned: expected error")
+			}
+		})
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		config, err := ParseFlagsConfig(strings.NewReader("Version: 1\nNamespace: foo\nConstraints: a, b\n\nCommands: ls, push\nUnique: true\nDryRun: true\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.File = "flags"
+		if err := config.Resolve(app); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := strings.Join(config.Entries[1].Commands, ", "); got != "list, push" {
+			t.Errorf("expected aliases to be resolved; got %q", got)
+		}
+		if got := config.Entries[1].Flags["uniq"]; got != "true" {
+			t.Errorf("expected uniq to be %q; got %q", "true", got)
+		}
+	})
+
+	t.Run("misplaced version", func(t *testing.T) {
+		// (which still makes the whole file versioned)
+		config, err := ParseFlagsConfig(strings.NewReader("Namespace: foo\n\nCommands: list\nVersion: 1\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.File = "flags"
+		if err := config.Resolve(app); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	t.Run("future version", func(t *testing.T) {
+		config, err := ParseFlagsConfig(strings.NewReader("Version: 2\nNamespace: foo\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.File = "flags"
+		if err := config.Resolve(app); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
+
+func TestFlagsConfigLookup(t *testing.T) {
+	app := testFlagsConfigApp()
+	config, err := ParseFlagsConfig(strings.NewReader(`Version: 1
+Namespace: everything
+
+Repos: python, py*
+Namespace: repos
+
+Commands: push, build
+Namespace: commands
+
+Commands: push
+Repos: python
+Namespace: both
+
+# (a later paragraph of the same specificity wins)
+Commands: build
+Namespace: later
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.File = "flags"
+	if err := config.Resolve(app); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cmd      string
+		repos    []string
+		expected string
+		source   string
+	}{
+		{"list", nil, "everything", "flags (paragraph 1)"},
+		{"list", []string{"python"}, "repos", "flags (paragraph 2; Repos: python, py*)"},
+		{"list", []string{"pypy"}, "repos", "flags (paragraph 2; Repos: python, py*)"},
+		{"list", []string{"python", "golang"}, "everything", "flags (paragraph 1)"}, // (every repo has to match)
+		{"push", nil, "commands", "flags (paragraph 3; Commands: push, build)"},
+		{"push", []string{"golang"}, "commands", "flags (paragraph 3; Commands: push, build)"},
+		{"push", []string{"pypy"}, "repos", "flags (paragraph 2; Repos: python, py*)"}, // (repos are more specific than commands)
+		{"push", []string{"python"}, "both", "flags (paragraph 4; Commands: push; Repos: python)"},
+		{"build", nil, "later", "flags (paragraph 5; Commands: build)"},
+		{"build", []string{"python"}, "repos", "flags (paragraph 2; Repos: python, py*)"},
+	}
+	for _, test := range tests {
+		t.Run(test.cmd+" "+strings.Join(test.repos, " "), func(t *testing.T) {
+			got := config.Lookup(test.cmd, test.repos)["namespace"]
+			if got.Value != test.expected || got.Source != test.source {
+				t.Errorf("expected %q (%s); got %q (%s)", test.expected, test.source, got.Value, got.Source)
+			}
+		})
+	}
+}
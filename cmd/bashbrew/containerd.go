@@ -43,7 +43,8 @@ func newBuiltinContainerdServices(ctx context.Context) (containerd.ClientOpt, er
 	), nil
 }
 
-var containerdClientCache *containerd.Client = nil
+// arch => client (each architecture gets a separate store; see "newBuiltinContainerdServices")
+var containerdClientCache = map[string]*containerd.Client{}
 
 // the returned client is cached, don't Close() it!
 func newContainerdClient(ctx context.Context) (context.Context, *containerd.Client, error) {
@@ -62,8 +63,8 @@ func newContainerdClient(ctx context.Context) (context.Context, *containerd.Clie
 	}
 	ctx = namespaces.WithNamespace(ctx, ns)
 
-	if containerdClientCache != nil {
-		return ctx, containerdClientCache, nil
+	if client, ok := containerdClientCache[arch]; ok {
+		return ctx, client, nil
 	}
 
 	opts := []containerd.ClientOpt{
@@ -83,7 +84,7 @@ func newContainerdClient(ctx context.Context) (context.Context, *containerd.Clie
 				break
 			}
 			client, err := containerd.New(socket, opts...)
-			containerdClientCache = client
+			containerdClientCache[arch] = client
 			return ctx, client, err
 		}
 	}
@@ -96,6 +97,6 @@ func newContainerdClient(ctx context.Context) (context.Context, *containerd.Clie
 	opts = append(opts, services)
 
 	client, err := containerd.New("", opts...)
-	containerdClientCache = client
+	containerdClientCache[arch] = client
 	return ctx, client, err
 }
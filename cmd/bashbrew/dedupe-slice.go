@@ -17,29 +17,3 @@ func (s *dedupeSlice[T]) add(i T) bool {
 func (s dedupeSlice[T]) slice() []T {
 	return s.s
 }
-
-type dedupeSliceMap[K comparable, V comparable] struct {
-	m map[K]*dedupeSlice[V]
-}
-
-func (m *dedupeSliceMap[K, V]) add(key K, value V) bool {
-	if m.m == nil {
-		m.m = map[K]*dedupeSlice[V]{}
-	}
-	if s, ok := m.m[key]; !ok || s == nil {
-		m.m[key] = &dedupeSlice[V]{}
-	}
-	return m.m[key].add(value)
-}
-
-func (m dedupeSliceMap[K, V]) has(key K) bool {
-	s, ok := m.m[key]
-	return ok && s != nil
-}
-
-func (m dedupeSliceMap[K, V]) slice(key K) []V {
-	if !m.has(key) {
-		return nil
-	}
-	return m.m[key].slice()
-}
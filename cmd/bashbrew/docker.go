@@ -2,18 +2,29 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding/csv"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/docker-library/bashbrew/architecture"
 	"github.com/docker-library/bashbrew/manifest"
 	"github.com/docker-library/bashbrew/pkg/dockerfile"
+	"github.com/docker-library/bashbrew/registry"
+
+	"github.com/containerd/containerd/errdefs"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/urfave/cli"
 )
 
@@ -26,6 +37,43 @@ func (r Repo) ArchLastStageFrom(arch string, entry *manifest.Manifest2822Entry)
 	return dockerfileMeta.StageFroms[len(dockerfileMeta.StageFroms)-1], nil
 }
 
+// returns the Windows "os.version" of the given entry's images on the given architecture: what the entry declares ("OSVersion"), what the architecture itself declares (see "architecture.Register"), or what the last stage's FROM implies (ala "mcr.microsoft.com/windows/servercore:ltsc2022"; see "architecture.WindowsVersion"), or "" for non-Windows architectures (or if we can't tell) -- this is only a guess for images whose config doesn't say (which "registry.PutIndex" prefers whenever it does)
+func (r Repo) ArchOSVersion(arch string, entry *manifest.Manifest2822Entry) (string, error) {
+	if v := entry.ArchOSVersion(arch); v != "" {
+		return v, nil
+	}
+	ociArch := architecture.SupportedArches[arch]
+	if ociArch.OSVersion != "" || ociArch.OS != "windows" {
+		return ociArch.OSVersion, nil
+	}
+	from, err := r.ArchLastStageFrom(arch, entry)
+	if err != nil {
+		return "", err
+	}
+	return architecture.WindowsVersion(from), nil
+}
+
+// returns the names of the named stages other than the last one (in order), which "build --cache-stages" builds and tags individually (see "dockerCacheStageTag")
+func (r Repo) ArchIntermediateStages(arch string, entry *manifest.Manifest2822Entry) ([]string, error) {
+	dockerfileMeta, err := r.archDockerfileMetadata(arch, entry)
+	if err != nil {
+		return nil, err
+	}
+	stages := []string{}
+	for _, name := range dockerfileMeta.StageNames {
+		if dockerfileMeta.StageNumbers[name] < len(dockerfileMeta.StageFroms)-1 {
+			stages = append(stages, name)
+		}
+	}
+	return stages, nil
+}
+
+// returns the tag for the given intermediate stage of the image with the given "cache tag" ("bashbrew/cache:<hash>-<stage>")
+func dockerCacheStageTag(cacheTag string, stage string) string {
+	// stage names are case-insensitive, but tags can't be uppercase
+	return cacheTag + "-" + strings.ToLower(stage)
+}
+
 func (r Repo) DockerFroms(entry *manifest.Manifest2822Entry) ([]string, error) {
 	return r.ArchDockerFroms(arch, entry)
 }
@@ -101,13 +149,19 @@ func (r Repo) dockerCacheHash(entry *manifest.Manifest2822Entry) (string, error)
 	return hex.EncodeToString(b[:]), nil
 }
 
+// the error of a "docker inspect" of something that doesn't exist wraps "errdefs.ErrNotFound" (so it can be told apart from Docker being unreachable, for example)
 func dockerInspect(format string, args ...string) (string, error) {
 	args = append([]string{"inspect", "-f", format}, args...)
 	out, err := exec.Command("docker", args...).Output()
 	if err != nil {
 		if ee, ok := err.(*exec.ExitError); ok {
-			return "", fmt.Errorf("%v\ncommand: docker inspect -f %q %q\n%s", ee, format, args, string(ee.Stderr))
+			stderr := string(ee.Stderr)
+			err = fmt.Errorf("%v\ncommand: docker inspect -f %q %q\n%s", ee, format, args, stderr)
+			if strings.Contains(stderr, "No such object") || strings.Contains(stderr, "No such image") {
+				err = fmt.Errorf("%w: %w", errdefs.ErrNotFound, err)
+			}
 		}
+		return "", err
 	}
 	return strings.TrimSpace(string(out)), nil
 }
@@ -147,11 +201,54 @@ func (r Repo) dockerBuildUniqueBits(entry *manifest.Manifest2822Entry) ([]string
 	return uniqueBits, nil
 }
 
-func dockerBuild(tags []string, file string, context io.Reader, platform string) error {
+// returns "--flag key=val" arguments for each entry of the given map (sorted, for stable command lines)
+func dockerKeyValArgs(flag string, m map[string]string) []string {
+	args := []string{}
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		args = append(args, flag, key+"="+m[key])
+	}
+	return args
+}
+
+// the knobs "dockerBuild" and "dockerBuildxBuild" share beyond the tags/file/context
+type dockerBuildOptions struct {
+	Platform string
+
+	// see "dockerBuild" and "dockerBuildxBuild" for where these end up
+	Annotations map[string]string
+
+	// "--build-arg" values (notably SOURCE_DATE_EPOCH; see "Repo.ArchBuildArgs")
+	BuildArgs map[string]string
+
+	NoCache bool
+
+	// "--target" (for building intermediate stages)
+	Target string
+
+	// see "buildOptions"
+	Log io.Writer
+
+	// counts the bytes of the build context as they are actually sent to the builder, if non-nil (see "metricsPhase.Write")
+	ContextBytes io.Writer
+
+	// FROM value => an image in our containerd content store to build on instead of resolving it from the registry (parents built earlier in this run; see "buildkitHostBuild"); only "buildkitClientBuild" supports this
+	Contexts map[string]imagespec.Descriptor
+}
+
+// "docker build" (with "DOCKER_BUILDKIT=0"); annotations are applied as labels, since "docker push" creates the manifests of images built this way
+func dockerBuild(tags []string, file string, context io.Reader, opts dockerBuildOptions) error {
 	args := []string{"build"}
 	for _, tag := range tags {
 		args = append(args, "--tag", tag)
 	}
+	args = append(args, dockerKeyValArgs("--label", opts.Annotations)...)
+	args = append(args, dockerKeyValArgs("--build-arg", opts.BuildArgs)...)
+	if opts.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if opts.Target != "" {
+		args = append(args, "--target", opts.Target)
+	}
 	if file != "" {
 		args = append(args, "--file", file)
 	}
@@ -162,24 +259,31 @@ func dockerBuild(tags []string, file string, context io.Reader, platform string)
 	if debugFlag {
 		fmt.Println("$ export DOCKER_BUILDKIT=0")
 	}
-	if platform != "" {
+	if opts.Platform != "" {
 		// ideally, we would set this via an explicit "--platform" flag on "docker build", but it's not supported without buildkit until 20.10+ and this is a trivial way to get Docker to do the right thing in both cases without explicitly trying to detect whether we're on 20.10+
 		// https://github.com/docker/cli/blob/v20.10.7/cli/command/image/build.go#L163
-		cmd.Env = append(cmd.Env, "DOCKER_DEFAULT_PLATFORM="+platform)
+		cmd.Env = append(cmd.Env, "DOCKER_DEFAULT_PLATFORM="+opts.Platform)
 		if debugFlag {
-			fmt.Printf("$ export DOCKER_DEFAULT_PLATFORM=%q\n", platform)
+			fmt.Printf("$ export DOCKER_DEFAULT_PLATFORM=%q\n", opts.Platform)
 		}
 	}
 	cmd.Stdin = context
+	if opts.ContextBytes != nil {
+		cmd.Stdin = io.TeeReader(context, opts.ContextBytes)
+	}
+	if opts.Log != nil {
+		fmt.Fprintf(opts.Log, "$ export %q\n$ docker %q\n", cmd.Env[len(os.Environ()):], args)
+	}
 	if debugFlag {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = teeBuildLog(os.Stdout, opts.Log)
+		cmd.Stderr = teeBuildLog(os.Stderr, opts.Log)
 		fmt.Printf("$ docker %q\n", args)
 		return cmd.Run()
 	} else {
 		buf := &bytes.Buffer{}
-		cmd.Stdout = buf
-		cmd.Stderr = buf
+		out := teeBuildLog(buf, opts.Log)
+		cmd.Stdout = out
+		cmd.Stderr = out
 		err := cmd.Run()
 		if err != nil {
 			err = cli.NewMultiError(err, fmt.Errorf(`docker %q output:%s`, args, "\n"+buf.String()))
@@ -192,9 +296,70 @@ const (
 	dockerfileSyntaxEnv = "BASHBREW_BUILDKIT_SYNTAX"
 	sbomGeneratorEnv    = "BASHBREW_BUILDKIT_SBOM_GENERATOR"
 	buildxBuilderEnv    = "BUILDX_BUILDER"
+	buildkitHostEnv     = "BUILDKIT_HOST"
 )
 
-func dockerBuildxBuild(tags []string, file string, context io.Reader, platform string) error {
+var buildkitVersionRegex = regexp.MustCompile(`(?mi)^(?:buildkit(?: version)?:\s*)?v(\d+)\.(\d+)\.`)
+
+// whether the given BuildKit version (either "v0.13.0" or "docker buildx inspect" output) supports the "rewrite-timestamp" exporter option (BuildKit v0.13+)
+func buildkitVersionSupportsRewriteTimestamp(version string) bool {
+	matches := buildkitVersionRegex.FindStringSubmatch(version)
+	if matches == nil {
+		return false
+	}
+	major, _ := strconv.Atoi(matches[1])
+	minor, _ := strconv.Atoi(matches[2])
+	return major > 0 || minor >= 13
+}
+
+// whether the BuildKit behind "BUILDX_BUILDER" supports the "rewrite-timestamp" exporter option
+var buildxSupportsRewriteTimestamp = sync.OnceValue(func() bool {
+	out, err := exec.Command("docker", "buildx", "inspect", "--bootstrap").Output()
+	if err != nil {
+		if debugFlag {
+			fmt.Fprintf(os.Stderr, "DEBUG: docker buildx inspect => %v\n", err)
+		}
+		return false
+	}
+	return buildkitVersionSupportsRewriteTimestamp(string(out))
+})
+
+// returns the attributes for BuildKit's "oci" exporter that put the given annotations on the index, the image manifest, and the image manifest's descriptor in the index (and clamp file timestamps to SOURCE_DATE_EPOCH, if "rewriteTimestamp")
+func buildkitOCIExporterAttrs(opts dockerBuildOptions, rewriteTimestamp bool) map[string]string {
+	attrs := map[string]string{}
+	if _, ok := opts.BuildArgs["SOURCE_DATE_EPOCH"]; ok && rewriteTimestamp {
+		// clamp the timestamps of files in the layers too (not just the image config) so builds can be bit-for-bit reproducible
+		attrs["rewrite-timestamp"] = "true"
+	}
+	for key, val := range opts.Annotations {
+		for _, prefix := range []string{"annotation-index.", "annotation-manifest.", "annotation-manifest-descriptor."} {
+			attrs[prefix+key] = val
+		}
+	}
+	return attrs
+}
+
+// returns the "--output" value for "buildx build" that exports an OCI tarball (see "buildkitOCIExporterAttrs"; CSV-encoded, because that's how buildx parses it)
+func buildxOCIOutput(opts dockerBuildOptions) (string, error) {
+	fields := []string{"type=oci"}
+	attrs := buildkitOCIExporterAttrs(opts, buildxSupportsRewriteTimestamp())
+	for _, key := range slices.Sorted(maps.Keys(attrs)) {
+		fields = append(fields, key+"="+attrs[key])
+	}
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(fields); err != nil {
+		return "", err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// "docker buildx build"; annotations end up on the manifests (and index) when we're using a dedicated builder (and thus exporting an OCI tarball into containerd ourselves, in which case the result is *only* in containerd and it is up to the caller to load it into Docker), and as labels otherwise (see "dockerBuild")
+func dockerBuildxBuild(tags []string, file string, context io.Reader, opts dockerBuildOptions) error {
 	dockerfileSyntax, ok := os.LookupEnv(dockerfileSyntaxEnv)
 	if !ok {
 		return fmt.Errorf("missing %q", dockerfileSyntaxEnv)
@@ -206,6 +371,7 @@ func dockerBuildxBuild(tags []string, file string, context io.Reader, platform s
 		"--progress", "plain",
 		"--build-arg", "BUILDKIT_SYNTAX=" + dockerfileSyntax,
 	}
+	args = append(args, dockerKeyValArgs("--build-arg", opts.BuildArgs)...)
 	buildxBuilder := "" != os.Getenv(buildxBuilderEnv)
 	if buildxBuilder {
 		args = append(args, "--provenance", "mode=max")
@@ -217,24 +383,39 @@ func dockerBuildxBuild(tags []string, file string, context io.Reader, platform s
 			return fmt.Errorf("have %q but missing %q", sbomGeneratorEnv, buildxBuilderEnv)
 		}
 	}
-	if platform != "" {
-		args = append(args, "--platform", platform)
+	if opts.Platform != "" {
+		args = append(args, "--platform", opts.Platform)
+	}
+	if opts.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if opts.Target != "" {
+		args = append(args, "--target", opts.Target)
 	}
 	for _, tag := range tags {
 		args = append(args, "--tag", tag)
 	}
+	if !buildxBuilder {
+		args = append(args, dockerKeyValArgs("--label", opts.Annotations)...)
+	}
 	if file != "" {
 		args = append(args, "--file", file)
 	}
 	args = append(args, "-")
 
 	if buildxBuilder {
-		args = append(args, "--output", "type=oci")
-		// TODO ,annotation.xyz.tianon.foo=bar,annotation-manifest-descriptor.xyz.tianon.foo=bar (for OCI source annotations, which this function doesn't currently have access to)
+		output, err := buildxOCIOutput(opts)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--output", output)
 	}
 
 	cmd := exec.Command("docker", args...)
 	cmd.Stdin = context
+	if opts.ContextBytes != nil {
+		cmd.Stdin = io.TeeReader(context, opts.ContextBytes)
+	}
 
 	run := func() error {
 		return cmd.Run()
@@ -259,34 +440,21 @@ func dockerBuildxBuild(tags []string, file string, context io.Reader, platform s
 			}
 			pipe.Close()
 
-			err = cmd.Wait()
-			if err != nil {
-				return err
-			}
-
-			desc, err := containerdImageLookup(tags[0])
-			if err != nil {
-				return err
-			}
-
-			fmt.Printf("Importing %s into Docker\n", desc.Digest)
-			err = containerdDockerLoad(*desc, tags)
-			if err != nil {
-				return err
-			}
-
-			return nil
+			return cmd.Wait()
 		}
 	}
 
 	// intentionally not touching os.Stdout because "buildx build" does *not* put any build output to stdout and in some cases (see above) we use stdout to capture an OCI tarball and pipe it into containerd
+	if opts.Log != nil {
+		fmt.Fprintf(opts.Log, "$ docker %q\n", args)
+	}
 	if debugFlag {
-		cmd.Stderr = os.Stderr
+		cmd.Stderr = teeBuildLog(os.Stderr, opts.Log)
 		fmt.Printf("$ docker %q\n", args)
 		return run()
 	} else {
 		buf := &bytes.Buffer{}
-		cmd.Stderr = buf
+		cmd.Stderr = teeBuildLog(buf, opts.Log)
 		err := run()
 		if err != nil {
 			err = cli.NewMultiError(err, fmt.Errorf(`docker %q output:%s`, args, "\n"+buf.String()))
@@ -295,6 +463,123 @@ func dockerBuildxBuild(tags []string, file string, context io.Reader, platform s
 	}
 }
 
+// returns the "--build-arg" values we pass to every build of the given entry (currently just SOURCE_DATE_EPOCH, from "ArchGitTime", so builds of the same commit can be bit-for-bit reproducible)
+func (r Repo) ArchBuildArgs(arch string, entry *manifest.Manifest2822Entry) (map[string]string, error) {
+	created, err := r.ArchGitTime(arch, entry)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"SOURCE_DATE_EPOCH": strconv.FormatInt(created.Unix(), 10),
+	}, nil
+}
+
+// "Builder: classic" (and the default) and "Builder: buildkit"
+type dockerBuilder struct {
+	buildkit bool
+}
+
+func init() {
+	registerBuilder("", dockerBuilder{})
+	registerBuilder("classic", dockerBuilder{})
+	registerBuilder("buildkit", buildkitBuilder{dockerBuilder{buildkit: true}})
+}
+
+// returns the "dockerBuildOptions" for building the given entry for the given architecture
+func (r Repo) archDockerBuildOptions(arch string, entry *manifest.Manifest2822Entry, buildOpts buildOptions) (dockerBuildOptions, error) {
+	opts := dockerBuildOptions{
+		NoCache: buildOpts.NoCache,
+		Target:  buildOpts.Target,
+		Log:     buildOpts.Log,
+	}
+	if phase := metrics.Entry(r, entry).Current(); phase != nil {
+		// whichever phase we're building in ("build", "stage", "verify") gets the bytes of the context we send for it
+		opts.ContextBytes = phase
+	}
+	if buildOpts.FromScratch {
+		opts.Platform = ociArch.String()
+	}
+	var err error
+	opts.Annotations, err = r.ArchAnnotations(arch, entry, buildOpts.Pins)
+	if err != nil {
+		return opts, fmt.Errorf(`failed calculating annotations: %w`, err)
+	}
+	opts.BuildArgs, err = r.ArchBuildArgs(arch, entry)
+	if err != nil {
+		return opts, fmt.Errorf(`failed calculating build args: %w`, err)
+	}
+	return opts, nil
+}
+
+func (b dockerBuilder) Build(r Repo, entry *manifest.Manifest2822Entry, tags []string, buildOpts buildOptions) error {
+	commit, err := r.fetchGitRepo(arch, entry)
+	if err != nil {
+		return fmt.Errorf(`failed fetching git repo: %w`, err)
+	}
+
+	opts, err := r.archDockerBuildOptions(arch, entry, buildOpts)
+	if err != nil {
+		return err
+	}
+	if baseName, ok := opts.Annotations[imagespec.AnnotationBaseImageName]; ok && opts.Annotations[imagespec.AnnotationBaseImageDigest] == "" && (!b.buildkit || os.Getenv(buildxBuilderEnv) == "") {
+		// these builds happen on top of whatever the local Docker has for the FROM, so if that was pulled from the registry, we know exactly what we're building on (see "ArchAnnotations")
+		if dgst, err := dockerLocalBaseDigest(baseName); err == nil && dgst != "" {
+			opts.Annotations[imagespec.AnnotationBaseImageDigest] = dgst
+		}
+	}
+
+	archive, err := gitArchive(commit, entry.ArchDirectory(arch))
+	if err != nil {
+		return fmt.Errorf(`failed generating git archive: %w`, err)
+	}
+	defer archive.Close()
+
+	if len(buildOpts.Pins) > 0 {
+		df, err := r.archPinnedDockerfile(arch, entry, buildOpts.Pins)
+		if err != nil {
+			return fmt.Errorf(`failed pinning FROMs: %w`, err)
+		}
+		archive = tarReplaceFile(archive, entry.ArchFile(arch), []byte(df))
+		defer archive.Close()
+	}
+
+	if b.buildkit {
+		if err := dockerBuildxBuild(tags, entry.ArchFile(arch), archive, opts); err != nil {
+			return err
+		}
+		if os.Getenv(buildxBuilderEnv) == "" {
+			return nil
+		}
+
+		// with "BUILDX_BUILDER", the result only lands in containerd (see "dockerBuildxBuild"), so we need to load it into Docker ourselves
+		desc, err := containerdImageLookup(tags[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Importing %s into Docker\n", desc.Digest)
+		phase := metrics.Entry(r, entry).Start("import")
+		return phase.End(containerdDockerLoad(*desc, tags))
+	}
+	return dockerBuild(tags, entry.ArchFile(arch), archive, opts)
+}
+
+func (b dockerBuilder) Lookup(tag string) (*builtImage, error) {
+	if b.buildkit {
+		// with "BUILDX_BUILDER", "dockerBuildxBuild" loads the result into containerd (and we should prefer that; see "dockerBuildxBuild")
+		if img, err := containerdBuiltImageLookup(tag); img != nil || err != nil {
+			return img, err
+		}
+	}
+	return dockerBuiltImageLookup(tag)
+}
+
+func (b dockerBuilder) Push(r Repo, entry *manifest.Manifest2822Entry, img *builtImage, tags []string, opts pushOptions) ([]string, error) {
+	if img.Desc != nil {
+		return containerdBuiltImagePush(r, entry, img, tags, opts)
+	}
+	return dockerBuiltImagePush(r, entry, img, tags, opts)
+}
+
 func dockerTag(tag1 string, tag2 string) error {
 	if debugFlag {
 		fmt.Printf("$ docker tag %q %q\n", tag1, tag2)
@@ -308,19 +593,80 @@ func dockerTag(tag1 string, tag2 string) error {
 	return err
 }
 
-func dockerPush(tag string) error {
+func dockerRmi(tag string) error {
 	if debugFlag {
-		fmt.Printf("$ docker push %q\n", tag)
+		fmt.Printf("$ docker rmi %q\n", tag)
 	}
-	_, err := exec.Command("docker", "push", tag).Output()
+	_, err := exec.Command("docker", "rmi", tag).Output()
 	if err != nil {
 		if ee, ok := err.(*exec.ExitError); ok {
-			return fmt.Errorf("%v\ncommand: docker push %q\n%s", ee, tag, string(ee.Stderr))
+			return fmt.Errorf("%v\ncommand: docker rmi %q\n%s", ee, tag, string(ee.Stderr))
 		}
 	}
 	return err
 }
 
+// returns the output of "docker push" (see "dockerPushedBytes")
+func dockerPush(tag string) (string, error) {
+	if debugFlag {
+		fmt.Printf("$ docker push %q\n", tag)
+	}
+	out, err := exec.Command("docker", "push", tag).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("%v\ncommand: docker push %q\n%s", ee, tag, string(ee.Stderr))
+		}
+	}
+	return string(out), err
+}
+
+// "docker push" progress lines ("ID: Pushed", "ID: Layer already exists", etc), where "ID" is the truncated "diff ID" of the layer
+var dockerPushProgressRegex = regexp.MustCompile(`(?m)^([0-9a-f]{12}): (.+)$`)
+
+// returns the "diff IDs" (truncated, ala "stringid.TruncateID") of the layers the given "docker push" output says were actually uploaded (not already there, or mounted from another repository)
+func dockerPushedLayers(output string) map[string]bool {
+	pushed := map[string]bool{}
+	for _, match := range dockerPushProgressRegex.FindAllStringSubmatch(output, -1) {
+		if strings.TrimSpace(match[2]) == "Pushed" {
+			pushed[match[1]] = true
+		}
+	}
+	return pushed
+}
+
+// returns the number of (compressed) layer bytes the given "docker push" of "tag" actually uploaded, by matching the layers its output says it pushed against the manifest it pushed (since "docker push" doesn't tell us sizes)
+func dockerPushedBytes(tag string, output string) (int64, error) {
+	pushed := dockerPushedLayers(output)
+	if len(pushed) == 0 {
+		return 0, nil
+	}
+
+	ctx := context.Background()
+	obj, err := registry.ResolveArch(ctx, tag, arch)
+	if err != nil {
+		return 0, err
+	}
+	m, err := obj.Manifest(ctx)
+	if err != nil {
+		return 0, err
+	}
+	config, err := obj.At(m.Config).ConfigBlob(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(config.RootFS.DiffIDs) != len(m.Layers) {
+		return 0, fmt.Errorf("%q has %d layers but %d diff IDs", tag, len(m.Layers), len(config.RootFS.DiffIDs))
+	}
+
+	var n int64
+	for i, diffID := range config.RootFS.DiffIDs {
+		if pushed[diffID.Encoded()[:12]] {
+			n += m.Layers[i].Size
+		}
+	}
+	return n, nil
+}
+
 func dockerPull(tag string) error {
 	if debugFlag {
 		fmt.Printf("$ docker pull %q\n", tag)
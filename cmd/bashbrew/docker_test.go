@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker-library/bashbrew/manifest"
+)
+
+func TestDockerPushedLayers(t *testing.T) {
+	output := `The push refers to repository [docker.io/tianon/test]
+5f70bf18a086: Pushed
+a2b3c4d5e6f7: Layer already exists
+0123456789ab: Mounted from library/debian
+b1c2d3e4f5a6: Pushed
+latest: digest: sha256:0000000000000000000000000000000000000000000000000000000000000000 size: 1234
+`
+	expected := map[string]bool{
+		"5f70bf18a086": true,
+		"b1c2d3e4f5a6": true,
+	}
+	if got := dockerPushedLayers(output); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v; got %v", expected, got)
+	}
+
+	if got := dockerPushedLayers("a2b3c4d5e6f7: Layer already exists\n"); len(got) != 0 {
+		t.Errorf("expected nothing pushed; got %v", got)
+	}
+}
+
+func TestBuildkitVersionSupportsRewriteTimestamp(t *testing.T) {
+	tests := map[string]bool{
+		"v0.13.0":                   true,
+		"v0.12.5":                   false,
+		"v0.12.99":                  false,
+		"v0.14.1":                   true,
+		"v1.0.0":                    true,
+		"v0.9.3":                    false, // (compared numerically, not lexically)
+		"v0.13.0-rc1":               true,  // pre-releases of v0.13 already have it
+		"v0.13.0-beta3":             true,
+		"v0.12.0-rc1":               false,
+		"v0.13.1+d3e6c1360f6e":      true, // (moby's embedded BuildKit)
+		"v0.12.5+d3e6c1360f6e":      false,
+		"v0.0.0+unknown":            false, // (a development build we can't say anything about)
+		"0.13.0":                    false,
+		"":                          false,
+		"Buildkit:  v0.11.6":        false,
+		"BuildKit version: v0.13.2": true,
+
+		// "docker buildx inspect" output
+		"Name:          default\nDriver:        docker\n\nNodes:\nName:      default\nEndpoint:  default\nStatus:    running\nBuildKit version: v0.16.0\nPlatforms: linux/amd64\n": true,
+		"Name:   builder\nDriver: docker-container\nNodes:\nName:      builder0\nStatus:    running\nBuildkit:  v0.12.4\nPlatforms: linux/amd64\n":                                 false,
+		"Name:   builder\nDriver: docker-container\nNodes:\nName:      builder0\nStatus:    inactive\n":                                                                            false,
+	}
+	for version, expected := range tests {
+		if got := buildkitVersionSupportsRewriteTimestamp(version); got != expected {
+			t.Errorf("%q: expected %v; got %v", version, expected, got)
+		}
+	}
+}
+
+func TestBuildkitOCIExporterAttrs(t *testing.T) {
+	annotations := map[string]string{"org.opencontainers.image.version": "1.2.3"}
+	withEpoch := dockerBuildOptions{BuildArgs: map[string]string{"SOURCE_DATE_EPOCH": "1700000000"}, Annotations: annotations}
+	withoutEpoch := dockerBuildOptions{Annotations: annotations}
+
+	tests := []struct {
+		name             string
+		opts             dockerBuildOptions
+		rewriteTimestamp bool
+		expected         string
+	}{
+		{"supported", withEpoch, true, "true"},
+		{"unsupported", withEpoch, false, ""},
+		{"no SOURCE_DATE_EPOCH", withoutEpoch, true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attrs := buildkitOCIExporterAttrs(test.opts, test.rewriteTimestamp)
+			if got := attrs["rewrite-timestamp"]; got != test.expected {
+				t.Errorf("expected rewrite-timestamp=%q; got %q", test.expected, got)
+			}
+			for _, prefix := range []string{"annotation-index.", "annotation-manifest.", "annotation-manifest-descriptor."} {
+				if got := attrs[prefix+"org.opencontainers.image.version"]; got != "1.2.3" {
+					t.Errorf("%s: expected %q; got %q", prefix, "1.2.3", got)
+				}
+			}
+		})
+	}
+}
+
+func TestArchBuildArgs(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	origCache, origGitRepo, origGitRepoCache := defaultCache, gitRepo, gitRepoCache
+	defer func() { defaultCache, gitRepo, gitRepoCache = origCache, origGitRepo, origGitRepoCache }()
+	defaultCache, gitRepo, gitRepoCache = t.TempDir(), nil, map[string]string{}
+
+	// a commit with a known (committer) date, which is what SOURCE_DATE_EPOCH should be
+	dir := t.TempDir()
+	run := func(env []string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Env = append(os.Environ(), env...)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	run(nil, "init", "--quiet")
+	if err := os.MkdirAll(filepath.Join(dir, "1.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.0", "Dockerfile"), []byte("FROM scratch\nARG SOURCE_DATE_EPOCH=0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(nil, "add", ".")
+	run([]string{"GIT_AUTHOR_DATE=2020-01-01T00:00:00Z", "GIT_COMMITTER_DATE=2023-11-14T22:13:20Z"}, "commit", "--quiet", "--message", "test")
+	commit := run(nil, "rev-parse", "HEAD")
+
+	man, err := manifest.Parse(strings.NewReader("Maintainers: Foo (@foo)\nGitRepo: " + dir + "\nGitFetch: refs/heads/" + run(nil, "symbolic-ref", "--short", "HEAD") + "\nGitCommit: " + commit + "\n\nTags: 1.0\nDirectory: 1.0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Repo{RepoName: "test", Manifest: man}
+
+	args, err := r.ArchBuildArgs("amd64", &man.Entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (the Dockerfile's own "ARG SOURCE_DATE_EPOCH=0" default is just that -- a default, which the build arg overrides)
+	if expected := map[string]string{"SOURCE_DATE_EPOCH": "1700000000"}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %v; got %v", expected, args)
+	}
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
-	"strings"
+	"slices"
 
 	"github.com/sirupsen/logrus" // this is used by containerd libraries, so we need to set the default log level for it
 	"github.com/urfave/cli"
@@ -19,6 +20,8 @@ import (
 //   docker version --format '{{.Server.APIVersion}}'
 
 var (
+	rootApp *cli.App // (commands with "Subcommands" get their own "cli.App", but "flags" keys are resolved against the real one; see "cmdConfigShow")
+
 	configPath  string
 	flagsConfig *FlagsConfig
 
@@ -31,12 +34,14 @@ var (
 	constraints          []string
 	exclusiveConstraints bool
 
-	archNamespaces map[string]string
+	archNamespaces    map[string]string
+	archDockerHosts   map[string]string
+	archBuildkitHosts map[string]string
 
 	debugFlag  = false
 	noSortFlag = false
 
-	// separated so that FlagsConfig.ApplyTo can access them
+	// separated so that "bashbrew config show" can tell which values come from the environment
 	flagEnvVars = map[string]string{
 		"debug":     "BASHBREW_DEBUG",
 		"arch":      "BASHBREW_ARCH",
@@ -45,9 +50,15 @@ var (
 		"library":   "BASHBREW_LIBRARY",
 		"cache":     "BASHBREW_CACHE",
 		"pull":      "BASHBREW_PULL",
+		"sign-key":  "BASHBREW_SIGN_KEY",
 
 		"constraint":     "BASHBREW_CONSTRAINTS",
 		"arch-namespace": "BASHBREW_ARCH_NAMESPACES",
+
+		"arch-docker-host":   "BASHBREW_ARCH_DOCKER_HOSTS",
+		"arch-buildkit-host": "BASHBREW_ARCH_BUILDKIT_HOSTS",
+		"builder-pool":       "BASHBREW_BUILDER_POOL",
+		"custom-arch":        "BASHBREW_CUSTOM_ARCHES",
 	}
 )
 
@@ -69,6 +80,7 @@ func initDefaultCachePath() string {
 
 func main() {
 	app := cli.NewApp()
+	rootApp = app
 	app.Name = "bashbrew"
 	app.Usage = "canonical build tool for the official images"
 	app.Version = version
@@ -107,18 +119,38 @@ func main() {
 		cli.StringSliceFlag{
 			Name:   "constraint",
 			EnvVar: flagEnvVars["constraint"],
-			Usage:  "build constraints (see Constraints in Manifest2822Entry)",
+			Usage:  "build constraints, ala \"aufs\" or \"docker=24.0.7\" (see Constraints in Manifest2822Entry)",
 		},
 		cli.BoolFlag{
 			Name:  "exclusive-constraints",
 			Usage: "skip entries which do not have Constraints",
 		},
 
+		cli.StringSliceFlag{
+			Name:   "custom-arch",
+			EnvVar: flagEnvVars["custom-arch"],
+			Usage:  `additional architectures to support ("name=os/arch[/variant]" ala "amd64v3=linux/amd64/v3"; see also the "arches" file next to "flags" in --config for more detailed definitions)`,
+		},
 		cli.StringSliceFlag{
 			Name:   "arch-namespace",
 			EnvVar: flagEnvVars["arch-namespace"],
 			Usage:  `architecture to push namespace mappings for creating indexes/manifest lists ("arch=namespace" ala "s390x=tianons390x")`,
 		},
+		cli.StringSliceFlag{
+			Name:   "arch-docker-host",
+			EnvVar: flagEnvVars["arch-docker-host"],
+			Usage:  `architecture to DOCKER_HOST mappings for building (and pushing) on remote Docker daemons ("arch=host" ala "s390x=ssh://s390x-builder"; architectures without one use the local daemon, emulating them if necessary)`,
+		},
+		cli.StringSliceFlag{
+			Name:   "arch-buildkit-host",
+			EnvVar: flagEnvVars["arch-buildkit-host"],
+			Usage:  `architecture to BUILDKIT_HOST mappings for "Builder: buildkit" entries ("arch=host" ala "arm64v8=tcp://arm64-buildkitd:1234")`,
+		},
+		cli.StringSliceFlag{
+			Name:   "builder-pool",
+			EnvVar: flagEnvVars["builder-pool"],
+			Usage:  `remote workers to dispatch builds to ("arch=endpoint", any number per architecture; "ssh://..." or "docker+tcp://..." for Docker daemons, buildkitd addresses ala "tcp://host:1234?ca=...&cert=...&key=..." otherwise), with results collected into the local containerd store`,
+		},
 
 		cli.StringFlag{
 			Name:   "config",
@@ -138,6 +170,19 @@ func main() {
 			EnvVar: flagEnvVars["cache"],
 			Usage:  "where the git wizardry is stashed",
 		},
+
+		cli.StringFlag{
+			Name:  "metrics",
+			Usage: "write how long each phase (fetch, pull, build, import, push, etc) of each entry took (and how many bytes it involved) as JSON to `FILE`",
+		},
+		cli.StringFlag{
+			Name:  "metrics-prometheus",
+			Usage: "write the same metrics as --metrics in Prometheus text format to `FILE` (ala node_exporter's \"textfile\" collector)",
+		},
+		cli.StringFlag{
+			Name:  "metrics-otlp",
+			Usage: "append the same metrics as --metrics as OpenTelemetry traces in OTLP/JSON format to `FILE` (ala the collector's \"otlpjsonfile\" receiver)",
+		},
 	}
 
 	app.Before = func(c *cli.Context) error {
@@ -152,10 +197,28 @@ func main() {
 		if err != nil && !os.IsNotExist(err) {
 			return err
 		}
+		if err := flagsConfig.Resolve(app); err != nil {
+			return err
+		}
+
+		if err := registerCustomArchesFile(filepath.Join(configPath, "arches")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if c.String("metrics") != "" || c.String("metrics-prometheus") != "" || c.String("metrics-otlp") != "" {
+			metrics = newMetricsRecorder(c.Args().First())
+		}
 
 		return nil
 	}
 
+	app.After = func(c *cli.Context) error {
+		if err := metrics.WriteFiles(c.String("metrics"), c.String("metrics-prometheus"), c.String("metrics-otlp")); err != nil {
+			return fmt.Errorf("failed writing metrics: %w", err)
+		}
+		return nil
+	}
+
 	subcommandBeforeFactory := func(cmd string) cli.BeforeFunc {
 		return func(c *cli.Context) error {
 			err := flagsConfig.ApplyTo(cmd, c)
@@ -173,6 +236,20 @@ func main() {
 				logrus.SetLevel(logrus.DebugLevel)
 			}
 
+			customArches, err := parseArchMappings("custom-arch", c.GlobalStringSlice("custom-arch"))
+			if err != nil {
+				return err
+			}
+			for _, name := range slices.Sorted(maps.Keys(customArches)) {
+				platform, err := architecture.ParsePlatform(customArches[name])
+				if err == nil {
+					err = architecture.Register(name, platform)
+				}
+				if err != nil {
+					return cli.NewMultiError(fmt.Errorf(`invalid --custom-arch %q`, name), err)
+				}
+			}
+
 			arch = c.GlobalString("arch")
 			namespace = c.GlobalString("namespace")
 			constraints = c.GlobalStringSlice("constraint")
@@ -183,20 +260,22 @@ func main() {
 				arch = manifest.DefaultArchitecture
 			}
 
-			var ok bool
-			if ociArch, ok = architecture.SupportedArches[arch]; !ok {
-				return fmt.Errorf("invalid architecture: %q", arch)
+			if archNamespaces, err = parseArchMappings("arch-namespace", c.GlobalStringSlice("arch-namespace")); err != nil {
+				return err
+			}
+			if archDockerHosts, err = parseArchMappings("arch-docker-host", c.GlobalStringSlice("arch-docker-host")); err != nil {
+				return err
+			}
+			if archBuildkitHosts, err = parseArchMappings("arch-buildkit-host", c.GlobalStringSlice("arch-buildkit-host")); err != nil {
+				return err
 			}
 
-			archNamespaces = map[string]string{}
-			for _, archMapping := range c.GlobalStringSlice("arch-namespace") {
-				if archMapping == "" {
-					// "BASHBREW_ARCH_NAMESPACES=" (should be the same as the empty list)
-					continue
-				}
-				splitArchMapping := strings.SplitN(archMapping, "=", 2)
-				splitArch, splitNamespace := strings.TrimSpace(splitArchMapping[0]), strings.TrimSpace(splitArchMapping[1])
-				archNamespaces[splitArch] = splitNamespace
+			if builderPool, err = parseBuilderPool(c.GlobalStringSlice("builder-pool")); err != nil {
+				return err
+			}
+
+			if err := setArch(arch); err != nil {
+				return err
 			}
 
 			defaultLibrary, err = filepath.Abs(c.GlobalString("library"))
@@ -247,6 +326,11 @@ func main() {
 			Name:  "force",
 			Usage: "always push (skip the clever Hub API lookups that no-op things sooner if a push doesn't seem necessary)",
 		},
+		"sign-key": cli.StringFlag{
+			Name:   "sign-key",
+			EnvVar: flagEnvVars["sign-key"],
+			Usage:  "sign everything pushed with the (unencrypted, PEM-encoded) private key in `FILE` (cosign-compatible signatures, pushed as OCI referrers)",
+		},
 		"target-namespace": cli.StringFlag{
 			Name:  "target-namespace",
 			Usage: `target namespace to act into ("docker tag namespace/repo:tag target-namespace/repo:tag", "docker push target-namespace/repo:tag")`,
@@ -277,6 +361,96 @@ func main() {
 			Before: subcommandBeforeFactory("list"),
 			Action: cmdList,
 		},
+		{
+			Name:  "plan",
+			Usage: `output a job graph (for CI) of every entry and architecture of the given repos, with the FROM dependencies between them`,
+			Flags: []cli.Flag{
+				commonFlags["all"],
+				commonFlags["uniq"],
+				commonFlags["apply-constraints"],
+				commonFlags["arch-filter"],
+				cli.StringFlag{
+					Name:  "format",
+					Value: "json",
+					Usage: `output format ("json" for a generic list of jobs, or "github-actions" for a GitHub Actions "strategy" with a matrix of them)`,
+				},
+				cli.BoolFlag{
+					Name:  "waves",
+					Usage: "group jobs into waves that can each be built entirely in parallel (after the previous wave)",
+				},
+			},
+			Before: subcommandBeforeFactory("plan"),
+			Action: cmdPlan,
+		},
+		{
+			Name:  "affected",
+			Usage: `list the tags of entries (and, by default, their children) affected by the changes in a range of commits of a Git repository (only entries whose GitCommit is in that range are considered)`,
+			Flags: []cli.Flag{
+				commonFlags["all"],
+				commonFlags["uniq"],
+				commonFlags["apply-constraints"],
+				commonFlags["arch-filter"],
+				cli.StringFlag{
+					Name:  "git-dir",
+					Value: ".",
+					Usage: "the Git repository the commits are in (a local clone of the entries' GitRepo)",
+				},
+				cli.StringFlag{
+					Name:  "commits",
+					Usage: `the range of commits to consider (anything "git diff" accepts, ala "origin/master..HEAD")`,
+				},
+				cli.BoolFlag{
+					Name:  "dockerfile-filter",
+					Usage: `only consider changes to each entry's Dockerfile (and the files it COPY/ADDs) instead of everything in its Directory`,
+				},
+				cli.BoolTFlag{
+					Name:  "children",
+					Usage: `also list everything in --library built FROM an affected entry (recursively; "--children=false" to disable)`,
+				},
+			},
+			Before: subcommandBeforeFactory("affected"),
+			Action: cmdAffected,
+		},
+		{
+			Name:  "outdated",
+			Usage: `compare the base (last FROM) of each pushed image to what it currently resolves to in the registry, to find images that need rebuilding (read-only)`,
+			Flags: []cli.Flag{
+				commonFlags["all"],
+				commonFlags["uniq"],
+				commonFlags["target-namespace"],
+				cli.BoolFlag{
+					Name:  "build-order",
+					Usage: "instead of a report, list the tags of everything that needs rebuilding (outdated images and everything built FROM them) in the order it needs to be rebuilt",
+				},
+				commonFlags["json"],
+			},
+			Before: subcommandBeforeFactory("outdated"),
+			Action: cmdOutdated,
+		},
+		{
+			Name:  "graph",
+			Usage: `output the FROM graph of the given repos (per architecture, including external bases like "mcr.microsoft.com/...") as DOT, Mermaid, or JSON`,
+			Flags: []cli.Flag{
+				commonFlags["all"],
+				commonFlags["apply-constraints"],
+				commonFlags["arch-filter"],
+				cli.StringSliceFlag{
+					Name:  "root",
+					Usage: "only include the given repo, tag, or external base and everything built FROM it (can be specified multiple times)",
+				},
+				cli.IntFlag{
+					Name:  "depth",
+					Usage: `maximum number of levels to traverse from each "--root" (0 for unlimited)`,
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "dot",
+					Usage: `output format ("dot", "mermaid", or "json")`,
+				},
+			},
+			Before: subcommandBeforeFactory("graph"),
+			Action: cmdGraph,
+		},
 		{
 			Name:  "build",
 			Usage: "build (and tag) repo:tag combinations for a given repo",
@@ -289,7 +463,42 @@ func main() {
 					EnvVar: flagEnvVars["pull"],
 					Usage:  `pull FROM before building (always, missing, never)`,
 				},
+				cli.StringFlag{
+					Name:  "log-dir",
+					Usage: "write the full output of each entry's build(s) to a timestamped file in `DIR` (and mention it in failure reports)",
+				},
+				cli.IntFlag{
+					Name:  "log-keep",
+					Usage: `number of logs to keep per entry/architecture in "--log-dir" (0 to keep all of them)`,
+				},
+				cli.DurationFlag{
+					Name:  "log-max-age",
+					Usage: `remove logs older than this from "--log-dir" (0 to keep them forever)`,
+				},
+				cli.BoolFlag{
+					Name:  "cache-stages",
+					Usage: `also build and tag each named intermediate stage (as "bashbrew/cache:<hash>-<stage>") so retrying a failed multi-stage build is cheaper`,
+				},
+				cli.BoolFlag{
+					Name:  "pin-froms",
+					Usage: `resolve each FROM to a digest for the current architecture (once per run) and pull/build against exactly that, so a base image being retagged mid-run can't sneak in`,
+				},
+				cli.BoolFlag{
+					Name:  "verify-reproducible",
+					Usage: `rebuild each entry from scratch ("--no-cache") after building it and compare the resulting manifest digests (or image IDs), failing if any differ`,
+				},
 				commonFlags["dry-run"],
+				cli.StringSliceFlag{
+					Name:  "arches",
+					Usage: `build for each of the given architectures in turn (comma-separated and/or repeated; see "--arch-docker-host" and "--arch-buildkit-host" for building some of them elsewhere)`,
+				},
+				cli.BoolFlag{
+					Name:  "put-shared",
+					Usage: `after building each of "--arches", push it to its "--arch-namespace", then put the indexes for everything into "--target-namespace" (ala "push" + "put-shared")`,
+				},
+				commonFlags["target-namespace"],
+				commonFlags["force"],
+				commonFlags["sign-key"],
 			},
 			Before: subcommandBeforeFactory("build"),
 			Action: cmdBuild,
@@ -315,6 +524,7 @@ func main() {
 				commonFlags["dry-run"],
 				commonFlags["force"],
 				commonFlags["target-namespace"],
+				commonFlags["sign-key"],
 			},
 			Before: subcommandBeforeFactory("push"),
 			Action: cmdPush,
@@ -331,10 +541,24 @@ func main() {
 					Name:  "single-arch",
 					Usage: `only act on the current architecture (for pushing "amd64/hello-world:latest", for example)`,
 				},
+				commonFlags["sign-key"],
 			},
 			Before: subcommandBeforeFactory("put-shared"),
 			Action: cmdPutShared,
 		},
+		{
+			Name:  "promote",
+			Usage: `copy namespace/repo:tag to target-namespace/repo:tag registry-to-registry (no local Docker required)`,
+			Flags: []cli.Flag{
+				commonFlags["all"],
+				commonFlags["uniq"],
+				commonFlags["dry-run"],
+				commonFlags["force"],
+				commonFlags["target-namespace"],
+			},
+			Before: subcommandBeforeFactory("promote"),
+			Action: cmdPromote,
+		},
 
 		{
 			Name: "children",
@@ -478,6 +702,53 @@ func main() {
 
 			Category: "plumbing",
 		},
+		{
+			Name:  "config",
+			Usage: `show or change the "flags" configuration (see "--config")`,
+			Subcommands: []cli.Command{
+				{
+					Name:      "show",
+					Usage:     `show the effective value of every global flag (and every flag of "--command") and where it comes from (the command line, the environment, the "flags" file, or the default), optionally for the given repos (see "Repos" in the "flags" file)`,
+					ArgsUsage: "[repo...]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "command",
+							Usage: "also show the flags of (and the configuration specific to) the given `COMMAND`",
+						},
+						commonFlags["json"],
+					},
+					Action: cmdConfigShow,
+				},
+				{
+					Name:      "get",
+					Usage:     `print the effective value of a single key (ala "Namespace" or "target-namespace"; see "show")`,
+					ArgsUsage: "KEY [repo...]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "command",
+							Usage: "get the value for the given `COMMAND` (required for keys which aren't global flags)",
+						},
+					},
+					Action: cmdConfigGet,
+				},
+				{
+					Name:      "set",
+					Usage:     `set a key in the "flags" file (in the paragraph for exactly the given "--command" and "--repo" values, which is created if necessary), or remove it if VALUE is empty`,
+					ArgsUsage: "KEY VALUE",
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "command",
+							Usage: "only set the key for the given `COMMAND` (may be specified multiple times)",
+						},
+						cli.StringSliceFlag{
+							Name:  "repo",
+							Usage: "only set the key for the given `REPO` (ala \"python\" or \"py*\"; may be specified multiple times)",
+						},
+					},
+					Action: cmdConfigSet,
+				},
+			},
+		},
 		{
 			Name:     "remote",
 			Usage:    "query registries for bashbrew-related data",
@@ -492,6 +763,61 @@ func main() {
 					},
 					Action: cmdRemoteArches,
 				},
+				{
+					Name:  "status",
+					Usage: `compare what "push" (or "put-shared") would publish to what the registry currently has, including tags no entry produces (read-only)`,
+					Flags: []cli.Flag{
+						commonFlags["all"],
+						commonFlags["target-namespace"],
+						cli.BoolFlag{
+							Name:  "put-shared",
+							Usage: `compare the indexes "put-shared" would put (instead of the images "push" would push)`,
+						},
+						cli.BoolFlag{
+							Name:  "single-arch",
+							Usage: `only check the current architecture (ala "put-shared --single-arch"; only meaningful with "--put-shared")`,
+						},
+						commonFlags["json"],
+					},
+					Action: cmdRemoteStatus,
+				},
+				{
+					Name:  "prune",
+					Usage: `delete tags from the registry that are no longer produced by any entry (dry-run unless "--delete" is specified)`,
+					Flags: []cli.Flag{
+						commonFlags["all"],
+						commonFlags["target-namespace"],
+						cli.StringSliceFlag{
+							Name:  "allow",
+							Usage: "only consider tags matching the given `PATTERN` for deletion (ala \"path.Match\"; may be specified multiple times)",
+						},
+						cli.StringSliceFlag{
+							Name:  "deny",
+							Usage: "never delete tags matching the given `PATTERN` (ala \"path.Match\"; may be specified multiple times)",
+						},
+						cli.BoolFlag{
+							Name:  "delete",
+							Usage: "actually delete tags (otherwise, only print what would be deleted)",
+						},
+					},
+					Action: cmdRemotePrune,
+				},
+				{
+					Name:  "referrers",
+					Usage: `list the referrers (signatures, etc) of the specified image(s), optionally verifying signatures`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "artifact-type",
+							Usage: "only list referrers of the given `TYPE`",
+						},
+						cli.StringFlag{
+							Name:  "verify-key",
+							Usage: "verify signatures against the PEM-encoded public key in `FILE` (failing if any image has no valid signature)",
+						},
+						commonFlags["json"],
+					},
+					Action: cmdRemoteReferrers,
+				},
 			},
 		},
 	}
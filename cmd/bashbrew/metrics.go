@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker-library/bashbrew/manifest"
+)
+
+// per-entry (and per-architecture) timings and byte counts of each phase of the work we do ("fetch", "pull", "build", "import", "push", etc), for "--metrics", "--metrics-prometheus", and "--metrics-otlp" (so we can find the slow images)
+type metricsRecorder struct {
+	Command string          `json:"command"`
+	Start   time.Time       `json:"start"`
+	Seconds float64         `json:"seconds"`
+	Entries []*entryMetrics `json:"entries"`
+
+	mu    sync.Mutex
+	end   time.Time
+	byKey map[string]*entryMetrics
+}
+
+// nil unless one of the "--metrics*" flags is given (and every method below is nil-safe, so callers never need to check)
+var metrics *metricsRecorder
+
+func newMetricsRecorder(command string) *metricsRecorder {
+	return &metricsRecorder{
+		Command: command,
+		Start:   time.Now(),
+		Entries: []*entryMetrics{},
+		byKey:   map[string]*entryMetrics{},
+	}
+}
+
+type entryMetrics struct {
+	Repo   string          `json:"repo"`
+	Entry  string          `json:"entry"`
+	Arch   string          `json:"arch"`
+	Phases []*metricsPhase `json:"phases"`
+
+	recorder *metricsRecorder
+	open     []*metricsPhase // phases which have been started but not ended yet (innermost last)
+}
+
+type metricsPhase struct {
+	Phase   string    `json:"phase"`
+	Parent  string    `json:"parent,omitempty"` // the phase this one happened inside of (and is thus included in the duration of), like "import" inside "build"
+	Start   time.Time `json:"start"`
+	Seconds float64   `json:"seconds"`
+	Bytes   int64     `json:"bytes,omitempty"`
+	Error   string    `json:"error,omitempty"`
+
+	entry  *entryMetrics
+	parent *metricsPhase
+	end    time.Time
+}
+
+// returns the metrics of the given entry (on the current "arch"), creating them if necessary
+func (m *metricsRecorder) Entry(r Repo, entry *manifest.Manifest2822Entry) *entryMetrics {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := r.EntryIdentifier(entry)
+	key := id + "\n" + arch
+	if em, ok := m.byKey[key]; ok {
+		return em
+	}
+	em := &entryMetrics{
+		Repo:     r.RepoName,
+		Entry:    id,
+		Arch:     arch,
+		Phases:   []*metricsPhase{},
+		recorder: m,
+	}
+	m.byKey[key] = em
+	m.Entries = append(m.Entries, em)
+	return em
+}
+
+// starts timing the given phase (inside of whatever phase of this entry is currently running, if any); the result needs to be "End"ed
+func (em *entryMetrics) Start(phase string) *metricsPhase {
+	if em == nil {
+		return nil
+	}
+	em.recorder.mu.Lock()
+	defer em.recorder.mu.Unlock()
+
+	p := &metricsPhase{
+		Phase: phase,
+		Start: time.Now(),
+		entry: em,
+	}
+	if len(em.open) > 0 {
+		p.parent = em.open[len(em.open)-1]
+		p.Parent = p.parent.Phase
+	}
+	em.open = append(em.open, p)
+	em.Phases = append(em.Phases, p)
+	return p
+}
+
+// returns the innermost phase of this entry which is currently running (if any)
+func (em *entryMetrics) Current() *metricsPhase {
+	if em == nil {
+		return nil
+	}
+	em.recorder.mu.Lock()
+	defer em.recorder.mu.Unlock()
+
+	if len(em.open) == 0 {
+		return nil
+	}
+	return em.open[len(em.open)-1]
+}
+
+func (p *metricsPhase) AddBytes(n int64) {
+	if p == nil {
+		return
+	}
+	p.entry.recorder.mu.Lock()
+	defer p.entry.recorder.mu.Unlock()
+	p.Bytes += n
+}
+
+// counts the bytes written as part of this phase (so it can be handed directly to something like "tarscrub.WriteTar")
+func (p *metricsPhase) Write(b []byte) (int, error) {
+	p.AddBytes(int64(len(b)))
+	return len(b), nil
+}
+
+// stops timing this phase, recording the given error (if any) as its outcome, and returns it unmodified (so this can wrap the phase's final function call)
+func (p *metricsPhase) End(err error) error {
+	if p == nil {
+		return err
+	}
+	p.entry.recorder.mu.Lock()
+	defer p.entry.recorder.mu.Unlock()
+
+	p.end = time.Now()
+	p.Seconds = p.end.Sub(p.Start).Seconds()
+	if err != nil {
+		p.Error = err.Error()
+	}
+	for i, open := range p.entry.open {
+		if open == p {
+			// anything started inside this phase that hasn't been ended is abandoned along with it
+			p.entry.open = p.entry.open[:i]
+			break
+		}
+	}
+	return err
+}
+
+// writes every requested output format (see "--metrics*")
+func (m *metricsRecorder) WriteFiles(jsonFile, prometheusFile, otlpFile string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.end = time.Now()
+	m.Seconds = m.end.Sub(m.Start).Seconds()
+
+	if jsonFile != "" {
+		b, err := json.MarshalIndent(m, "", "\t")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(jsonFile, append(b, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+
+	if prometheusFile != "" {
+		if err := os.WriteFile(prometheusFile, m.prometheus(), 0644); err != nil {
+			return err
+		}
+	}
+
+	if otlpFile != "" {
+		b, err := m.otlp()
+		if err != nil {
+			return err
+		}
+		// the collector's "otlpjsonfile" receiver reads one request per line, so we append (and multiple invocations can share a file)
+		f, err := os.OpenFile(otlpFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format (suitable for node_exporter's "textfile" collector, for example); multiple runs of the same phase (several "pull"s, for example) are summed
+func (m *metricsRecorder) prometheus() []byte {
+	type series struct {
+		labels  string
+		seconds float64
+		bytes   int64
+		errors  int
+	}
+	all := []*series{}
+	byLabels := map[string]*series{}
+	for _, em := range m.Entries {
+		for _, p := range em.Phases {
+			labels := fmt.Sprintf(`repo="%s",entry="%s",arch="%s",phase="%s"`,
+				prometheusLabelEscaper.Replace(em.Repo),
+				prometheusLabelEscaper.Replace(em.Entry),
+				prometheusLabelEscaper.Replace(em.Arch),
+				prometheusLabelEscaper.Replace(p.Phase),
+			)
+			s, ok := byLabels[labels]
+			if !ok {
+				s = &series{labels: labels}
+				byLabels[labels] = s
+				all = append(all, s)
+			}
+			s.seconds += p.Seconds
+			s.bytes += p.Bytes
+			if p.Error != "" {
+				s.errors++
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "# HELP bashbrew_phase_duration_seconds Time spent in each phase of each entry (nested phases like \"import\" are also included in their parent, like \"build\").\n")
+	fmt.Fprintf(buf, "# TYPE bashbrew_phase_duration_seconds gauge\n")
+	for _, s := range all {
+		fmt.Fprintf(buf, "bashbrew_phase_duration_seconds{%s} %s\n", s.labels, strconv.FormatFloat(s.seconds, 'f', -1, 64))
+	}
+	fmt.Fprintf(buf, "# HELP bashbrew_phase_bytes Bytes processed by each phase of each entry (build context size, layers pushed, etc).\n")
+	fmt.Fprintf(buf, "# TYPE bashbrew_phase_bytes gauge\n")
+	for _, s := range all {
+		if s.bytes > 0 {
+			fmt.Fprintf(buf, "bashbrew_phase_bytes{%s} %d\n", s.labels, s.bytes)
+		}
+	}
+	fmt.Fprintf(buf, "# HELP bashbrew_phase_errors Number of times each phase of each entry failed.\n")
+	fmt.Fprintf(buf, "# TYPE bashbrew_phase_errors gauge\n")
+	for _, s := range all {
+		fmt.Fprintf(buf, "bashbrew_phase_errors{%s} %d\n", s.labels, s.errors)
+	}
+	fmt.Fprintf(buf, "# HELP bashbrew_last_run_timestamp_seconds When the \"bashbrew\" invocation that produced these metrics finished.\n")
+	fmt.Fprintf(buf, "# TYPE bashbrew_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(buf, "bashbrew_last_run_timestamp_seconds{command=\"%s\"} %d\n", prometheusLabelEscaper.Replace(m.Command), m.end.Unix())
+	return buf.Bytes()
+}
+
+func otlpID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand does not fail (https://github.com/golang/go/issues/66821)
+	}
+	return hex.EncodeToString(b)
+}
+
+func otlpAttributes(attrs ...string) []any {
+	ret := []any{}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		ret = append(ret, map[string]any{
+			"key":   attrs[i],
+			"value": map[string]any{"stringValue": attrs[i+1]},
+		})
+	}
+	return ret
+}
+
+// a single trace (one span per invocation, with a child span per entry, with a child span per phase) in the OTLP/JSON encoding of "ExportTraceServiceRequest" (https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding), which is what the collector's "otlpjsonfile" receiver reads
+func (m *metricsRecorder) otlp() ([]byte, error) {
+	traceID := otlpID(16)
+	span := func(spanID, parentSpanID, name string, start, end time.Time, err string, attrs []any) map[string]any {
+		s := map[string]any{
+			"traceId":           traceID,
+			"spanId":            spanID,
+			"name":              name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(end.UnixNano(), 10),
+			"attributes":        attrs,
+		}
+		if parentSpanID != "" {
+			s["parentSpanId"] = parentSpanID
+		}
+		if err != "" {
+			s["status"] = map[string]any{"code": 2, "message": err} // STATUS_CODE_ERROR
+		}
+		return s
+	}
+
+	rootID := otlpID(8)
+	spans := []any{span(rootID, "", "bashbrew "+m.Command, m.Start, m.end, "", otlpAttributes("bashbrew.command", m.Command))}
+	for _, em := range m.Entries {
+		entryID := otlpID(8)
+		phaseIDs := map[*metricsPhase]string{}
+		var start, end time.Time
+		entryAttrs := otlpAttributes("bashbrew.repo", em.Repo, "bashbrew.entry", em.Entry, "bashbrew.arch", em.Arch)
+		for _, p := range em.Phases {
+			if p.end.IsZero() {
+				// never finished (we must've bailed on an error somewhere)
+				continue
+			}
+			if start.IsZero() || p.Start.Before(start) {
+				start = p.Start
+			}
+			if p.end.After(end) {
+				end = p.end
+			}
+			phaseIDs[p] = otlpID(8)
+			parentID := entryID
+			if p.parent != nil && phaseIDs[p.parent] != "" {
+				parentID = phaseIDs[p.parent]
+			}
+			attrs := append(otlpAttributes(), entryAttrs...)
+			if p.Bytes > 0 {
+				attrs = append(attrs, map[string]any{
+					"key":   "bashbrew.bytes",
+					"value": map[string]any{"intValue": strconv.FormatInt(p.Bytes, 10)},
+				})
+			}
+			spans = append(spans, span(phaseIDs[p], parentID, p.Phase, p.Start, p.end, p.Error, attrs))
+		}
+		if start.IsZero() {
+			continue
+		}
+		spans = append(spans, span(entryID, rootID, em.Entry+" ("+em.Arch+")", start, end, "", entryAttrs))
+	}
+
+	return json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes("service.name", "bashbrew", "service.version", version),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "bashbrew"},
+				"spans": spans,
+			}},
+		}},
+	})
+}
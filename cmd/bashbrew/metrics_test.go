@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker-library/bashbrew/manifest"
+)
+
+// records a small run: "python:3.12" on amd64 (a "build" with an "import" inside it, and two "pull"s, one of which failed) and a repo with an awkward name on arm64v8 (a "push", plus a "build" that never finished)
+func testMetricsRecorder(t *testing.T) *metricsRecorder {
+	t.Helper()
+
+	origArch := arch
+	defer func() { arch = origArch }()
+
+	m := newMetricsRecorder("build")
+
+	python := Repo{RepoName: "python"}
+	pythonEntry := &manifest.Manifest2822Entry{Tags: []string{"3.12"}}
+	arch = "amd64"
+	em := m.Entry(python, pythonEntry)
+	build := em.Start("build")
+	imp := em.Start("import")
+	if cur := em.Current(); cur != imp {
+		t.Fatalf("expected current phase to be %q; got %+v", "import", cur)
+	}
+	imp.AddBytes(100)
+	imp.End(nil)
+	build.End(nil)
+	em.Start("pull").End(errors.New("boom"))
+	em.Start("pull").End(nil)
+	if m.Entry(python, pythonEntry) != em {
+		t.Fatal("expected the same entry on the same architecture")
+	}
+
+	odd := Repo{RepoName: "odd\"repo\\name\n"}
+	oddEntry := &manifest.Manifest2822Entry{Tags: []string{"latest"}}
+	arch = "arm64v8"
+	em = m.Entry(odd, oddEntry)
+	push := em.Start("push")
+	push.Write([]byte("hello"))
+	push.End(nil)
+	em.Start("build") // (never ended)
+
+	// make the timings predictable
+	for _, em := range m.Entries {
+		for _, p := range em.Phases {
+			p.Seconds = 1.5
+		}
+	}
+	m.end = time.Unix(1700000000, 0)
+
+	return m
+}
+
+func TestMetricsPrometheus(t *testing.T) {
+	m := testMetricsRecorder(t)
+	expected := `# HELP bashbrew_phase_duration_seconds Time spent in each phase of each entry (nested phases like "import" are also included in their parent, like "build").
+# TYPE bashbrew_phase_duration_seconds gauge
+bashbrew_phase_duration_seconds{repo="python",entry="python:3.12",arch="amd64",phase="build"} 1.5
+bashbrew_phase_duration_seconds{repo="python",entry="python:3.12",arch="amd64",phase="import"} 1.5
+bashbrew_phase_duration_seconds{repo="python",entry="python:3.12",arch="amd64",phase="pull"} 3
+bashbrew_phase_duration_seconds{repo="odd\"repo\\name\n",entry="odd\"repo\\name\n:latest",arch="arm64v8",phase="push"} 1.5
+bashbrew_phase_duration_seconds{repo="odd\"repo\\name\n",entry="odd\"repo\\name\n:latest",arch="arm64v8",phase="build"} 1.5
+# HELP bashbrew_phase_bytes Bytes processed by each phase of each entry (build context size, layers pushed, etc).
+# TYPE bashbrew_phase_bytes gauge
+bashbrew_phase_bytes{repo="python",entry="python:3.12",arch="amd64",phase="import"} 100
+bashbrew_phase_bytes{repo="odd\"repo\\name\n",entry="odd\"repo\\name\n:latest",arch="arm64v8",phase="push"} 5
+# HELP bashbrew_phase_errors Number of times each phase of each entry failed.
+# TYPE bashbrew_phase_errors gauge
+bashbrew_phase_errors{repo="python",entry="python:3.12",arch="amd64",phase="build"} 0
+bashbrew_phase_errors{repo="python",entry="python:3.12",arch="amd64",phase="import"} 0
+bashbrew_phase_errors{repo="python",entry="python:3.12",arch="amd64",phase="pull"} 1
+bashbrew_phase_errors{repo="odd\"repo\\name\n",entry="odd\"repo\\name\n:latest",arch="arm64v8",phase="push"} 0
+bashbrew_phase_errors{repo="odd\"repo\\name\n",entry="odd\"repo\\name\n:latest",arch="arm64v8",phase="build"} 0
+# HELP bashbrew_last_run_timestamp_seconds When the "bashbrew" invocation that produced these metrics finished.
+# TYPE bashbrew_last_run_timestamp_seconds gauge
+bashbrew_last_run_timestamp_seconds{command="build"} 1700000000
+`
+	if got := string(m.prometheus()); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestMetricsOTLP(t *testing.T) {
+	m := testMetricsRecorder(t)
+	b, err := m.otlp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type attribute struct {
+		Key   string            `json:"key"`
+		Value map[string]string `json:"value"`
+	}
+	type span struct {
+		TraceID      string      `json:"traceId"`
+		SpanID       string      `json:"spanId"`
+		ParentSpanID string      `json:"parentSpanId"`
+		Name         string      `json:"name"`
+		Attributes   []attribute `json:"attributes"`
+		Status       *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []span `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("expected a single resource and scope; got %s", b)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+
+	byID := map[string]span{}
+	for _, s := range spans {
+		if s.TraceID != spans[0].TraceID {
+			t.Errorf("%q: expected trace %q; got %q", s.Name, spans[0].TraceID, s.TraceID)
+		}
+		if _, ok := byID[s.SpanID]; ok {
+			t.Errorf("%q: duplicate span ID %q", s.Name, s.SpanID)
+		}
+		byID[s.SpanID] = s
+	}
+	attr := func(s span, key string) map[string]string {
+		for _, a := range s.Attributes {
+			if a.Key == key {
+				return a.Value
+			}
+		}
+		return nil
+	}
+
+	// each span (phases prefixed by their entry, since phase names repeat), mapped to its parent (the two "pull"s share one)
+	tree := map[string]string{}
+	name := func(s span) string {
+		if s.ParentSpanID == "" || strings.HasSuffix(s.Name, ")") {
+			return s.Name
+		}
+		return attr(s, "bashbrew.entry")["stringValue"] + " " + s.Name
+	}
+	for _, s := range spans {
+		parent := ""
+		if s.ParentSpanID != "" {
+			p, ok := byID[s.ParentSpanID]
+			if !ok {
+				t.Errorf("%q: unknown parent span %q", s.Name, s.ParentSpanID)
+			}
+			parent = name(p)
+		}
+		if prev, ok := tree[name(s)]; ok && prev != parent {
+			t.Errorf("%q: conflicting parents %q and %q", name(s), prev, parent)
+		}
+		tree[name(s)] = parent
+	}
+	expected := map[string]string{
+		"bashbrew build":                     "",
+		"python:3.12 (amd64)":                "bashbrew build",
+		"python:3.12 build":                  "python:3.12 (amd64)",
+		"python:3.12 import":                 "python:3.12 build",
+		"python:3.12 pull":                   "python:3.12 (amd64)",
+		"odd\"repo\\name\n:latest (arm64v8)": "bashbrew build",
+		"odd\"repo\\name\n:latest push":      "odd\"repo\\name\n:latest (arm64v8)",
+		// (the unfinished "build" is left out)
+	}
+	if len(tree) != len(expected) {
+		t.Errorf("expected %d distinct spans; got %d: %q", len(expected), len(tree), tree)
+	}
+	for name, parent := range expected {
+		if got, ok := tree[name]; !ok {
+			t.Errorf("%q: missing", name)
+		} else if got != parent {
+			t.Errorf("%q: expected parent %q; got %q", name, parent, got)
+		}
+	}
+	if len(spans) != 8 {
+		t.Errorf("expected 8 spans; got %d", len(spans))
+	}
+
+	errored := 0
+	for _, s := range spans {
+		if bytes := attr(s, "bashbrew.bytes")["intValue"]; (name(s) == "python:3.12 import" && bytes != "100") || (name(s) == "odd\"repo\\name\n:latest push" && bytes != "5") || (strings.HasSuffix(s.Name, ")") && bytes != "") {
+			t.Errorf("%q: unexpected bytes %q", name(s), bytes)
+		}
+		if s.Status != nil {
+			errored++
+			if s.Name != "pull" || s.Status.Code != 2 || s.Status.Message != "boom" {
+				t.Errorf("%q: unexpected status %+v", s.Name, *s.Status)
+			}
+		}
+	}
+	if errored != 1 {
+		t.Errorf("expected a single failed span; got %d", errored)
+	}
+}
+
+func TestMetricsWriteFiles(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile, prometheusFile, otlpFile := filepath.Join(dir, "metrics.json"), filepath.Join(dir, "metrics.prom"), filepath.Join(dir, "traces.jsonl")
+
+	// the nil recorder (no "--metrics*" flags) does nothing at all
+	var m *metricsRecorder
+	em := m.Entry(Repo{RepoName: "python"}, &manifest.Manifest2822Entry{Tags: []string{"3.12"}})
+	p := em.Start("build")
+	p.AddBytes(1)
+	if n, err := p.Write([]byte("hello")); n != 5 || err != nil {
+		t.Errorf("expected (5, nil); got (%d, %v)", n, err)
+	}
+	if em.Current() != nil {
+		t.Errorf("expected no current phase")
+	}
+	boom := errors.New("boom")
+	if err := p.End(boom); err != boom {
+		t.Errorf("expected End to return its error; got %v", err)
+	}
+	if err := m.WriteFiles(jsonFile, prometheusFile, otlpFile); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no files; got %d", len(files))
+	}
+
+	m = testMetricsRecorder(t)
+	for i := 0; i < 2; i++ {
+		if err := m.WriteFiles(jsonFile, prometheusFile, otlpFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Command string `json:"command"`
+		Entries []struct {
+			Entry  string `json:"entry"`
+			Phases []struct {
+				Phase  string `json:"phase"`
+				Parent string `json:"parent"`
+			} `json:"phases"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Command != "build" || len(decoded.Entries) != 2 || decoded.Entries[0].Phases[1].Parent != "build" {
+		t.Errorf("unexpected JSON: %s", b)
+	}
+
+	b, err = os.ReadFile(prometheusFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "# HELP bashbrew_phase_duration_seconds ") {
+		t.Errorf("unexpected Prometheus output: %s", b)
+	}
+
+	// OTLP is appended (one request per line)
+	b, err = os.ReadFile(otlpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines; got %d", len(lines))
+	}
+	for _, line := range lines {
+		if !json.Valid([]byte(line)) {
+			t.Errorf("invalid JSON: %s", line)
+		}
+	}
+}
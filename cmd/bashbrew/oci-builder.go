@@ -1,17 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	iofs "io/fs"
+	"maps"
 	"os"
 	"os/exec"
 	"path"
+	"sync/atomic"
 
+	"github.com/docker-library/bashbrew/manifest"
 	"github.com/docker-library/bashbrew/registry"
 
+	"github.com/opencontainers/go-digest"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	// thanks, go-digest...
 	_ "crypto/sha256"
@@ -87,8 +92,86 @@ func importOCIBlob(ctx context.Context, cs content.Store, fs iofs.FS, descriptor
 	return content.WriteBlob(ctx, cs, ingestRef, r, descriptor)
 }
 
-// this is "docker build" but for "Builder: oci-import"
-func ociImportBuild(tags []string, commit, dir, file string) (*imagespec.Descriptor, error) {
+// given a containerd content store and a manifest descriptor, write a copy of the manifest with the given annotations added (overriding any existing annotations of the same name) and return the new descriptor
+func annotateManifest(ctx context.Context, cs content.Store, desc imagespec.Descriptor, annotations map[string]string) (imagespec.Descriptor, error) {
+	b, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return desc, err
+	}
+
+	// we use a generic map here (instead of "imagespec.Manifest") so that we don't drop any fields our version of the image-spec doesn't know about
+	var manifest map[string]json.RawMessage
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return desc, err
+	}
+	merged := map[string]string{}
+	if raw, ok := manifest["annotations"]; ok {
+		if err := json.Unmarshal(raw, &merged); err != nil {
+			return desc, err
+		}
+	}
+	maps.Copy(merged, annotations)
+	manifest["annotations"], err = json.Marshal(merged)
+	if err != nil {
+		return desc, err
+	}
+	b, err = json.Marshal(manifest)
+	if err != nil {
+		return desc, err
+	}
+
+	desc.Digest = digest.FromBytes(b)
+	desc.Size = int64(len(b))
+	if err := content.WriteBlob(ctx, cs, string(desc.Digest), bytes.NewReader(b), desc); err != nil {
+		return desc, err
+	}
+	return desc, nil
+}
+
+// "Builder: oci-import" (see "ociImportBuild"), whose results only ever live in containerd's image store
+type ociImportBuilder struct{}
+
+func init() {
+	registerBuilder("oci-import", ociImportBuilder{})
+}
+
+func (ociImportBuilder) Build(r Repo, entry *manifest.Manifest2822Entry, tags []string, opts buildOptions) error {
+	commit, err := r.fetchGitRepo(arch, entry)
+	if err != nil {
+		return fmt.Errorf(`failed fetching git repo: %w`, err)
+	}
+
+	annotations, err := r.ArchAnnotations(arch, entry, opts.Pins)
+	if err != nil {
+		return fmt.Errorf(`failed calculating annotations: %w`, err)
+	}
+
+	desc, err := ociImportBuild(tags, commit, entry.ArchDirectory(arch), entry.ArchFile(arch), annotations)
+	if err != nil {
+		return err
+	}
+
+	if imageTags := tags[1:]; len(imageTags) > 0 {
+		fmt.Printf("Importing %s (%s) into Docker\n", r.EntryIdentifier(entry), desc.Digest)
+		phase := metrics.Entry(r, entry).Start("import")
+		if err := phase.End(containerdDockerLoad(*desc, imageTags)); err != nil {
+			return fmt.Errorf(`failed oci-import into Docker: %w`, err)
+		}
+	}
+
+	return nil
+}
+
+func (ociImportBuilder) Lookup(tag string) (*builtImage, error) {
+	return containerdBuiltImageLookup(tag)
+}
+
+func (ociImportBuilder) Push(r Repo, entry *manifest.Manifest2822Entry, img *builtImage, tags []string, opts pushOptions) ([]string, error) {
+	return containerdBuiltImagePush(r, entry, img, tags, opts)
+}
+
+// this is "docker build" but for "Builder: oci-import" (with the given annotations added to the imported manifest)
+func ociImportBuild(tags []string, commit, dir, file string, annotations map[string]string) (*imagespec.Descriptor, error) {
 	// TODO use r.archGitFS (we have no r or arch or entry here 😅)
 	fs, err := gitCommitFS(commit)
 	if err != nil {
@@ -180,31 +263,47 @@ func ociImportBuild(tags []string, commit, dir, file string) (*imagespec.Descrip
 		}
 	}
 
+	if len(annotations) > 0 {
+		manifestDescriptor, err = annotateManifest(ctx, cs, manifestDescriptor, annotations)
+		if err != nil {
+			return nil, fmt.Errorf("failed to annotate manifest %s: %w", errFileStr(string(manifestDescriptor.Digest)), err)
+		}
+	}
+
+	if err := containerdTagImage(ctx, client, manifestDescriptor, tags); err != nil {
+		return nil, err
+	}
+
+	return &manifestDescriptor, nil
+}
+
+// points every one of "tags" at the given descriptor in the containerd image store (creating or updating them as necessary)
+func containerdTagImage(ctx context.Context, client *containerd.Client, desc imagespec.Descriptor, tags []string) error {
 	is := client.ImageService()
 
 	for _, tag := range tags {
 		ref, err := docker.ParseAnyReference(tag)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse tag %q while updating image in containerd: %w", tag, err)
+			return fmt.Errorf("failed to parse tag %q while updating image in containerd: %w", tag, err)
 		}
 		img := images.Image{
 			Name:   ref.String(),
-			Target: manifestDescriptor,
+			Target: desc,
 		}
 		img2, err := is.Update(ctx, img, "target") // "target" here is to specify that we want to update the descriptor that "Name" points to (if this image name already exists)
 		if err != nil {
 			if !errdefs.IsNotFound(err) {
-				return nil, fmt.Errorf("failed to update image %q in containerd: %w", img.Name, err)
+				return fmt.Errorf("failed to update image %q in containerd: %w", img.Name, err)
 			}
 			img2, err = is.Create(ctx, img)
 			if err != nil {
-				return nil, fmt.Errorf("failed to create image %q in containerd: %w", img.Name, err)
+				return fmt.Errorf("failed to create image %q in containerd: %w", img.Name, err)
 			}
 		}
 		img = img2 // unnecessary? :)
 	}
 
-	return &manifestDescriptor, nil
+	return nil
 }
 
 // `ctr image import` (used when interfacing with buildx build + SBOMs that Docker can't represent correctly)
@@ -304,6 +403,60 @@ func containerdImageLookup(tag string) (*imagespec.Descriptor, error) {
 	return &img.Target, nil
 }
 
+// removes the given tag from the containerd image store (the content it points to is left for containerd's garbage collection; see "images.SynchronousDelete"), returning no error if it does not exist
+func containerdImageDelete(tag string) error {
+	ctx := context.Background()
+
+	ctx, client, err := newContainerdClient(ctx)
+	if err != nil {
+		return err
+	}
+	// NO: defer client.Close()
+
+	ref, err := docker.ParseAnyReference(tag)
+	if err != nil {
+		return fmt.Errorf("failed to parse tag %q while deleting containerd image: %w", tag, err)
+	}
+
+	if err := client.ImageService().Delete(ctx, ref.String(), images.SynchronousDelete()); err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// returns the digests of the image manifests of the given containerd image (the image itself if it's a manifest, the non-attestation children if it's an index)
+func containerdImageManifestDigests(desc imagespec.Descriptor) ([]string, error) {
+	if !images.IsIndexType(desc.MediaType) {
+		return []string{desc.Digest.String()}, nil
+	}
+
+	ctx := context.Background()
+
+	ctx, client, err := newContainerdClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// NO: defer client.Close()
+
+	b, err := content.ReadBlob(ctx, client.ContentStore(), desc)
+	if err != nil {
+		return nil, err
+	}
+	var index imagespec.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, err
+	}
+
+	digests := []string{}
+	for _, child := range index.Manifests {
+		if isAttestationManifest(child) {
+			continue
+		}
+		digests = append(digests, child.Digest.String())
+	}
+	return digests, nil
+}
+
 // given a descriptor and a list of tags (intended for pushing), return the set of those that are up-to-date (`skip`) and those that need-update (`update`)
 func containerdPushFilter(desc imagespec.Descriptor, destinationTags []string) (skip, update []string, err error) {
 	ctx := context.Background()
@@ -328,29 +481,29 @@ func containerdPushFilter(desc imagespec.Descriptor, destinationTags []string) (
 	return skip, update, nil
 }
 
-// given a descriptor and a list of tags, push the content from containerd's content store to the appropriate registry
-func containerdPush(desc imagespec.Descriptor, destinationTags []string) error {
+// given a descriptor and a list of tags, push the content from containerd's content store to the appropriate registry (returning the number of layer bytes that actually had to be uploaded)
+func containerdPush(desc imagespec.Descriptor, destinationTags []string) (int64, error) {
 	ctx := context.Background()
 
 	ctx, client, err := newContainerdClient(ctx)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	// NO: defer client.Close()
 
-	cs := client.ContentStore()
+	cs := &layerCountingStore{Store: client.ContentStore()}
 
 	resolver := registry.NewDockerAuthResolver()
 
 	for _, tag := range destinationTags {
 		ref, err := docker.ParseAnyReference(tag)
 		if err != nil {
-			return err
+			return cs.bytes.Load(), err
 		}
 
 		pusher, err := resolver.Pusher(ctx, ref.String())
 		if err != nil {
-			return err
+			return cs.bytes.Load(), err
 		}
 
 		// add the "tag" annotation to our descriptor so that containerd's pusher code does the right thing and pushes *every* tag (even though our Pusher is scoped to this tag, without this it will "cleverly" avoid pushing tag 2+ because the digest we're pushing already exists in the repository)
@@ -359,9 +512,23 @@ func containerdPush(desc imagespec.Descriptor, destinationTags []string) error {
 
 		err = remotes.PushContent(ctx, pusher, desc, cs, nil, nil, nil)
 		if err != nil {
-			return err
+			return cs.bytes.Load(), err
 		}
 	}
 
-	return nil
+	return cs.bytes.Load(), nil
+}
+
+// a content store that counts the bytes of layers read out of it; "remotes.PushContent" only reads a blob when the registry doesn't already have it, so this ends up being the number of layer bytes actually pushed (see "containerdPush")
+type layerCountingStore struct {
+	content.Store
+	bytes atomic.Int64
+}
+
+func (cs *layerCountingStore) ReaderAt(ctx context.Context, desc imagespec.Descriptor) (content.ReaderAt, error) {
+	ra, err := cs.Store.ReaderAt(ctx, desc)
+	if err == nil && images.IsLayerType(desc.MediaType) {
+		cs.bytes.Add(desc.Size)
+	}
+	return ra, err
 }
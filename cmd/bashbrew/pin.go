@@ -0,0 +1,105 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/docker-library/bashbrew/manifest"
+	"github.com/docker-library/bashbrew/pkg/dockerfile"
+	"github.com/docker-library/bashbrew/registry"
+
+	"github.com/containerd/containerd/reference/docker"
+)
+
+// FROM value => "name:tag@digest" (for the current "arch"; see "pinFrom")
+var pinnedFromCache = map[string]string{}
+
+// resolves the given FROM value (ala "debian:bookworm") in the registry to the exact image for the current "arch", returning a reference to it by digest (ala "debian:bookworm@sha256:...") -- each FROM is only resolved once per run, so every entry built FROM the same tag in this run gets the same image, even if the tag moves while we're building (see "build --pin-froms")
+func pinFrom(from string) (string, error) {
+	if pinned, ok := pinnedFromCache[from]; ok {
+		return pinned, nil
+	}
+
+	ref, err := docker.ParseNormalizedNamed(from)
+	if err != nil {
+		return "", fmt.Errorf("failed parsing FROM %q: %w", from, err)
+	}
+	ref = docker.TagNameOnly(ref)
+	if _, ok := ref.(docker.Digested); ok {
+		// already pinned
+		pinnedFromCache[from] = from
+		return from, nil
+	}
+
+	obj, err := registry.ResolveArch(context.Background(), ref.String(), arch)
+	if err != nil {
+		return "", err
+	}
+	pinnedRef, err := docker.WithDigest(ref, obj.Desc.Digest)
+	if err != nil {
+		return "", err
+	}
+	pinned := docker.FamiliarString(pinnedRef)
+
+	pinnedFromCache[from] = pinned
+
+	return pinned, nil
+}
+
+// returns the contents of the given entry's Dockerfile with every FROM in "pins" replaced by its pinned value (see "dockerfile.Pin")
+func (r Repo) archPinnedDockerfile(arch string, entry *manifest.Manifest2822Entry, pins map[string]string) (string, error) {
+	commit, err := r.fetchGitRepo(arch, entry)
+	if err != nil {
+		return "", err
+	}
+	file := path.Join(entry.ArchDirectory(arch), entry.ArchFile(arch))
+	df, err := gitShow(commit, file)
+	if err != nil {
+		return "", fmt.Errorf(`failed "git show" for %q from commit %q: %w`, file, commit, err)
+	}
+	return dockerfile.Pin(df, pins), nil
+}
+
+// streams the given tar archive, but with the contents of the given file replaced (so we can hand builders a rewritten Dockerfile in the same build context "gitArchive" generates)
+func tarReplaceFile(in io.ReadCloser, name string, contents []byte) io.ReadCloser {
+	name = path.Clean(name)
+	pr, pw := io.Pipe()
+	go func() {
+		defer in.Close()
+		pw.CloseWithError(func() error {
+			tr := tar.NewReader(in)
+			tw := tar.NewWriter(pw)
+			found := false
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				var body io.Reader = tr
+				if hdr.Typeflag == tar.TypeReg && path.Clean(hdr.Name) == name {
+					found = true
+					hdr.Size = int64(len(contents))
+					body = bytes.NewReader(contents)
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				if _, err := io.Copy(tw, body); err != nil {
+					return err
+				}
+			}
+			if !found {
+				return fmt.Errorf("%q not found in archive", name)
+			}
+			return tw.Close()
+		}())
+	}()
+	return pr
+}
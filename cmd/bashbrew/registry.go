@@ -98,3 +98,40 @@ func fetchRegistryManiestListDigests(image string) []string {
 	}
 	return digests
 }
+
+// signs whatever the given image currently points to in the registry (and each image manifest of it, if it's an index) with the given key (see "--sign-key")
+func signRegistryImage(signer *registry.Signer, image string) error {
+	ctx := context.Background()
+
+	img, err := registry.Resolve(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	objs := []*registry.ResolvedObject{img}
+	if img.IsImageIndex() {
+		manifests, err := img.Manifests(ctx)
+		if err != nil {
+			return err
+		}
+		for _, manifestDesc := range manifests {
+			if isAttestationManifest(manifestDesc) {
+				// no point in signing BuildKit's attestations separately (they're covered by the signature of the index)
+				continue
+			}
+			objs = append(objs, img.At(manifestDesc))
+		}
+	}
+
+	for _, obj := range objs {
+		sig, err := obj.Sign(ctx, signer)
+		if err != nil {
+			return fmt.Errorf("failed signing %s: %w", obj.Desc.Digest, err)
+		}
+		if debugFlag {
+			fmt.Printf("DEBUG: signed %s (%s) -> %s\n", obj.Desc.Digest, image, sig.Digest)
+		}
+	}
+
+	return nil
+}
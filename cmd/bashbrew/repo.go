@@ -29,7 +29,19 @@ func repos(all bool, args ...string) ([]string, error) {
 		}
 	}
 
-	ret = append(ret, args...)
+	selections := []selection{}
+	for _, arg := range args {
+		if !isSelector(arg) {
+			selections = append(selections, selection{base: arg})
+			continue
+		}
+		selected, err := resolveSelector(arg)
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selected...)
+	}
+	ret = append(ret, mergeSelections(selections)...)
 
 	if len(ret) < 1 {
 		return nil, fmt.Errorf(`need at least one repo (either explicitly or via "--all")`)
@@ -84,6 +96,13 @@ func (r Repo) SkipConstraints(entry *manifest.Manifest2822Entry) bool {
 		return true
 	}
 
+	return r.SkipUnsatisfiedConstraints(entry)
+}
+
+// like "SkipConstraints", but ignoring "arch" (for commands like "promote" which act on every architecture at once)
+func (r Repo) SkipUnsatisfiedConstraints(entry *manifest.Manifest2822Entry) bool {
+	repoTag := r.RepoName + ":" + entry.Tags[0]
+
 	if len(entry.Constraints) == 0 {
 		if exclusiveConstraints {
 			if !haveOutputSkippedMessage[repoTag] {
@@ -94,31 +113,7 @@ func (r Repo) SkipConstraints(entry *manifest.Manifest2822Entry) bool {
 		return exclusiveConstraints
 	}
 
-	unsatisfactory := []string{}
-
-NextConstraint:
-	for _, eConstraint := range entry.Constraints {
-		wanted := true
-		if eConstraint[0] == '!' {
-			wanted = false
-			eConstraint = eConstraint[1:]
-		}
-
-		for _, gConstraint := range constraints {
-			if gConstraint == eConstraint {
-				// if we did not want "aufs" ("!aufs") but found it, UNSATISFACTORY
-				if !wanted {
-					unsatisfactory = append(unsatisfactory, eConstraint)
-				}
-				continue NextConstraint
-			}
-		}
-
-		// if we want "aufs" but did not find it, UNSATISFACTORY
-		if wanted {
-			unsatisfactory = append(unsatisfactory, eConstraint)
-		}
-	}
+	unsatisfactory := entry.UnsatisfiedConstraints(constraints)
 
 	if len(unsatisfactory) > 0 {
 		if !haveOutputSkippedMessage[repoTag] {
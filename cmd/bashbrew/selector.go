@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/docker-library/bashbrew/manifest"
+)
+
+// a "selector" argument (see "isSelector"): a comma-separated list of terms which all have to match for an entry to be selected, the first of which can be a "repo[:tag]" (where either half can be a glob, ala "python:3.1*" or "*:*-alpine")
+//
+//	arch=ARCH[|ARCH...]              the entry supports (at least one of) the given architectures
+//	maintainer=HANDLE[|HANDLE...]    the entry has (at least one of) the given maintainers (GitHub handle, with or without "@", or email)
+//	constraint=EXPR                  the entry's constraints satisfy the given constraint expression (see "manifest.Constraint"; the entry's constraints are treated as "--constraint" values: the names they mention, plus "NAME=VERSION" for any version they compare against)
+//	builder=BUILDER[|BUILDER...]     the entry uses the given builder on at least one architecture ("classic" for the default)
+//	changed=REV                      the entry is new or builds something different than it did in Git revision REV of the manifest file (which needs to be in a Git repository, like the official images "library" directory)
+//	repo=REPO, tag=TAG               the same as the leading "repo[:tag]" term
+type selector struct {
+	repo, tag string
+
+	arches      []string
+	maintainers []string
+	constraints []manifest.Constraint
+	builders    []string
+	changed     string
+}
+
+// the keys of "KEY=VALUE" selector terms (see "selector")
+var selectorKeys = map[string]bool{
+	"repo": true, "tag": true,
+	"arch": true, "arches": true,
+	"maintainer": true, "maintainers": true,
+	"constraint": true, "constraints": true,
+	"builder": true, "builders": true,
+	"changed": true,
+}
+
+func isSelectorTerm(term string) bool {
+	key, _, ok := strings.Cut(term, "=")
+	return ok && selectorKeys[key]
+}
+
+// whether the given "repo" argument is a selector instead of a literal repo, "repo:tag", file path, or URL: either every term after the first is a known "KEY=VALUE" (and the first is too, or is a "repo[:tag]"), or it's a single "repo[:tag]" glob (which can't be a path, since it has no "/", and can't be a literal "repo:tag", since those can't contain glob characters)
+func isSelector(arg string) bool {
+	if _, err := os.Stat(arg); err == nil {
+		// an existing file is never a selector, no matter what it's named
+		return false
+	}
+	terms := strings.Split(arg, ",")
+	if len(terms) > 1 {
+		for _, term := range terms[1:] {
+			if !isSelectorTerm(term) {
+				return false
+			}
+		}
+		return true
+	}
+	if isSelectorTerm(arg) {
+		return true
+	}
+	return !strings.ContainsAny(arg, "/=") && strings.ContainsAny(arg, "*?[")
+}
+
+func parseSelector(arg string) (*selector, error) {
+	s := &selector{}
+	for i, term := range strings.Split(arg, ",") {
+		key, val, ok := strings.Cut(term, "=")
+		if !ok || strings.Contains(key, "://") {
+			if i != 0 {
+				return nil, fmt.Errorf("invalid selector %q: %q is not KEY=VALUE (only the first term can be a bare repo[:tag])", arg, term)
+			}
+			key, val = "repo", term
+		}
+		if val == "" {
+			return nil, fmt.Errorf("invalid selector %q: empty %q", arg, key)
+		}
+		vals := strings.Split(val, "|")
+		switch key {
+		case "repo":
+			// see "manifest.Fetch" (the tag is split off the last path component, so URLs and "localhost:5000/..." paths work)
+			s.repo = val
+			if i := strings.LastIndex(val, ":"); i > strings.LastIndex(val, "/") && !strings.HasSuffix(val[:i], ":/") {
+				s.repo, s.tag = val[:i], val[i+1:]
+			}
+		case "tag":
+			s.tag = val
+		case "arch", "arches":
+			s.arches = append(s.arches, vals...)
+		case "maintainer", "maintainers":
+			for _, val := range vals {
+				s.maintainers = append(s.maintainers, strings.TrimPrefix(val, "@"))
+			}
+		case "constraint", "constraints":
+			c, err := manifest.ParseConstraint(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector %q: %w", arg, err)
+			}
+			s.constraints = append(s.constraints, c)
+		case "builder", "builders":
+			s.builders = append(s.builders, vals...)
+		case "changed":
+			s.changed = val
+		default:
+			return nil, fmt.Errorf("invalid selector %q: unknown key %q", arg, key)
+		}
+	}
+	for _, glob := range []string{s.repo, s.tag} {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", arg, err)
+		}
+	}
+	return s, nil
+}
+
+// whether the selector has any terms beyond "repo" (and thus might select only some of a repo's entries)
+func (s selector) filtersEntries() bool {
+	return s.tag != "" || len(s.arches) > 0 || len(s.maintainers) > 0 || len(s.constraints) > 0 || len(s.builders) > 0 || s.changed != ""
+}
+
+func (s selector) matchEntry(entry *manifest.Manifest2822Entry) bool {
+	if s.tag != "" && !slices.ContainsFunc(append(slices.Clone(entry.Tags), entry.SharedTags...), func(tag string) bool {
+		ok, _ := path.Match(s.tag, tag)
+		return ok
+	}) {
+		return false
+	}
+	if len(s.arches) > 0 && !slices.ContainsFunc(s.arches, entry.HasArchitecture) {
+		return false
+	}
+	if len(s.maintainers) > 0 && !slices.ContainsFunc(entry.Maintainers, func(maint manifest.Manifest2822Maintainer) bool {
+		return slices.ContainsFunc(s.maintainers, func(want string) bool {
+			return strings.EqualFold(want, maint.Handle) || (maint.Email != "" && strings.EqualFold(want, maint.Email))
+		})
+	}) {
+		return false
+	}
+	if len(s.constraints) > 0 {
+		given := entryConstraintValues(entry)
+		for _, c := range s.constraints {
+			if !c.Satisfied(given) {
+				return false
+			}
+		}
+	}
+	if len(s.builders) > 0 && !slices.ContainsFunc(entry.Architectures, func(entryArch string) bool {
+		builder := entry.ArchBuilder(entryArch)
+		if builder == "" {
+			builder = "classic"
+		}
+		return slices.Contains(s.builders, builder)
+	}) {
+		return false
+	}
+	return true
+}
+
+// the entry's "Constraints" as "--constraint" values, for evaluating "constraint=" selector terms against (a negated term like "!aufs" means the entry doesn't have "aufs", so it contributes nothing)
+func entryConstraintValues(entry *manifest.Manifest2822Entry) []string {
+	ret := []string{}
+	for _, constraint := range entry.Constraints {
+		c, err := manifest.ParseConstraint(constraint)
+		if err != nil {
+			continue
+		}
+		for _, term := range c {
+			if term.Not {
+				continue
+			}
+			ret = append(ret, term.Name)
+			if term.Version != "" {
+				ret = append(ret, term.Name+"="+term.Version)
+			}
+		}
+	}
+	return ret
+}
+
+// the manifest files of the library a repo glob could refer to
+func libraryRepos(glob string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(defaultLibrary, glob))
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, name := range names {
+		if fi, err := os.Stat(name); err == nil && !fi.IsDir() {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// what a selector selected from one repo (see "resolveSelector")
+type selection struct {
+	// the "repo" value "fetch" knows the whole repo by
+	base string
+
+	// the (whole) repo, or nil for a literal "repo" argument that isn't a selector (which we don't fetch unless we have to; see "mergeSelections")
+	repo *Repo
+
+	// the tags ("Tags[0]") of the selected entries, or nil if the repo is selected in its entirety
+	tags map[string]bool
+}
+
+// resolves the given selector argument (see "selector") to what it selects from each repo it matches
+func resolveSelector(arg string) ([]selection, error) {
+	s, err := parseSelector(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	var bases []string
+	if s.repo == "" || strings.ContainsAny(s.repo, "*?[") && !strings.Contains(s.repo, "://") {
+		glob := s.repo
+		if glob == "" {
+			glob = "*"
+		}
+		bases, err = libraryRepos(glob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", arg, err)
+		}
+	} else {
+		bases = []string{s.repo}
+	}
+
+	ret := []selection{}
+	for _, base := range bases {
+		r, err := fetch(base)
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching repo %q (for selector %q): %w", base, arg, err)
+		}
+		if !s.filtersEntries() {
+			ret = append(ret, selection{base: base, repo: r})
+			continue
+		}
+
+		var changed func(entry *manifest.Manifest2822Entry) bool
+		if s.changed != "" {
+			changed, err = manifestChangedSince(base, s.changed)
+			if err != nil {
+				return nil, fmt.Errorf("failed comparing %q to %q (for selector %q): %w", base, s.changed, arg, err)
+			}
+		}
+
+		tags := map[string]bool{}
+		for _, entry := range r.Entries() {
+			if !s.matchEntry(entry) || (changed != nil && !changed(entry)) {
+				continue
+			}
+			tags[entry.Tags[0]] = true
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		if len(tags) == len(r.Manifest.Entries) {
+			tags = nil
+		}
+		ret = append(ret, selection{base: base, repo: r, tags: tags})
+	}
+
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("selector %q did not match anything", arg)
+	}
+	return ret, nil
+}
+
+// turns the given selections (in order) into a list of "repo" values "fetch" knows about: the repos themselves if they're selected in their entirety (by any selector, or by a literal "repo" argument), and otherwise a (cached) "Repo" object with only the selected entries under a unique name -- several selections from the same repo are merged into one, since they'd all have the same "Identifier" (and thus all but one would get lost in "depgraph", for example)
+func mergeSelections(selections []selection) []string {
+	whole := map[string]bool{}
+	if slices.ContainsFunc(selections, func(sel selection) bool { return sel.tags != nil }) {
+		partialRepos := map[string]bool{}
+		for _, sel := range selections {
+			if sel.repo != nil && sel.tags != nil {
+				partialRepos[sel.repo.RepoName] = true
+			}
+		}
+
+		selections = slices.Clone(selections)
+		for i, sel := range selections {
+			r := sel.repo
+			if r == nil {
+				// (if this fails, whatever ends up fetching it will report the error)
+				r, _ = fetch(sel.base)
+			}
+			if r == nil {
+				continue
+			}
+			if r.TagName != "" && partialRepos[r.RepoName] {
+				// a literal "repo:tag" next to a selector of the same repo gets merged with it like any other partial selection (otherwise its entries would show up twice)
+				tags := map[string]bool{}
+				for _, entry := range r.Entries() {
+					tags[entry.Tags[0]] = true
+				}
+				selections[i] = selection{
+					base: strings.TrimSuffix(sel.base, ":"+r.TagName),
+					repo: &Repo{RepoName: r.RepoName, Manifest: r.Manifest},
+					tags: tags,
+				}
+				continue
+			}
+			if r.TagName == "" && sel.tags == nil {
+				whole[r.RepoName] = true
+			}
+		}
+	}
+
+	ret := []string{}
+	partial := map[string]*selection{}
+	for _, sel := range selections {
+		if sel.tags == nil {
+			ret = append(ret, sel.base)
+			continue
+		}
+		if whole[sel.repo.RepoName] {
+			continue
+		}
+		if prev, ok := partial[sel.repo.RepoName]; ok {
+			for tag := range sel.tags {
+				prev.tags[tag] = true
+			}
+			continue
+		}
+		sel.tags = maps.Clone(sel.tags)
+		partial[sel.repo.RepoName] = &sel
+		ret = append(ret, sel.repo.RepoName+" (selected)")
+	}
+
+	for repoName, sel := range partial {
+		selected := *sel.repo.Manifest
+		selected.Entries = nil
+		for _, entry := range sel.repo.Entries() {
+			if sel.tags[entry.Tags[0]] {
+				selected.Entries = append(selected.Entries, *entry)
+			}
+		}
+		if len(selected.Entries) == len(sel.repo.Manifest.Entries) {
+			// several selectors that together select everything
+			ret[slices.Index(ret, repoName+" (selected)")] = sel.base
+			continue
+		}
+		repoCache[repoName+" (selected)"] = &Repo{
+			RepoName: repoName,
+			Manifest: &selected,
+		}
+	}
+
+	return ret
+}
+
+// returns a function for whether a given entry of the given manifest file is new or different (see "Manifest2822Entry.SameBuildArtifacts"), or has different tags, compared to the given Git revision of the file
+func manifestChangedSince(file string, rev string) (func(entry *manifest.Manifest2822Entry) bool, error) {
+	if _, err := os.Stat(file); err != nil {
+		// see "manifest.Fetch"
+		file = filepath.Join(defaultLibrary, file)
+	}
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := git("-C", filepath.Dir(file), "show", rev+":./"+filepath.Base(file))
+	if err != nil {
+		// TODO distinguish "didn't exist in this revision" (everything is new) from "not a Git repository" / "bad revision"
+		if _, revErr := git("-C", filepath.Dir(file), "rev-parse", "--verify", rev+"^{commit}"); revErr != nil {
+			return nil, revErr
+		}
+		return func(*manifest.Manifest2822Entry) bool { return true }, nil
+	}
+	oldMan, err := manifest.Parse(strings.NewReader(string(old)))
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing %q from %q: %w", file, rev, err)
+	}
+
+	return func(entry *manifest.Manifest2822Entry) bool {
+		oldEntry := oldMan.GetTag(entry.Tags[0])
+		// (tags have to be compared too, because entries with the same build artifacts get merged together by "manifest.Parse", so a new tag often shows up as an existing entry with an extra tag)
+		return oldEntry == nil || !oldEntry.SameBuildArtifacts(*entry) || !slices.Equal(oldEntry.Tags, entry.Tags) || !slices.Equal(oldEntry.SharedTags, entry.SharedTags)
+	}, nil
+}
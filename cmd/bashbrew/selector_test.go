@@ -0,0 +1,253 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker-library/bashbrew/manifest"
+)
+
+func TestIsSelector(t *testing.T) {
+	tests := map[string]bool{
+		"python":                           false,
+		"python:3.12":                      false,
+		"./library/python":                 false,
+		"https://example.com/python?x=y":   false,
+		"localhost:5000/python":            false,
+		"python:3.1*":                      true,
+		"*:*-alpine":                       true,
+		"pyth?n":                           true,
+		"arch=s390x":                       true,
+		"python,arch=s390x":                true,
+		"https://example.com/x,arch=s390x": true,
+		"python,":                          false, // (a trailing comma isn't anything)
+		"./library/py*":                    false, // file paths are never globs
+		"library/python[1]":                false,
+		"foo=bar":                          false, // not a selector key
+		"python:3.12=x":                    false,
+		"python,color=blue":                false,
+		"python,3.12":                      false,
+		"https://example.com/*":            false,
+		"tag=3*":                           true,
+	}
+	for arg, expected := range tests {
+		if got := isSelector(arg); got != expected {
+			t.Errorf("%q: expected %v; got %v", arg, expected, got)
+		}
+	}
+
+	// an existing file is a file, even if its name looks like a glob
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile("odd[1]", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if isSelector("odd[1]") {
+		t.Errorf("%q: expected a file, not a selector", "odd[1]")
+	}
+	if !isSelector("odd[2]") {
+		t.Errorf("%q: expected a selector", "odd[2]")
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	tests := map[string]selector{
+		"python:3.1*":                                   {repo: "python", tag: "3.1*"},
+		"*:*-alpine":                                    {repo: "*", tag: "*-alpine"},
+		"localhost:5000/python":                         {repo: "localhost:5000/python"},
+		"localhost:5000/python:3*":                      {repo: "localhost:5000/python", tag: "3*"},
+		"https://example.com/python:3*":                 {repo: "https://example.com/python", tag: "3*"},
+		"repo=python,tag=3*":                            {repo: "python", tag: "3*"},
+		"arch=amd64|arm64v8,arch=s390x":                 {arches: []string{"amd64", "arm64v8", "s390x"}},
+		"maintainer=@tianon|foo@bar.com":                {maintainers: []string{"tianon", "foo@bar.com"}},
+		"python,constraint=!windowsservercore-ltsc2022": {repo: "python", constraints: []manifest.Constraint{{{Not: true, Name: "windowsservercore-ltsc2022"}}}},
+		"constraint=docker>=24|podman,constraint=!aufs": {constraints: []manifest.Constraint{
+			{{Name: "docker", Op: ">=", Version: "24"}, {Name: "podman"}},
+			{{Not: true, Name: "aufs"}},
+		}},
+		"builders=buildkit|classic": {builders: []string{"buildkit", "classic"}},
+		"changed=HEAD~1":            {changed: "HEAD~1"},
+	}
+	for arg, expected := range tests {
+		t.Run(arg, func(t *testing.T) {
+			got, err := parseSelector(arg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(*got, expected) {
+				t.Errorf("expected %+v; got %+v", expected, *got)
+			}
+		})
+	}
+
+	for _, arg := range []string{
+		"arch=amd64,python", // bare repo after the first term
+		"arch=",             // empty value
+		"python,color=blue", // unknown key
+		"python:[",          // invalid glob
+		"constraint=a b",    // invalid constraint
+	} {
+		if got, err := parseSelector(arg); err == nil {
+			t.Errorf("%q: expected error; got %+v", arg, *got)
+		}
+	}
+}
+
+func TestSelectorMatchEntry(t *testing.T) {
+	man, err := manifest.Parse(strings.NewReader(`
+Maintainers: Foo (@foo)
+GitRepo: https://github.com/docker-library/python.git
+GitCommit: 0123456789abcdef0123456789abcdef01234567
+
+Tags: 3.12.1, 3.12, 3
+SharedTags: latest
+Architectures: amd64, arm64v8
+Directory: 3.12
+
+Tags: 3.12.1-alpine, 3.12-alpine
+Architectures: amd64, s390x
+Directory: 3.12/alpine
+Builder: buildkit
+Constraints: docker>=24, !aufs
+
+Tags: 3.12.1-windowsservercore-ltsc2022
+SharedTags: latest
+Maintainers: Bar <bar@example.com> (@bar)
+Architectures: windows-amd64
+Directory: 3.12/windows
+Constraints: windowsservercore-ltsc2022
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"python":                                 {"3.12.1", "3.12.1-alpine", "3.12.1-windowsservercore-ltsc2022"},
+		"python:3.12":                            {"3.12.1"},
+		"python:*-alpine":                        {"3.12.1-alpine"},
+		"python:latest":                          {"3.12.1", "3.12.1-windowsservercore-ltsc2022"}, // SharedTags count too
+		"arch=s390x|arm64v8":                     {"3.12.1", "3.12.1-alpine"},
+		"maintainer=@BAR":                        {"3.12.1-windowsservercore-ltsc2022"},
+		"maintainer=bar@example.com":             {"3.12.1-windowsservercore-ltsc2022"},
+		"constraint=windowsservercore-ltsc2022":  {"3.12.1-windowsservercore-ltsc2022"},
+		"constraint=!windowsservercore-ltsc2022": {"3.12.1", "3.12.1-alpine"},
+		"constraint=windowsservercore-ltsc2022|nanoserver-ltsc2022": {"3.12.1-windowsservercore-ltsc2022"},
+		"constraint=docker":                    {"3.12.1-alpine"},
+		"constraint=docker>=20":                {"3.12.1-alpine"},
+		"constraint=docker>=25":                {},
+		"constraint=aufs":                      {}, // ("!aufs" is the opposite of having it)
+		"constraint=!docker,constraint=!aufs":  {"3.12.1", "3.12.1-windowsservercore-ltsc2022"},
+		"builder=buildkit":                     {"3.12.1-alpine"},
+		"builder=classic":                      {"3.12.1", "3.12.1-windowsservercore-ltsc2022"},
+		"python:3*,arch=amd64,builder=classic": {"3.12.1"},
+		"python:3.11*":                         {},
+	}
+	for arg, expected := range tests {
+		t.Run(arg, func(t *testing.T) {
+			s, err := parseSelector(arg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for i := range man.Entries {
+				if s.matchEntry(&man.Entries[i]) {
+					got = append(got, man.Entries[i].Tags[0])
+				}
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %q; got %q", expected, got)
+			}
+		})
+	}
+}
+
+func TestMergeSelections(t *testing.T) {
+	man, err := manifest.Parse(strings.NewReader(`
+Maintainers: Foo (@foo)
+GitRepo: https://github.com/docker-library/python.git
+GitCommit: 0123456789abcdef0123456789abcdef01234567
+
+Tags: 3.12
+Directory: 3.12
+
+Tags: 3.11
+Directory: 3.11
+
+Tags: 3.10
+Directory: 3.10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	python := &Repo{RepoName: "python", Manifest: man}
+
+	origCache := repoCache
+	defer func() { repoCache = origCache }()
+	repoCache = map[string]*Repo{"python": python}
+	for i := range man.Entries {
+		// (literal "python:TAG" arguments)
+		repoCache["python:"+man.Entries[i].Tags[0]] = python.EntryRepo(&man.Entries[i])
+	}
+
+	selected := func(tags ...string) selection {
+		sel := selection{base: "python", repo: python, tags: map[string]bool{}}
+		for _, tag := range tags {
+			sel.tags[tag] = true
+		}
+		return sel
+	}
+
+	// two selectors on the same repo ("python:3.12*" and "python:3.10*") should end up as a single repo with both of their entries (in manifest order)
+	got := mergeSelections([]selection{selected("3.10"), selected("3.12")})
+	if len(got) != 1 {
+		t.Fatalf("expected a single repo; got %q", got)
+	}
+	r, err := fetch(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := []string{}
+	for _, entry := range r.Entries() {
+		tags = append(tags, entry.Tags[0])
+	}
+	if expected := []string{"3.12", "3.10"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %q; got %q", expected, tags)
+	}
+	if r.Identifier() != "python" {
+		t.Errorf("expected identifier %q; got %q", "python", r.Identifier())
+	}
+
+	// ... and if together they select everything, that's just the repo
+	if got := mergeSelections([]selection{selected("3.10", "3.11"), selected("3.12")}); !reflect.DeepEqual(got, []string{"python"}) {
+		t.Errorf("expected %q; got %q", []string{"python"}, got)
+	}
+
+	// a literal "repo:tag" next to a selector of the same repo is merged with it (without duplicating the entry they both select)
+	got = mergeSelections([]selection{selected("3.12"), {base: "python:3.12"}, {base: "python:3.10"}})
+	if len(got) != 1 {
+		t.Fatalf("expected a single repo; got %q", got)
+	}
+	r, err = fetch(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags = []string{}
+	for _, entry := range r.Entries() {
+		tags = append(tags, entry.Tags[0])
+	}
+	if expected := []string{"3.12", "3.10"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %q; got %q", expected, tags)
+	}
+
+	// ... but literals on their own are left alone
+	if got := mergeSelections([]selection{{base: "python:3.12"}, {base: "python:3.10"}}); !reflect.DeepEqual(got, []string{"python:3.12", "python:3.10"}) {
+		t.Errorf("expected %q; got %q", []string{"python:3.12", "python:3.10"}, got)
+	}
+
+	// a selector next to the literal repo is redundant
+	if got := mergeSelections([]selection{selected("3.11"), {base: "python"}}); !reflect.DeepEqual(got, []string{"python"}) {
+		t.Errorf("expected %q; got %q", []string{"python"}, got)
+	}
+}
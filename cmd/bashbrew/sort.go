@@ -1,8 +1,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
 	"github.com/docker-library/bashbrew/manifest"
-	"pault.ag/go/topsort"
+	"github.com/docker-library/bashbrew/pkg/depgraph"
+
+	"github.com/urfave/cli"
 )
 
 // TODO unify archFilter and applyConstraints handling by pre-filtering the full list of Repo objects such that all that remains are things we should process (thus removing all "if" statements throughout the various loops); re-doing the Architectures and Entries lists to only include ones we should process, etc
@@ -35,7 +42,13 @@ func sortRepos(repos []string, applyConstraints bool) ([]string, error) {
 
 	ret := []string{}
 	for _, r := range rs {
-		ret = append(ret, rsMap[r])
+		repo, ok := rsMap[r]
+		if !ok {
+			// "sortRepoObjects" had to split a repo into individual entries to get around a cycle, so we need to give each of them a name "fetch" knows
+			repo = r.Identifier()
+			repoCache[repo] = r
+		}
+		ret = append(ret, repo)
 	}
 	return ret, nil
 }
@@ -72,31 +85,26 @@ func sortRepoObjects(rs []*Repo, applyConstraints bool) ([]*Repo, error) {
 		return rs, nil
 	}
 
-	network := topsort.NewNetwork()
-
-	// a map of alternate tag names to the canonical "node name" for topsort purposes
-	canonicalNodes := map[string]string{}
-	canonicalRepos := map[string]*Repo{}
-
+	g := depgraph.New()
 	for _, r := range rs {
-		node := r.Identifier()
-		for _, entry := range r.Entries() {
-			// add edges both with and without namespace so sorting still works properly for official images ("bashbrew --namespace amd64 list --build-order wordpress php")
-			// this should be reasonably harmless for other use cases of --namespace but catches things like "tianon/foo -> tianon/bar" and things like "php -> wordpress" equally even if we're building to target a different namespace
-			tags := []string{}
-			tags = append(tags, r.Tags("", false, entry)...)
-			tags = append(tags, r.Tags(namespace, false, entry)...)
-			for _, tag := range tags {
-				if canonicalRepo, ok := canonicalRepos[tag]; ok && canonicalRepo.TagName != "" {
-					// if we run into a duplicate, we want to prefer a specific tag over a full repo
-					continue
-				}
+		g.AddNode(r.Identifier(), r.RepoName, r)
+	}
 
-				canonicalNodes[tag] = node
-				canonicalRepos[tag] = r
+	// if we run into a duplicate, we want to prefer a specific tag over a full repo (and the first claim on a tag wins; see "depgraph.Graph.AddTags")
+	for _, specific := range []bool{true, false} {
+		for _, r := range rs {
+			if (r.TagName != "") != specific {
+				continue
+			}
+			for _, entry := range r.Entries() {
+				// add tags both with and without namespace so sorting still works properly for official images ("bashbrew --namespace amd64 list --build-order wordpress php")
+				// this should be reasonably harmless for other use cases of --namespace but catches things like "tianon/foo -> tianon/bar" and things like "php -> wordpress" equally even if we're building to target a different namespace
+				tags := append(r.Tags("", false, entry), r.Tags(namespace, false, entry)...)
+				for _, entryArch := range entry.Architectures {
+					g.AddTags(r.Identifier(), entryArch, tags...)
+				}
 			}
 		}
-		network.AddNode(node, r)
 	}
 
 	for _, r := range rs {
@@ -116,47 +124,34 @@ func sortRepoObjects(rs []*Repo, applyConstraints bool) ([]*Repo, error) {
 				entryArches = entry.Architectures
 			}
 
-			froms := []string{}
 			for _, entryArch := range entryArches {
-				archFroms, err := r.ArchDockerFroms(entryArch, entry)
+				froms, err := r.ArchDockerFroms(entryArch, entry)
 				if err != nil {
 					return nil, err
 				}
-			ArchFroms:
-				for _, archFrom := range archFroms {
-					for _, from := range froms {
-						if from == archFrom {
-							continue ArchFroms
-						}
-					}
-					froms = append(froms, archFrom)
+				for _, from := range froms {
+					// if our FROM isn't in the list of things we're sorting, it isn't relevant in this context (and "Sort" ignores it)
+					g.AddFrom(r.Identifier(), entryArch, from)
 				}
 			}
+		}
+	}
 
-			for _, from := range froms {
-				fromNode, ok := canonicalNodes[from]
-				if !ok {
-					// if our FROM isn't in the list of things we're sorting, it isn't relevant in this context
-					continue
-				}
-
-				// TODO somehow reconcile/avoid "a:a -> b:b, b:b -> a:c" (which will exhibit here as cyclic)
-				for _, tag := range r.Tags(namespace, false, entry) {
-					if tagNode, ok := canonicalNodes[tag]; ok {
-						if tagNode == fromNode {
-							// don't be cyclic
-							continue
-						}
-						if err := network.AddEdge(fromNode, tagNode); err != nil {
-							return nil, err
-						}
-					}
-				}
+	nodes, err := g.Sort()
+	var cycleErr *depgraph.CycleError
+	if errors.As(err, &cycleErr) {
+		// "a:a -> b:b, b:b -> a:c" is cyclic at the repo level, but not at the entry level, so if we have any repos with more than one entry, we try again with each entry on its own (and only fail if that's still cyclic, which means it's a real cycle)
+		entryRs := []*Repo{}
+		for _, r := range rs {
+			for _, entry := range r.Entries() {
+				entryRs = append(entryRs, r.EntryRepo(entry))
 			}
 		}
+		if len(entryRs) > len(rs) {
+			fmt.Fprintf(os.Stderr, "warning: sorting individual entries instead of repos: %v\n", err)
+			return sortRepoObjects(entryRs, applyConstraints)
+		}
 	}
-
-	nodes, err := network.Sort()
 	if err != nil {
 		return nil, err
 	}
@@ -168,3 +163,105 @@ func sortRepoObjects(rs []*Repo, applyConstraints bool) ([]*Repo, error) {
 
 	return ret, nil
 }
+
+// the "Value" of the nodes of an "entryGraph"
+type graphEntry struct {
+	Repo  *Repo
+	Entry *manifest.Manifest2822Entry
+}
+
+// builds the FROM graph of every entry of the given repos (on every architecture, or only the current one with "applyConstraints" or "archFilter"), one node per entry named after its first tag (with "--namespace"), which is what "parents", "children", "graph", "plan", etc all walk
+func entryGraph(repos []string, applyConstraints, archFilter bool) (*depgraph.Graph, error) {
+	g := depgraph.New()
+	for _, repo := range repos {
+		r, err := fetch(repo)
+		if err != nil {
+			return nil, cli.NewMultiError(fmt.Errorf(`failed fetching repo %q`, repo), err)
+		}
+		if err := addEntryGraphRepo(g, r, applyConstraints, archFilter); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// like "entryGraph", but of only the given repos and whatever they are (transitively) FROM, fetched as they're reached (so "parents" doesn't need to parse all of "--library", and isn't affected by unrelated problems in it)
+func ancestorGraph(repos []string, applyConstraints, archFilter bool) (*depgraph.Graph, error) {
+	g, err := entryGraph(repos, applyConstraints, archFilter)
+	if err != nil {
+		return nil, err
+	}
+	tried := map[string]bool{}
+	for {
+		lookup := []string{}
+		for _, n := range g.Nodes() {
+			if n.External && !tried[n.ID] {
+				tried[n.ID] = true
+				lookup = append(lookup, n.ID)
+			}
+		}
+		if len(lookup) == 0 {
+			return g, nil
+		}
+		for _, from := range lookup {
+			r, err := fetch(from)
+			if err != nil {
+				var (
+					manifestNotFoundErr manifest.ManifestNotFoundError
+					tagNotFoundErr      manifest.TagNotFoundError
+				)
+				if errors.As(err, &manifestNotFoundErr) || errors.As(err, &tagNotFoundErr) {
+					// not a supported tag ("FROM mcr.microsoft.com/...", etc), so it stays an external node
+					continue
+				}
+				return nil, cli.NewMultiError(fmt.Errorf(`failed fetching repo %q`, from), err)
+			}
+			if err := addEntryGraphRepo(g, r, applyConstraints, archFilter); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func addEntryGraphRepo(g *depgraph.Graph, r *Repo, applyConstraints, archFilter bool) error {
+	for _, entry := range r.Entries() {
+		if applyConstraints && r.SkipConstraints(entry) {
+			continue
+		}
+		if archFilter && !entry.HasArchitecture(arch) {
+			continue
+		}
+
+		tags := r.Tags(namespace, false, entry)
+		n := g.AddNode(tags[0], path.Join(namespace, r.RepoName), graphEntry{Repo: r, Entry: entry})
+		// see "sortRepoObjects" (FROM values don't include "--namespace", but our tags might)
+		tags = append(tags, r.Tags("", false, entry)...)
+
+		entryArches := []string{arch}
+		if !applyConstraints && !archFilter {
+			entryArches = entry.Architectures
+		}
+		for _, entryArch := range entryArches {
+			g.AddTags(n.ID, entryArch, tags...)
+
+			froms, err := r.ArchDockerFroms(entryArch, entry)
+			if err != nil {
+				return cli.NewMultiError(fmt.Errorf(`failed fetching/scraping FROM for %q (tags %q, arch %q)`, r.RepoName, entry.TagsString(), entryArch), err)
+			}
+			for _, from := range froms {
+				g.AddFrom(n.ID, entryArch, from)
+			}
+		}
+	}
+	return nil
+}
+
+// returns the nodes of the given "entryGraph" the given argument refers to (a tag, an external FROM value, or a repository; see "depgraph.Graph.Resolve"), preferring the "--namespace" version of it
+func resolveGraphArg(g *depgraph.Graph, arg string) []*depgraph.Node {
+	if nsArg := path.Join(namespace, arg); nsArg != arg {
+		if nodes := g.Resolve(nsArg); len(nodes) > 0 {
+			return nodes
+		}
+	}
+	return g.Resolve(arg)
+}
@@ -0,0 +1,156 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// a single "Constraints" value, which is one or more terms separated by "|" (at least one of which has to be satisfied; the values of "Constraints" itself are all required)
+//
+//	aufs                                               "aufs" is one of the "--constraint" values
+//	!aufs                                              "aufs" is NOT one of the "--constraint" values
+//	windowsservercore-ltsc2022 | nanoserver-ltsc2022   either of those is one of the "--constraint" values
+//	docker>=24                                         one of the "--constraint" values is "docker=VERSION" where VERSION is at least 24 (also "<", "<=", ">", "=", and "!=")
+type Constraint []ConstraintTerm
+
+type ConstraintTerm struct {
+	Not     bool
+	Name    string
+	Op      string // "" (just check for "Name"), "=", "!=", "<", "<=", ">", ">="
+	Version string
+}
+
+var constraintTermRegex = regexp.MustCompile(`^(!?)\s*([a-zA-Z0-9][a-zA-Z0-9._-]*)\s*(?:(>=|<=|!=|==|=|<|>)\s*([a-zA-Z0-9][a-zA-Z0-9._+~-]*))?$`)
+
+func ParseConstraint(str string) (Constraint, error) {
+	c := Constraint{}
+	for _, term := range strings.Split(str, "|") {
+		matches := constraintTermRegex.FindStringSubmatch(strings.TrimSpace(term))
+		if matches == nil {
+			return nil, fmt.Errorf("invalid constraint %q: bad term %q", str, strings.TrimSpace(term))
+		}
+		op := matches[3]
+		if op == "==" {
+			op = "="
+		}
+		c = append(c, ConstraintTerm{
+			Not:     matches[1] == "!",
+			Name:    matches[2],
+			Op:      op,
+			Version: matches[4],
+		})
+	}
+	return c, nil
+}
+
+func (term ConstraintTerm) String() string {
+	ret := term.Name + term.Op + term.Version
+	if term.Not {
+		ret = "!" + ret
+	}
+	return ret
+}
+
+func (c Constraint) String() string {
+	terms := []string{}
+	for _, term := range c {
+		terms = append(terms, term.String())
+	}
+	return strings.Join(terms, " | ")
+}
+
+// whether this term is satisfied by the given set of "--constraint" values (each of which is either "NAME" or "NAME=VERSION")
+func (term ConstraintTerm) Satisfied(given []string) bool {
+	found := false
+	for _, g := range given {
+		name, version, hasVersion := strings.Cut(g, "=")
+		if name != term.Name {
+			continue
+		}
+		if term.Op == "" || (hasVersion && compareOp(CompareVersions(version, term.Version), term.Op)) {
+			found = true
+			break
+		}
+	}
+	return found != term.Not
+}
+
+func (c Constraint) Satisfied(given []string) bool {
+	for _, term := range c {
+		if term.Satisfied(given) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareOp(cmp int, op string) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// compares two dotted versions (ala "24.0.7" vs "24"), numerically where both components are numbers and lexically otherwise; missing components count as "0"
+func CompareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		ac, bc := "0", "0"
+		if i < len(as) {
+			ac = as[i]
+		}
+		if i < len(bs) {
+			bc = bs[i]
+		}
+		an, aErr := strconv.ParseUint(ac, 10, 64)
+		bn, bErr := strconv.ParseUint(bc, 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case ac != bc:
+			return strings.Compare(ac, bc)
+		}
+	}
+	return 0
+}
+
+func (entry Manifest2822Entry) InvalidConstraints() []string {
+	invalid := []string{}
+	for _, constraint := range entry.Constraints {
+		if _, err := ParseConstraint(constraint); err != nil {
+			invalid = append(invalid, constraint)
+		}
+	}
+	return invalid
+}
+
+// the entry's "Constraints" values which are not satisfied by the given set of "--constraint" values (see "Constraint")
+func (entry Manifest2822Entry) UnsatisfiedConstraints(given []string) []string {
+	unsatisfied := []string{}
+	for _, constraint := range entry.Constraints {
+		c, err := ParseConstraint(constraint)
+		if err != nil || !c.Satisfied(given) {
+			// an invalid constraint can never be satisfied (but normally these would already be caught by "AddEntry")
+			unsatisfied = append(unsatisfied, constraint)
+		}
+	}
+	return unsatisfied
+}
@@ -0,0 +1,90 @@
+package manifest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker-library/bashbrew/manifest"
+)
+
+func TestConstraintSatisfied(t *testing.T) {
+	given := []string{"docker=24.0.7", "windowsservercore-ltsc2022"}
+	for constraint, expected := range map[string]bool{
+		"windowsservercore-ltsc2022":  true,
+		"!windowsservercore-ltsc2022": false,
+		"aufs":                        false,
+		"!aufs":                       true,
+		"nanoserver-ltsc2022 | windowsservercore-ltsc2022": true,
+		"nanoserver-ltsc2022 | aufs":                       false,
+		"nanoserver-ltsc2022 | !aufs":                      true,
+		"docker":                                           true,
+		"docker>=24":                                       true,
+		"docker >= 24.1":                                   false,
+		"docker<25":                                        true,
+		"docker=24.0.7":                                    true,
+		"docker==24":                                       false,
+		"docker!=24":                                       true,
+		"!docker<24":                                       true,
+		"windowsservercore-ltsc2022>=1":                    false, // no version given
+		"containerd>=1.7 | docker>=24":                     true,
+	} {
+		c, err := manifest.ParseConstraint(constraint)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", constraint, err)
+			continue
+		}
+		if actual := c.Satisfied(given); actual != expected {
+			t.Errorf("%q (%q): expected %v, got %v", constraint, c, expected, actual)
+		}
+	}
+}
+
+func TestConstraintInvalid(t *testing.T) {
+	for _, constraint := range []string{"", "a |", "docker>=", ">=24", "a b", "!!a"} {
+		if c, err := manifest.ParseConstraint(constraint); err == nil {
+			t.Errorf("%q: expected error, got %q", constraint, c)
+		}
+	}
+
+	testManifest := `Maintainers: Valid Name (@valid-handle)
+GitRepo: https://github.com/docker-library/hello-world.git
+GitCommit: 3fb6ebca4163bf5b9cc496ac3e8f11cb1e754aee
+
+Tags: latest
+Constraints: docker>=24, aufs |
+`
+	man, err := manifest.Parse(strings.NewReader(testManifest))
+	if err == nil {
+		t.Errorf("Expected error, got valid manifest instead:\n%s", man)
+		return
+	}
+	if !strings.Contains(err.Error(), `has invalid Constraints: "aufs |"`) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestUnsatisfiedConstraints(t *testing.T) {
+	entry := manifest.Manifest2822Entry{
+		Constraints: []string{"!aufs", "windowsservercore-ltsc2022 | nanoserver-ltsc2022", "docker>=24"},
+	}
+	unsatisfied := entry.UnsatisfiedConstraints([]string{"aufs", "nanoserver-ltsc2022", "docker=24.0.7"})
+	if strings.Join(unsatisfied, ", ") != "!aufs" {
+		t.Errorf("unexpected unsatisfied constraints: %q", unsatisfied)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"24", "24.0.0", 0},
+		{"24.0.7", "24", 1},
+		{"9", "10", -1},
+		{"1.2-rc1", "1.2-rc2", -1},
+	} {
+		if actual := manifest.CompareVersions(test.a, test.b); actual != test.expected {
+			t.Errorf("CompareVersions(%q, %q): expected %d, got %d", test.a, test.b, test.expected, actual)
+		}
+	}
+}
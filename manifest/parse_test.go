@@ -34,3 +34,48 @@ func TestInvalidMaintainer(t *testing.T) {
 		return
 	}
 }
+
+func TestOSVersion(t *testing.T) {
+	testManifest := `Maintainers: Valid Name (@valid-handle)
+GitRepo: https://example.com/foo.git
+GitCommit: 0123456789abcdef0123456789abcdef01234567
+
+Tags: ltsc2022
+Architectures: amd64, windows-amd64
+OSVersion: ltsc2022
+
+Tags: ltsc2019
+Architectures: windows-amd64
+windows-amd64-OSVersion: 10.0.17763.5329
+
+Tags: other
+Architectures: windows-amd64
+GitCommit: 89abcdef0123456789abcdef0123456789abcdef
+`
+
+	man, err := manifest.Parse(strings.NewReader(testManifest))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for tag, expected := range map[string]string{
+		"ltsc2022": "10.0.20348",
+		"ltsc2019": "10.0.17763.5329",
+		"other":    "",
+	} {
+		if got := man.GetTag(tag).ArchOSVersion("windows-amd64"); got != expected {
+			t.Errorf("%s: expected %q; got %q", tag, expected, got)
+		}
+	}
+
+	for _, invalid := range []string{"ltsc2000", "10.0"} {
+		invalidManifest := strings.Replace(testManifest, "OSVersion: ltsc2022", "OSVersion: "+invalid, 1)
+		man, err := manifest.Parse(strings.NewReader(invalidManifest))
+		if err == nil {
+			t.Errorf("Expected error, got valid manifest instead:\n%s", man)
+			continue
+		}
+		if !strings.Contains(err.Error(), "has invalid OSVersion:") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
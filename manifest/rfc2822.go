@@ -47,6 +47,9 @@ type Manifest2822Entry struct {
 	File      string
 	Builder   string
 
+	// the Windows "os.version" of the resulting images (either a version ala "10.0.20348" or the name of a release ala "ltsc2022"; see "architecture.OSVersion"), which otherwise gets derived from the FROM for Windows architectures (see "put-shared")
+	OSVersion string
+
 	// architecture-specific versions of the above fields
 	ArchValues map[string]string
 	// "ARCH-FIELD: VALUE"
@@ -91,7 +94,7 @@ func (entry *Manifest2822Entry) SeedArchValues() {
 		entry.ArchValues = map[string]string{}
 	}
 	for field, val := range entry.Paragraph.Values {
-		if strings.HasSuffix(field, "-GitRepo") || strings.HasSuffix(field, "-GitFetch") || strings.HasSuffix(field, "-GitCommit") || strings.HasSuffix(field, "-Directory") || strings.HasSuffix(field, "-File") || strings.HasSuffix(field, "-Builder") {
+		if strings.HasSuffix(field, "-GitRepo") || strings.HasSuffix(field, "-GitFetch") || strings.HasSuffix(field, "-GitCommit") || strings.HasSuffix(field, "-Directory") || strings.HasSuffix(field, "-File") || strings.HasSuffix(field, "-Builder") || strings.HasSuffix(field, "-OSVersion") {
 			entry.ArchValues[field] = val
 		}
 	}
@@ -143,7 +146,7 @@ func (a Manifest2822Entry) SameBuildArtifacts(b Manifest2822Entry) bool {
 		}
 	}
 
-	return a.ArchitecturesString() == b.ArchitecturesString() && a.GitRepo == b.GitRepo && a.GitFetch == b.GitFetch && a.GitCommit == b.GitCommit && a.Directory == b.Directory && a.File == b.File && a.Builder == b.Builder && a.ConstraintsString() == b.ConstraintsString()
+	return a.ArchitecturesString() == b.ArchitecturesString() && a.GitRepo == b.GitRepo && a.GitFetch == b.GitFetch && a.GitCommit == b.GitCommit && a.Directory == b.Directory && a.File == b.File && a.Builder == b.Builder && a.OSVersion == b.OSVersion && a.ConstraintsString() == b.ConstraintsString()
 }
 
 // returns a list of architecture-specific fields in an Entry
@@ -191,6 +194,9 @@ func (entry Manifest2822Entry) ClearDefaults(defaults Manifest2822Entry) Manifes
 	if entry.Builder == defaults.Builder {
 		entry.Builder = ""
 	}
+	if entry.OSVersion == defaults.OSVersion {
+		entry.OSVersion = ""
+	}
 	for _, key := range defaults.archFields() {
 		if defaults.ArchValues[key] == entry.ArchValues[key] {
 			delete(entry.ArchValues, key)
@@ -234,6 +240,9 @@ func (entry Manifest2822Entry) String() string {
 	if str := entry.Builder; str != "" {
 		ret = append(ret, "Builder: "+str)
 	}
+	if str := entry.OSVersion; str != "" {
+		ret = append(ret, "OSVersion: "+str)
+	}
 	for _, key := range entry.archFields() {
 		ret = append(ret, key+": "+entry.ArchValues[key])
 	}
@@ -314,6 +323,18 @@ func (entry Manifest2822Entry) ArchBuilder(arch string) string {
 	return entry.Builder
 }
 
+// ArchOSVersion returns the (resolved) "os.version" the entry declares for the given architecture (see "architecture.OSVersion"), or "" if it doesn't declare one
+func (entry Manifest2822Entry) ArchOSVersion(arch string) string {
+	val, ok := entry.ArchValues[arch+"-OSVersion"]
+	if !ok || val == "" {
+		val = entry.OSVersion
+	}
+	if v, ok := architecture.OSVersion(val); ok {
+		return v
+	}
+	return ""
+}
+
 func (entry Manifest2822Entry) HasTag(tag string) bool {
 	return slices.Contains(entry.Tags, tag)
 }
@@ -413,6 +434,12 @@ func (manifest *Manifest2822) AddEntry(entry Manifest2822Entry) error {
 	if invalidArchitectures := entry.InvalidArchitectures(); len(invalidArchitectures) > 0 {
 		return fmt.Errorf("Tags %q has invalid Architectures: %q", entry.TagsString(), strings.Join(invalidArchitectures, ", "))
 	}
+	if invalidConstraints := entry.InvalidConstraints(); len(invalidConstraints) > 0 {
+		return fmt.Errorf("Tags %q has invalid Constraints: %q", entry.TagsString(), strings.Join(invalidConstraints, ", "))
+	}
+	if invalidOSVersions := entry.InvalidOSVersions(); len(invalidOSVersions) > 0 {
+		return fmt.Errorf("Tags %q has invalid OSVersion: %q", entry.TagsString(), strings.Join(invalidOSVersions, ", "))
+	}
 
 	seenTag := map[string]bool{}
 	for _, tag := range entry.Tags {
@@ -513,6 +540,22 @@ func (entry Manifest2822Entry) InvalidArchitectures() []string {
 	return invalid
 }
 
+func (entry Manifest2822Entry) InvalidOSVersions() []string {
+	vals := []string{entry.OSVersion}
+	for _, key := range entry.archFields() {
+		if strings.HasSuffix(key, "-OSVersion") {
+			vals = append(vals, entry.ArchValues[key])
+		}
+	}
+	invalid := []string{}
+	for _, val := range vals {
+		if _, ok := architecture.OSVersion(val); val != "" && !ok {
+			invalid = append(invalid, val)
+		}
+	}
+	return invalid
+}
+
 // DeduplicateSharedTags will remove duplicate values from entry.SharedTags, preserving order.
 func (entry *Manifest2822Entry) DeduplicateSharedTags() {
 	aggregate := []string{}
@@ -0,0 +1,452 @@
+// Package depgraph implements the FROM graph of a set of images: nodes (usually one per entry) provide tags on a set of architectures, and are FROM tags on each architecture, which get resolved to the node providing that tag on that same architecture (which is what makes SharedTags work correctly, since a shared tag can be provided by a different node on every architecture).
+package depgraph
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"pault.ag/go/topsort"
+)
+
+// Node is a single vertex of a [Graph]
+type Node struct {
+	// the canonical name of the node (usually the first tag of an entry, ala "repo:tag"; for external nodes, the FROM value verbatim)
+	ID string `json:"id"`
+
+	// the repository the node belongs to (used for looking up nodes by repository, ala "bashbrew children alpine")
+	Repo string `json:"repo"`
+
+	// every tag the node provides on at least one of its architectures (see [Graph.AddTags])
+	Tags []string `json:"tags,omitempty"`
+
+	// the architectures the node exists on (for external nodes, the architectures something is FROM them on)
+	Arches []string `json:"arches"`
+
+	// whether the node is something other nodes are FROM, but which isn't provided by any node of the graph (an "external" base like "mcr.microsoft.com/windows/servercore:ltsc2022", or an unsupported tag)
+	External bool `json:"external,omitempty"`
+
+	// arbitrary caller data (see [Graph.AddNode])
+	Value any `json:"-"`
+}
+
+// Edge is a FROM relationship between two nodes of a [Graph] ("To" is FROM "From")
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// the architectures on which "To" is FROM "From"
+	Arches []string `json:"arches"`
+
+	// the FROM values (as written; ala "php:8-apache") which resolved to "From"
+	Refs []string `json:"refs"`
+}
+
+// Graph is the FROM graph of a set of nodes; it is built up via [Graph.AddNode], [Graph.AddTags], and [Graph.AddFrom], and FROM values are resolved to nodes lazily (the next time the graph is queried), so those can be called in any order
+type Graph struct {
+	nodes []*Node
+	byID  map[string]*Node
+
+	// arch => tag => node ID ("" => tag => node ID for any architecture)
+	canonical map[string]map[string]string
+
+	froms []from
+
+	// everything below here is derived from the above by "resolve" (and reset whenever the above changes)
+	resolved bool
+	external []*Node
+	edges    []*Edge
+	edgeIdx  map[[2]string]*Edge
+}
+
+type from struct {
+	id, arch, ref string
+}
+
+// New returns a new (empty) [Graph]
+func New() *Graph {
+	return &Graph{
+		byID:      map[string]*Node{},
+		canonical: map[string]map[string]string{},
+	}
+}
+
+// AddNode adds a node with the given ID (or returns the existing node with that ID), attaching the given repository name and value to it if it's new
+func (g *Graph) AddNode(id, repo string, value any) *Node {
+	if n, ok := g.byID[id]; ok && !n.External {
+		return n
+	}
+	n := &Node{
+		ID:     id,
+		Repo:   repo,
+		Arches: []string{},
+		Value:  value,
+	}
+	g.nodes = append(g.nodes, n)
+	g.byID[id] = n
+	g.resolved = false
+	return n
+}
+
+// AddTags records that the given node provides the given tags on the given architecture (adding the architecture to the node's list); the first node to claim a given tag on a given architecture owns it there
+func (g *Graph) AddTags(id, arch string, tags ...string) {
+	n := g.byID[id]
+	if n == nil || n.External {
+		panic(fmt.Sprintf("depgraph: AddTags on unknown node %q", id))
+	}
+	if !slices.Contains(n.Arches, arch) {
+		n.Arches = append(n.Arches, arch)
+	}
+	for _, a := range []string{arch, ""} {
+		if g.canonical[a] == nil {
+			g.canonical[a] = map[string]string{}
+		}
+		for _, tag := range tags {
+			if _, ok := g.canonical[a][tag]; !ok {
+				g.canonical[a][tag] = id
+			}
+		}
+	}
+	for _, tag := range tags {
+		if !slices.Contains(n.Tags, tag) {
+			n.Tags = append(n.Tags, tag)
+		}
+	}
+	g.resolved = false
+}
+
+// AddFrom records that the given node is FROM the given value on the given architecture ("scratch" is ignored, since it isn't really anyone's parent)
+func (g *Graph) AddFrom(id, arch, ref string) {
+	if ref == "scratch" {
+		return
+	}
+	g.froms = append(g.froms, from{id: id, arch: arch, ref: ref})
+	g.resolved = false
+}
+
+// returns the repository of the given (FROM) reference ("localhost:5000/foo:bar@sha256:..." => "localhost:5000/foo")
+func refRepo(ref string) string {
+	ref, _, _ = strings.Cut(ref, "@")
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+func (g *Graph) resolve() {
+	if g.resolved {
+		return
+	}
+	for _, n := range g.external {
+		if g.byID[n.ID] == n {
+			delete(g.byID, n.ID)
+		}
+	}
+	g.external = nil
+	g.edges = nil
+	g.edgeIdx = map[[2]string]*Edge{}
+
+	for _, f := range g.froms {
+		fromID, ok := g.canonical[f.arch][f.ref]
+		if !ok {
+			fromID = f.ref
+			n, ok := g.byID[fromID]
+			if !ok {
+				n = &Node{
+					ID:       fromID,
+					Repo:     refRepo(fromID),
+					Arches:   []string{},
+					External: true,
+				}
+				g.byID[fromID] = n
+				g.external = append(g.external, n)
+			}
+			if n.External && !slices.Contains(n.Arches, f.arch) {
+				n.Arches = append(n.Arches, f.arch)
+			}
+		}
+		if fromID == f.id {
+			// don't be cyclic
+			continue
+		}
+
+		key := [2]string{fromID, f.id}
+		e, ok := g.edgeIdx[key]
+		if !ok {
+			e = &Edge{From: fromID, To: f.id, Arches: []string{}, Refs: []string{}}
+			g.edgeIdx[key] = e
+			g.edges = append(g.edges, e)
+		}
+		if !slices.Contains(e.Arches, f.arch) {
+			e.Arches = append(e.Arches, f.arch)
+		}
+		if !slices.Contains(e.Refs, f.ref) {
+			e.Refs = append(e.Refs, f.ref)
+		}
+	}
+
+	g.resolved = true
+}
+
+// Node returns the node with the given ID (or nil)
+func (g *Graph) Node(id string) *Node {
+	g.resolve()
+	return g.byID[id]
+}
+
+// Lookup returns the node which provides the given tag on the given architecture (or on any architecture, if "arch" is empty), or nil
+func (g *Graph) Lookup(arch, tag string) *Node {
+	if id, ok := g.canonical[arch][tag]; ok {
+		return g.byID[id]
+	}
+	return nil
+}
+
+// Resolve returns the nodes the given string refers to: a node ID, a tag (see [Graph.Lookup]), or a repository (every node of that repository, in order)
+func (g *Graph) Resolve(s string) []*Node {
+	if n := g.Node(s); n != nil {
+		return []*Node{n}
+	}
+	if n := g.Lookup("", s); n != nil {
+		return []*Node{n}
+	}
+	var ret []*Node
+	for _, n := range g.Nodes() {
+		if n.Repo == s {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
+
+// Nodes returns every node of the graph (in the order they were added, followed by the external nodes in the order they were first referenced)
+func (g *Graph) Nodes() []*Node {
+	g.resolve()
+	return append(slices.Clone(g.nodes), g.external...)
+}
+
+// Edges returns every edge of the graph (in the order of the [Graph.AddFrom] calls that created them)
+func (g *Graph) Edges() []*Edge {
+	g.resolve()
+	return slices.Clone(g.edges)
+}
+
+// whether the given edge exists on any of the given architectures (or at all, if "arches" is empty)
+func (e *Edge) on(arches []string) bool {
+	if len(arches) == 0 {
+		return true
+	}
+	for _, arch := range e.Arches {
+		if slices.Contains(arches, arch) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parents returns the edges to the given node (what it's FROM) on any of the given architectures (or any architecture, if "arches" is empty)
+func (g *Graph) Parents(id string, arches []string) []*Edge {
+	g.resolve()
+	var ret []*Edge
+	for _, e := range g.edges {
+		if e.To == id && e.on(arches) {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// Children returns the edges from the given node (what's FROM it) on any of the given architectures (or any architecture, if "arches" is empty)
+func (g *Graph) Children(id string, arches []string) []*Edge {
+	g.resolve()
+	var ret []*Edge
+	for _, e := range g.edges {
+		if e.From == id && e.on(arches) {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// Descendants returns the IDs of every node reachable from the given nodes (via [Graph.Children] on the given architectures) within the given depth (0 for unlimited), in breadth-first order and including the given nodes themselves
+func (g *Graph) Descendants(ids []string, arches []string, depth int) []string {
+	ret := slices.Clone(ids)
+	lookup := ids
+	for d := depth; len(lookup) > 0 && (depth == 0 || d > 0); d-- {
+		nextLookup := []string{}
+		for _, id := range lookup {
+			for _, e := range g.Children(id, arches) {
+				if !slices.Contains(ret, e.To) {
+					ret = append(ret, e.To)
+					nextLookup = append(nextLookup, e.To)
+				}
+			}
+		}
+		lookup = nextLookup
+	}
+	return ret
+}
+
+// Subgraph returns a new graph with only the given nodes (and the edges between them), which is only meant for querying (adding to it is undefined)
+func (g *Graph) Subgraph(ids []string) *Graph {
+	g.resolve()
+	sub := New()
+	for _, n := range g.nodes {
+		if slices.Contains(ids, n.ID) {
+			sub.nodes = append(sub.nodes, n)
+			sub.byID[n.ID] = n
+		}
+	}
+	for _, n := range g.external {
+		if slices.Contains(ids, n.ID) {
+			sub.external = append(sub.external, n)
+			sub.byID[n.ID] = n
+		}
+	}
+	sub.edgeIdx = map[[2]string]*Edge{}
+	for _, e := range g.edges {
+		if sub.byID[e.From] != nil && sub.byID[e.To] != nil {
+			sub.edges = append(sub.edges, e)
+			sub.edgeIdx[[2]string{e.From, e.To}] = e
+		}
+	}
+	sub.resolved = true // (the edges are already resolved, and there's nothing to re-resolve them from)
+	return sub
+}
+
+// Sort returns the (non-external) nodes of the graph in an order such that every node comes after everything it's FROM (otherwise preserving the order they were added), or a [*CycleError] if there is no such order
+func (g *Graph) Sort() ([]*Node, error) {
+	g.resolve()
+	network := topsort.NewNetwork()
+	for _, n := range g.nodes {
+		network.AddNode(n.ID, n)
+	}
+	for _, e := range g.edges {
+		if g.byID[e.From].External {
+			continue
+		}
+		if err := network.AddEdge(e.From, e.To); err != nil {
+			return nil, err
+		}
+	}
+	sorted, err := network.Sort()
+	if err != nil {
+		if cycles := g.Cycles(); len(cycles) > 0 {
+			return nil, &CycleError{Cycles: cycles}
+		}
+		return nil, err
+	}
+	ret := []*Node{}
+	for _, node := range sorted {
+		ret = append(ret, node.Value.(*Node))
+	}
+	return ret, nil
+}
+
+// CycleError is returned by [Graph.Sort] when there is no order to build the graph in
+type CycleError struct {
+	// see [Graph.Cycles]
+	Cycles [][]*Edge
+}
+
+func (err *CycleError) Error() string {
+	lines := []string{}
+	for _, cycle := range err.Cycles {
+		chain := []string{cycle[0].From}
+		for _, e := range cycle {
+			chain = append(chain, e.To)
+		}
+		lines = append(lines, "cycle: "+strings.Join(chain, " -> "))
+		for _, e := range cycle {
+			lines = append(lines, fmt.Sprintf("\t%s: FROM %s (%s)", e.To, strings.Join(e.Refs, ", "), strings.Join(e.Arches, ", ")))
+		}
+	}
+	return fmt.Sprintf("FROM dependencies are cyclic (%d cycles):\n%s", len(err.Cycles), strings.Join(lines, "\n"))
+}
+
+// Cycles returns one cycle (as the chain of edges that forms it, in order, starting from the earliest-added node in it) for each set of (non-external) nodes which are FROM each other such that they can't be sorted
+func (g *Graph) Cycles() [][]*Edge {
+	g.resolve()
+
+	// https://en.wikipedia.org/wiki/Tarjan%27s_strongly_connected_components_algorithm
+	index := map[string]int{}
+	lowlink := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	sccOf := map[string]int{}
+	sccs := 0
+	var connect func(id string)
+	connect = func(id string) {
+		index[id] = len(index)
+		lowlink[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+		for _, e := range g.edges {
+			if e.From != id {
+				continue
+			}
+			if _, ok := index[e.To]; !ok {
+				connect(e.To)
+				lowlink[id] = min(lowlink[id], lowlink[e.To])
+			} else if onStack[e.To] {
+				lowlink[id] = min(lowlink[id], index[e.To])
+			}
+		}
+		if lowlink[id] == index[id] {
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				sccOf[top] = sccs
+				if top == id {
+					break
+				}
+			}
+			sccs++
+		}
+	}
+	for _, n := range g.nodes {
+		if _, ok := index[n.ID]; !ok {
+			connect(n.ID)
+		}
+	}
+
+	var cycles [][]*Edge
+	seen := map[int]bool{}
+	for _, n := range g.nodes {
+		scc := sccOf[n.ID]
+		if seen[scc] {
+			continue
+		}
+		seen[scc] = true
+
+		// the shortest way back to "n" through its strongly connected component (if there is one, it's a cycle)
+		via := map[string]*Edge{}
+		lookup := []string{n.ID}
+	BFS:
+		for len(lookup) > 0 {
+			nextLookup := []string{}
+			for _, id := range lookup {
+				for _, e := range g.edges {
+					if e.From != id || sccOf[e.To] != scc {
+						continue
+					}
+					if e.To == n.ID {
+						cycle := []*Edge{e}
+						for at := id; at != n.ID; at = via[at].From {
+							cycle = append([]*Edge{via[at]}, cycle...)
+						}
+						cycles = append(cycles, cycle)
+						break BFS
+					}
+					if _, ok := via[e.To]; !ok {
+						via[e.To] = e
+						nextLookup = append(nextLookup, e.To)
+					}
+				}
+			}
+			lookup = nextLookup
+		}
+	}
+	return cycles
+}
@@ -0,0 +1,135 @@
+package depgraph_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/docker-library/bashbrew/pkg/depgraph"
+)
+
+func ids(nodes []*depgraph.Node) []string {
+	ret := []string{}
+	for _, n := range nodes {
+		ret = append(ret, n.ID)
+	}
+	return ret
+}
+
+func edges(es []*depgraph.Edge) []string {
+	ret := []string{}
+	for _, e := range es {
+		ret = append(ret, e.From+" -> "+e.To)
+	}
+	return ret
+}
+
+func expect[T any](t *testing.T, expected, actual T) {
+	t.Helper()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v; got %#v", expected, actual)
+	}
+}
+
+// a miniature "official images": "foo:1" is a SharedTag provided by a Linux entry on amd64 and a Windows entry on windows-amd64
+func testGraph() *depgraph.Graph {
+	g := depgraph.New()
+
+	g.AddNode("foo:1-linux", "foo", nil)
+	g.AddTags("foo:1-linux", "amd64", "foo:1-linux", "foo:1")
+	g.AddTags("foo:1-linux", "arm64v8", "foo:1-linux", "foo:1")
+	g.AddFrom("foo:1-linux", "amd64", "debian:bookworm")
+	g.AddFrom("foo:1-linux", "arm64v8", "debian:bookworm")
+
+	g.AddNode("foo:1-windows", "foo", nil)
+	g.AddTags("foo:1-windows", "windows-amd64", "foo:1-windows", "foo:1")
+	g.AddFrom("foo:1-windows", "windows-amd64", "mcr.microsoft.com/windows/servercore:ltsc2022")
+
+	// (added before "debian", to make sure resolution doesn't depend on order)
+	g.AddNode("bar:1", "bar", nil)
+	g.AddTags("bar:1", "amd64", "bar:1", "bar:latest")
+	g.AddTags("bar:1", "windows-amd64", "bar:1", "bar:latest")
+	g.AddFrom("bar:1", "amd64", "foo:1")
+	g.AddFrom("bar:1", "windows-amd64", "foo:1")
+
+	g.AddNode("debian:bookworm", "debian", nil)
+	g.AddTags("debian:bookworm", "amd64", "debian:bookworm")
+	g.AddTags("debian:bookworm", "arm64v8", "debian:bookworm")
+	g.AddFrom("debian:bookworm", "amd64", "scratch")
+	g.AddFrom("debian:bookworm", "arm64v8", "scratch")
+
+	return g
+}
+
+func TestGraph(t *testing.T) {
+	g := testGraph()
+
+	expect(t, []string{"foo:1-linux", "foo:1-windows", "bar:1", "debian:bookworm", "mcr.microsoft.com/windows/servercore:ltsc2022"}, ids(g.Nodes()))
+	expect(t, []string{
+		"debian:bookworm -> foo:1-linux",
+		"mcr.microsoft.com/windows/servercore:ltsc2022 -> foo:1-windows",
+		"foo:1-linux -> bar:1",
+		"foo:1-windows -> bar:1",
+	}, edges(g.Edges()))
+
+	external := g.Node("mcr.microsoft.com/windows/servercore:ltsc2022")
+	expect(t, true, external.External)
+	expect(t, "mcr.microsoft.com/windows/servercore", external.Repo)
+	expect(t, []string{"windows-amd64"}, external.Arches)
+
+	// the shared tag resolves to a different node on each architecture
+	expect(t, "foo:1-linux", g.Lookup("amd64", "foo:1").ID)
+	expect(t, "foo:1-windows", g.Lookup("windows-amd64", "foo:1").ID)
+	expect(t, (*depgraph.Node)(nil), g.Lookup("arm64v8", "bar:1"))
+
+	expect(t, []string{"foo:1-linux -> bar:1"}, edges(g.Parents("bar:1", []string{"amd64"})))
+	expect(t, []string{"foo:1-linux -> bar:1", "foo:1-windows -> bar:1"}, edges(g.Parents("bar:1", nil)))
+	expect(t, []string{"foo:1"}, g.Parents("bar:1", nil)[0].Refs)
+	expect(t, []string{}, edges(g.Children("mcr.microsoft.com/windows/servercore:ltsc2022", []string{"amd64", "arm64v8"})))
+
+	expect(t, []string{"foo:1-linux", "foo:1-windows"}, ids(g.Resolve("foo")))
+	expect(t, []string{"foo:1-windows"}, ids(g.Resolve("foo:1-windows")))
+	expect(t, []string{"bar:1"}, ids(g.Resolve("bar:latest")))
+	expect(t, []string{"mcr.microsoft.com/windows/servercore:ltsc2022"}, ids(g.Resolve("mcr.microsoft.com/windows/servercore")))
+
+	expect(t, []string{"debian:bookworm", "foo:1-linux", "bar:1"}, g.Descendants([]string{"debian:bookworm"}, nil, 0))
+	expect(t, []string{"debian:bookworm", "foo:1-linux"}, g.Descendants([]string{"debian:bookworm"}, nil, 1))
+
+	sub := g.Subgraph(g.Descendants([]string{"mcr.microsoft.com/windows/servercore:ltsc2022"}, nil, 0))
+	expect(t, []string{"foo:1-windows", "bar:1", "mcr.microsoft.com/windows/servercore:ltsc2022"}, ids(sub.Nodes()))
+	expect(t, []string{"mcr.microsoft.com/windows/servercore:ltsc2022 -> foo:1-windows", "foo:1-windows -> bar:1"}, edges(sub.Edges()))
+}
+
+func TestSort(t *testing.T) {
+	g := testGraph()
+	sorted, err := g.Sort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, []string{"foo:1-windows", "debian:bookworm", "foo:1-linux", "bar:1"}, ids(sorted))
+
+	// resolving is lazy, so a node claiming a tag after we've already looked at the graph still gets its edges
+	g.AddNode("debian:trixie", "debian", nil)
+	g.AddTags("debian:trixie", "amd64", "debian:trixie")
+	g.AddFrom("debian:trixie", "amd64", "bar:latest")
+	g.AddFrom("debian:bookworm", "amd64", "debian:trixie")
+	_, err = g.Sort()
+	var cycleErr *depgraph.CycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("expected a cycle error; got %v", err)
+	}
+	expect(t, 1, len(cycleErr.Cycles))
+	expect(t, []string{
+		"foo:1-linux -> bar:1",
+		"bar:1 -> debian:trixie",
+		"debian:trixie -> debian:bookworm",
+		"debian:bookworm -> foo:1-linux",
+	}, edges(cycleErr.Cycles[0]))
+	expect(t, []string{"bar:latest"}, cycleErr.Cycles[0][1].Refs)
+	expect(t, "FROM dependencies are cyclic (1 cycles):\n"+
+		"cycle: foo:1-linux -> bar:1 -> debian:trixie -> debian:bookworm -> foo:1-linux\n"+
+		"\tbar:1: FROM foo:1 (amd64)\n"+
+		"\tdebian:trixie: FROM bar:latest (amd64)\n"+
+		"\tdebian:bookworm: FROM debian:trixie (amd64)\n"+
+		"\tfoo:1-linux: FROM debian:bookworm (amd64, arm64v8)", err.Error())
+}
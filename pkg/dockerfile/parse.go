@@ -2,7 +2,9 @@ package dockerfile
 
 import (
 	"bufio"
+	"encoding/json"
 	"io"
+	"path"
 	"strconv"
 	"strings"
 	"unicode"
@@ -12,8 +14,11 @@ type Metadata struct {
 	StageFroms     []string          // every image "FROM" instruction value (or the parent stage's FROM value in the case of a named stage)
 	StageNames     []string          // the name of any named stage (in order)
 	StageNameFroms map[string]string // map of stage names to FROM values (or the parent stage's FROM value in the case of a named stage), useful for resolving stage names to FROM values
+	StageNumbers   map[string]int    // map of stage names to their (zero-based) index in StageFroms, useful for telling intermediate stages apart from the final stage
 
 	Froms []string // every "FROM" or "COPY --from=xxx" value (minus named and/or numbered stages in the case of "--from=")
+
+	Sources []string // every build context path (or glob) "COPY" or "ADD" reads from, cleaned and relative to the context (minus "COPY --from=xxx", URLs, and heredocs), useful for figuring out which files a build actually depends on
 }
 
 func Parse(dockerfile string) (Metadata, error) {
@@ -24,6 +29,7 @@ func ParseReader(dockerfile io.Reader) (Metadata, error) {
 	meta := Metadata{
 		// panic: assignment to entry in nil map
 		StageNameFroms: map[string]string{},
+		StageNumbers:   map[string]int{},
 		// (nil slices work fine)
 	}
 
@@ -98,18 +104,23 @@ func ParseReader(dockerfile io.Reader) (Metadata, error) {
 				stageName := fields[3]
 				meta.StageNames = append(meta.StageNames, stageName)
 				meta.StageNameFroms[stageName] = from
+				meta.StageNumbers[stageName] = len(meta.StageFroms) - 1
 			}
 
-		case "COPY":
-			for _, arg := range fields[1:] {
+		case "COPY", "ADD":
+			copyFrom := false
+			args := fields[len(fields):]
+			for i, arg := range fields[1:] {
 				if !strings.HasPrefix(arg, "--") {
 					// doesn't appear to be a "flag"; time to bail!
+					args = fields[1+i:]
 					break
 				}
 				if !strings.HasPrefix(arg, "--from=") {
 					// ignore any flags we're not interested in
 					continue
 				}
+				copyFrom = true
 				from := arg[len("--from="):]
 
 				if stageFrom, ok := meta.StageNameFroms[from]; ok {
@@ -125,6 +136,9 @@ func ParseReader(dockerfile io.Reader) (Metadata, error) {
 
 				meta.Froms = append(meta.Froms, from)
 			}
+			if !copyFrom {
+				meta.Sources = append(meta.Sources, copySources(args)...)
+			}
 
 		case "RUN": // TODO combine this and the above COPY-parsing code somehow sanely
 			for _, arg := range fields[1:] {
@@ -173,6 +187,35 @@ func ParseReader(dockerfile io.Reader) (Metadata, error) {
 	return meta, scanner.Err()
 }
 
+// given the (non-flag) arguments of a "COPY" or "ADD", returns the (cleaned) build context paths it copies from
+func copySources(args []string) []string {
+	if len(args) > 0 && strings.HasPrefix(args[0], "[") {
+		// JSON form: COPY ["src", "dest"]
+		var jsonArgs []string
+		if err := json.Unmarshal([]byte(strings.Join(args, " ")), &jsonArgs); err == nil {
+			args = jsonArgs
+		}
+	}
+	if len(args) < 2 {
+		return nil
+	}
+
+	sources := []string{}
+	for _, src := range args[:len(args)-1] { // the last argument is the destination
+		if strings.HasPrefix(src, "<<") || strings.Contains(src, "://") || strings.HasPrefix(src, "git@") {
+			// heredocs and remote sources aren't from the build context
+			continue
+		}
+		// "/foo", "./foo", and "foo" are all the same file in the context
+		src = strings.TrimPrefix(path.Clean("/"+src), "/")
+		if src == "" {
+			src = "."
+		}
+		sources = append(sources, src)
+	}
+	return sources
+}
+
 func latestizeRepoTag(repoTag string) string {
 	if repoTag != "scratch" && strings.IndexRune(repoTag, ':') < 0 {
 		return repoTag + ":latest"
@@ -72,7 +72,13 @@ func TestParse(t *testing.T) {
 					"bar":  "bash:5",
 					"foo2": "bash:latest",
 				},
-				Froms: []string{"bash:latest", "busybox:uclibc", "bash:5", "bash:latest", "scratch", "bash:latest", "bash:5", "bash:latest"},
+				StageNumbers: map[string]int{
+					"foo":  0,
+					"bar":  2,
+					"foo2": 3,
+				},
+				Froms:   []string{"bash:latest", "busybox:uclibc", "bash:5", "bash:latest", "scratch", "bash:latest", "bash:5", "bash:latest"},
+				Sources: []string{"foo"},
 			},
 		},
 		{
@@ -155,9 +161,32 @@ func TestParse(t *testing.T) {
 				StageFroms:     []string{"busybox:uclibc", "scratch"},
 				StageNames:     []string{"bb"},
 				StageNameFroms: map[string]string{"bb": "busybox:uclibc"},
+				StageNumbers:   map[string]int{"bb": 0},
 				Froms:          []string{"busybox:uclibc", "scratch", "busybox:uclibc"},
 			},
 		},
+		{
+			name: "COPY/ADD sources",
+			dockerfile: `
+				FROM bash:latest AS build
+				COPY . /usr/src/
+				FROM scratch
+				COPY --from=build /usr/src/foo /foo
+				COPY ./docker-entrypoint.sh *.conf /usr/local/bin/
+				ADD --chown=1:1 ["conf d/", "/etc/"]
+				ADD https://example.com/foo.tar.gz /tmp/
+				COPY <<EOF /etc/heredoc
+				EOF
+			`,
+			metadata: dockerfile.Metadata{
+				StageFroms:     []string{"bash:latest", "scratch"},
+				StageNames:     []string{"build"},
+				StageNameFroms: map[string]string{"build": "bash:latest"},
+				StageNumbers:   map[string]int{"build": 0},
+				Froms:          []string{"bash:latest", "scratch", "bash:latest"},
+				Sources:        []string{".", "docker-entrypoint.sh", "*.conf", "conf d"},
+			},
+		},
 	} {
 		// some light normalization
 		if td.name == "" {
@@ -169,6 +198,9 @@ func TestParse(t *testing.T) {
 		if td.metadata.StageNameFroms == nil {
 			td.metadata.StageNameFroms = map[string]string{}
 		}
+		if td.metadata.StageNumbers == nil {
+			td.metadata.StageNumbers = map[string]int{}
+		}
 		t.Run(td.name, func(t *testing.T) {
 			parsed, err := dockerfile.Parse(td.dockerfile)
 			if err != nil {
@@ -0,0 +1,112 @@
+package dockerfile
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Pin rewrites every image reference in the given Dockerfile ("FROM xxx", "COPY --from=xxx", and "RUN --mount=from=xxx") found in "pins" (keyed by the same normalized values as [Metadata.Froms], like "debian:latest") to its value (usually the same reference with "@sha256:..." added), leaving everything else (stage references, comments, whitespace, etc) exactly as it was
+func Pin(dockerfile string, pins map[string]string) string {
+	stageNames := map[string]bool{}
+	stages := 0
+
+	// returns the pinned value of the given FROM value (or "" if it shouldn't be pinned)
+	pinned := func(from string) string {
+		if stageNames[from] {
+			return ""
+		}
+		if stageNumber, err := strconv.Atoi(from); err == nil && stageNumber < stages {
+			return ""
+		}
+		return pins[latestizeRepoTag(from)]
+	}
+
+	lines := strings.SplitAfter(dockerfile, "\n")
+	continued := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if continued {
+			// see "ParseReader" (empty lines and comments inside a continuation don't end it, and we don't try to rewrite anything but the first line of an instruction)
+			if trimmed != "" && trimmed[0] != '#' {
+				continued = strings.HasSuffix(trimmed, `\`)
+			}
+			continue
+		}
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		continued = strings.HasSuffix(trimmed, `\`)
+
+		fields := strings.Fields(strings.TrimSuffix(trimmed, `\`))
+		switch strings.ToUpper(fields[0]) {
+		case "FROM":
+			for j := 1; j < len(fields); j++ {
+				if strings.HasPrefix(fields[j], "--") {
+					// "--platform=xxx"
+					continue
+				}
+				if pin := pinned(fields[j]); pin != "" {
+					lines[i] = replaceField(lines[i], j, pin)
+				}
+				if j+2 < len(fields) && strings.ToUpper(fields[j+1]) == "AS" {
+					stageNames[fields[j+2]] = true
+				}
+				break
+			}
+			stages++
+
+		case "COPY", "ADD", "RUN":
+			for j := 1; j < len(fields); j++ {
+				arg := fields[j]
+				if !strings.HasPrefix(arg, "--") {
+					break
+				}
+				if from, ok := strings.CutPrefix(arg, "--from="); ok {
+					if pin := pinned(from); pin != "" {
+						lines[i] = replaceField(lines[i], j, "--from="+pin)
+					}
+				} else if mount, ok := strings.CutPrefix(arg, "--mount="); ok {
+					// TODO more correct CSV parsing (see "ParseReader")
+					csv := strings.Split(mount, ",")
+					changed := false
+					for k, field := range csv {
+						if from, ok := strings.CutPrefix(field, "from="); ok {
+							if pin := pinned(from); pin != "" {
+								csv[k] = "from=" + pin
+								changed = true
+							}
+						}
+					}
+					if changed {
+						lines[i] = replaceField(lines[i], j, "--mount="+strings.Join(csv, ","))
+					}
+				}
+			}
+		}
+	}
+
+	return strings.Join(lines, "")
+}
+
+// replaces the n-th (zero-based) whitespace-separated field of the given line, leaving all the whitespace around it alone
+func replaceField(line string, n int, val string) string {
+	start := -1
+	for i, r := range line {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				if n == 0 {
+					return line[:start] + val + line[i:]
+				}
+				n--
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 && n == 0 {
+		return line[:start] + val
+	}
+	return line
+}
@@ -0,0 +1,72 @@
+package dockerfile_test
+
+import (
+	"testing"
+
+	"github.com/docker-library/bashbrew/pkg/dockerfile"
+)
+
+func TestPin(t *testing.T) {
+	pins := map[string]string{
+		"bash:latest":    "bash@sha256:1111",
+		"bash:5":         "bash:5@sha256:5555",
+		"busybox:latest": "busybox@sha256:bbbb",
+	}
+	for _, td := range []struct {
+		name       string
+		dockerfile string
+		expected   string
+	}{
+		{
+			dockerfile: "FROM scratch\n",
+			expected:   "FROM scratch\n",
+		},
+		{
+			dockerfile: "from   bash\t# not a comment, but whatever\n",
+			expected:   "from   bash@sha256:1111\t# not a comment, but whatever\n",
+		},
+		{
+			dockerfile: "FROM --platform=$BUILDPLATFORM bash:5 AS build",
+			expected:   "FROM --platform=$BUILDPLATFORM bash:5@sha256:5555 AS build",
+		},
+		{
+			dockerfile: "FROM debian:bookworm\n",
+			expected:   "FROM debian:bookworm\n",
+		},
+		{
+			name: "stages",
+			dockerfile: `
+				FROM bash:5 AS busybox
+				RUN echo \
+				FROM bash
+				FROM busybox
+				COPY --from=busybox / /
+				COPY --from=0 --chown=1:1 / /
+				COPY --from=bash:latest / /
+				RUN --mount=type=bind,from=bash,target=/bash --mount=type=cache,target=/cache true
+				FROM 1
+			`,
+			expected: `
+				FROM bash:5@sha256:5555 AS busybox
+				RUN echo \
+				FROM bash
+				FROM busybox
+				COPY --from=busybox / /
+				COPY --from=0 --chown=1:1 / /
+				COPY --from=bash@sha256:1111 / /
+				RUN --mount=type=bind,from=bash@sha256:1111,target=/bash --mount=type=cache,target=/cache true
+				FROM 1
+			`,
+		},
+	} {
+		if td.name == "" {
+			td.name = td.dockerfile
+		}
+		t.Run(td.name, func(t *testing.T) {
+			pinned := dockerfile.Pin(td.dockerfile, pins)
+			if pinned != td.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", td.expected, pinned)
+			}
+		})
+	}
+}
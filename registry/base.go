@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// BaseStatus is the result of [CheckBase]
+type BaseStatus struct {
+	// how we decided: "annotation" (the image records which base it was built on, so we compare that to what the base is now) or "layers" (it doesn't, so we check whether the current layers of the base are still the bottom layers of the image)
+	Method string
+
+	// the base image digest the image was built on (if it records one; see [ocispec.AnnotationBaseImageDigest])
+	Recorded digest.Digest
+
+	// what the base image currently resolves to
+	Current digest.Digest
+
+	// whether the base image has moved on since the image was built (and thus the image needs a rebuild)
+	Outdated bool
+}
+
+// CheckBase compares the given image (on the given "bashbrew architecture") to what the given base image currently resolves to in the registry, in order to find images whose base has been updated since they were built
+func CheckBase(ctx context.Context, image, base, arch string) (*BaseStatus, error) {
+	imageObj, err := ResolveArch(ctx, image, arch)
+	if err != nil {
+		return nil, err
+	}
+	baseIndex, err := Resolve(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	baseObj, err := baseIndex.arch(ctx, arch)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &BaseStatus{
+		Current: baseIndex.Desc.Digest,
+	}
+
+	manifest, err := imageObj.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config, err := imageObj.At(manifest.Config).ConfigBlob(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// images pushed by "docker push" have labels instead of annotations
+	recorded := manifest.Annotations[ocispec.AnnotationBaseImageDigest]
+	if recorded == "" {
+		recorded = config.Config.Labels[ocispec.AnnotationBaseImageDigest]
+	}
+	if recorded != "" {
+		ret.Method = "annotation"
+		ret.Recorded, err = digest.Parse(recorded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q on %q: %w", ocispec.AnnotationBaseImageDigest, image, err)
+		}
+		switch ret.Recorded {
+		case baseIndex.Desc.Digest, baseObj.Desc.Digest:
+			return ret, nil
+		}
+
+		// the base index might have changed only for *other* architectures, so see whether the recorded index still points to the same image for ours
+		baseRef, err := docker.ParseNormalizedNamed(base)
+		if err != nil {
+			return nil, err
+		}
+		recordedRef, err := docker.WithDigest(docker.TrimNamed(baseRef), ret.Recorded)
+		if err != nil {
+			return nil, err
+		}
+		recordedObj, err := ResolveArch(ctx, recordedRef.String(), arch)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("failed resolving recorded base %q: %w", recordedRef.String(), err)
+		}
+		if err == nil && recordedObj.Desc.Digest == baseObj.Desc.Digest {
+			return ret, nil
+		}
+		// (if the recorded base doesn't exist anymore, it's been replaced or deleted, which means it's safe to assume it's outdated)
+
+		ret.Outdated = true
+		return ret, nil
+	}
+
+	ret.Method = "layers"
+	baseManifest, err := baseObj.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	baseConfig, err := baseObj.At(baseManifest.Config).ConfigBlob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	baseLayers := baseConfig.RootFS.DiffIDs
+	ret.Outdated = len(baseLayers) > len(config.RootFS.DiffIDs) || !slices.Equal(baseLayers, config.RootFS.DiffIDs[:len(baseLayers)])
+	return ret, nil
+}
+
+// ResolveArch resolves the given image and returns the (single-image) manifest for the given "bashbrew architecture" (see [ResolvedObject.Architectures])
+func ResolveArch(ctx context.Context, image, arch string) (*ResolvedObject, error) {
+	obj, err := Resolve(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	return obj.arch(ctx, arch)
+}
+
+func (obj ResolvedObject) arch(ctx context.Context, arch string) (*ResolvedObject, error) {
+	arches, err := obj.Architectures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objs := arches[arch]
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("%q has no image for %q: %w", obj.ImageRef, arch, errdefs.ErrNotFound)
+	}
+	return &objs[0], nil
+}
@@ -0,0 +1,110 @@
+package registry_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker-library/bashbrew/registry"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCheckBase(t *testing.T) {
+	ctx := context.Background()
+
+	check := func(t *testing.T, reg *testRegistry, method string, outdated bool) {
+		t.Helper()
+		status, err := registry.CheckBase(ctx, reg.Host+"/library/img:1", reg.Host+"/library/base:1", "amd64")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Method != method {
+			t.Errorf("expected method %q; got %q", method, status.Method)
+		}
+		if status.Outdated != outdated {
+			t.Errorf("expected outdated %t; got %t (%+v)", outdated, status.Outdated, status)
+		}
+	}
+
+	// pushes an image with the given layers (as "diff_ids", which is all "CheckBase" looks at) and annotations
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	push := func(t *testing.T, reg *testRegistry, repo, tag string, platform ocispec.Platform, annotations map[string]string, layers ...string) ocispec.Descriptor {
+		t.Helper()
+		layerBytes := [][]byte{}
+		for _, layer := range layers {
+			layerBytes = append(layerBytes, []byte(layer))
+		}
+		return pushTestManifest(t, reg, repo, tag, platform, ocispec.MediaTypeImageConfig, ocispec.MediaTypeImageLayerGzip, annotations, layerBytes...)
+	}
+
+	t.Run("annotation", func(t *testing.T) {
+		reg := newTestRegistry(t)
+		base := push(t, reg, "library/base", "1", amd64, nil, "base")
+		push(t, reg, "library/img", "1", amd64, map[string]string{
+			ocispec.AnnotationBaseImageDigest: base.Digest.String(),
+		}, "base", "img")
+		check(t, reg, "annotation", false)
+
+		push(t, reg, "library/base", "1", amd64, nil, "base v2")
+		check(t, reg, "annotation", true)
+	})
+
+	t.Run("annotation other architecture", func(t *testing.T) {
+		reg := newTestRegistry(t)
+		baseAmd64 := push(t, reg, "library/base", "", amd64, nil, "base")
+		baseArm64 := push(t, reg, "library/base", "", arm64, nil, "arm64 layer")
+		index := pushTestIndex(t, reg, "library/base", "1", baseAmd64, baseArm64)
+		push(t, reg, "library/img", "1", amd64, map[string]string{
+			ocispec.AnnotationBaseImageDigest: index.Digest.String(),
+		}, "base", "img")
+		check(t, reg, "annotation", false)
+
+		// an update for only arm64 shouldn't make amd64 outdated
+		baseArm64v2 := push(t, reg, "library/base", "", arm64, nil, "arm64 layer v2")
+		pushTestIndex(t, reg, "library/base", "1", baseAmd64, baseArm64v2)
+		check(t, reg, "annotation", false)
+
+		baseAmd64v2 := push(t, reg, "library/base", "", amd64, nil, "base v2")
+		pushTestIndex(t, reg, "library/base", "1", baseAmd64v2, baseArm64v2)
+		check(t, reg, "annotation", true)
+	})
+
+	t.Run("annotation deleted", func(t *testing.T) {
+		reg := newTestRegistry(t)
+		push(t, reg, "library/base", "1", amd64, nil, "base v2")
+		// the recorded base (an index that's since been replaced) doesn't exist in the registry anymore
+		push(t, reg, "library/img", "1", amd64, map[string]string{
+			ocispec.AnnotationBaseImageDigest: digest.FromString("some old index").String(),
+		}, "base", "img")
+		check(t, reg, "annotation", true)
+	})
+
+	t.Run("annotation error", func(t *testing.T) {
+		reg := newTestRegistry(t)
+		push(t, reg, "library/base", "1", amd64, nil, "base v2")
+		// the recorded base exists, but we can't make sense of it, which is not the same thing as it being gone
+		recorded := reg.putManifest("library/base", "", ocispec.MediaTypeImageIndex, []byte("not an index"))
+		push(t, reg, "library/img", "1", amd64, map[string]string{
+			ocispec.AnnotationBaseImageDigest: recorded.String(),
+		}, "base", "img")
+		if status, err := registry.CheckBase(ctx, reg.Host+"/library/img:1", reg.Host+"/library/base:1", "amd64"); err == nil {
+			t.Errorf("expected error; got %+v", status)
+		}
+	})
+
+	t.Run("layers", func(t *testing.T) {
+		reg := newTestRegistry(t)
+		push(t, reg, "library/base", "1", amd64, nil, "base 1", "base 2")
+		push(t, reg, "library/img", "1", amd64, nil, "base 1", "base 2", "img")
+		check(t, reg, "layers", false)
+
+		push(t, reg, "library/base", "1", amd64, nil, "base 1", "base 2 v2")
+		check(t, reg, "layers", true)
+
+		// a base with more layers than the image can't possibly be its base
+		push(t, reg, "library/base", "1", amd64, nil, "base 1", "base 2", "img", "more")
+		check(t, reg, "layers", true)
+	})
+}
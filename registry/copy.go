@@ -0,0 +1,222 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// https://github.com/containerd/containerd/blob/v1.6.19/remotes/docker/handler.go#L34-L35 (the annotation containerd's pusher uses to find cross-repository blob mount candidates)
+const distributionSourceLabel = "containerd.io/distribution.source"
+
+// fetchBytes fetches the raw bytes of the object (verifying both size and digest), which is necessary for manifests/indexes we intend to re-push byte-for-byte
+func (obj ResolvedObject) fetchBytes(ctx context.Context) ([]byte, error) {
+	// prevent go-digest panics later
+	if err := obj.Desc.Digest.Validate(); err != nil {
+		return nil, err
+	}
+
+	r, err := obj.fetcher.Fetch(ctx, obj.Desc)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// +1 to allow us to detect if we read too much
+	b, err := io.ReadAll(io.LimitReader(r, obj.Desc.Size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != obj.Desc.Size {
+		return nil, fmt.Errorf("size of %q is %d, not %d", obj.Desc.Digest.String(), len(b), obj.Desc.Size)
+	}
+	if obj.Desc.Digest.Algorithm().FromBytes(b) != obj.Desc.Digest {
+		return nil, fmt.Errorf("digest of %q not correct", obj.Desc.Digest.String())
+	}
+
+	return b, nil
+}
+
+type copier struct {
+	src    ResolvedObject
+	pusher remotes.Pusher
+
+	// the "containerd.io/distribution.source.HOST" annotation (and value) to attach to blobs so the registry can mount them instead of us uploading them
+	mountKey, mountValue string
+}
+
+// pushes the given descriptor, only calling "open" for the content if the destination doesn't have it already
+func (c copier) push(ctx context.Context, desc ocispec.Descriptor, open func() (io.ReadCloser, error)) error {
+	if !images.IsLayerType(desc.MediaType) && !images.IsKnownConfig(desc.MediaType) && !images.IsManifestType(desc.MediaType) && !images.IsIndexType(desc.MediaType) {
+		// things like attestation blobs ("application/vnd.in-toto+json") are perfectly fine, but containerd warns loudly about them without an explicit ref key prefix
+		ctx = remotes.WithMediaTypeKeyPrefix(ctx, desc.MediaType, "blob")
+	}
+
+	w, err := c.pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			// already exists (or was successfully mounted from the source repository)
+			return nil
+		}
+		return err
+	}
+	defer w.Close()
+
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return content.Copy(ctx, w, r, desc.Size, desc.Digest)
+}
+
+func bytesOpener(b []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+}
+
+func (c copier) copyBlob(ctx context.Context, desc ocispec.Descriptor) error {
+	if images.IsNonDistributable(desc.MediaType) {
+		// "foreign" layers (Windows base images) live elsewhere and registries will refuse them anyhow
+		return nil
+	}
+
+	annotations := map[string]string{}
+	for key, val := range desc.Annotations {
+		annotations[key] = val
+	}
+	if c.mountKey != "" {
+		annotations[c.mountKey] = c.mountValue
+	}
+	desc.Annotations = annotations
+
+	return c.push(ctx, desc, func() (io.ReadCloser, error) {
+		return c.src.fetcher.Fetch(ctx, desc)
+	})
+}
+
+// recursively copies the given manifest or index (and everything it references) and then pushes the object itself by digest
+func (c copier) copyManifest(ctx context.Context, desc ocispec.Descriptor) error {
+	b, err := c.src.At(desc).fetchBytes(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		var index ocispec.Index
+		if err := json.Unmarshal(b, &index); err != nil {
+			return err
+		}
+		// this includes attestation manifests (they are just manifests with an "unknown/unknown" platform)
+		for _, child := range index.Manifests {
+			if err := c.copyManifest(ctx, child); err != nil {
+				return fmt.Errorf("failed copying %s (from %s): %w", child.Digest, desc.Digest, err)
+			}
+		}
+
+	case images.IsManifestType(desc.MediaType):
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return err
+		}
+		for _, blob := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+			if err := c.copyBlob(ctx, blob); err != nil {
+				return fmt.Errorf("failed copying blob %s (from %s): %w", blob.Digest, desc.Digest, err)
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown media type: %q", desc.MediaType)
+	}
+
+	return c.push(ctx, desc, bytesOpener(b))
+}
+
+// returns a copier for pushing "src" (and everything it references) by digest into the repository named "dstName" (ala "docker.io/tianon/foo"), using cross-repository blob mounts when "src" lives on the same registry
+func newCopier(ctx context.Context, resolver remotes.Resolver, src ResolvedObject, dstName string) (*copier, error) {
+	srcRef, err := docker.ParseNormalizedNamed(src.ImageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// push everything by digest (a tag reference would cause containerd to push every child manifest to the tag itself)
+	pusher, err := resolver.Pusher(ctx, dstName+"@"+src.Desc.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+
+	c := copier{
+		src:    src,
+		pusher: pusher,
+	}
+	if srcHost, err := url.Parse("dummy://" + docker.Domain(srcRef)); err == nil {
+		// see "selectRepositoryMountCandidate" in containerd (which only ever matches candidates from the same hostname as the destination)
+		c.mountKey = distributionSourceLabel + "." + srcHost.Hostname()
+		c.mountValue = docker.Path(srcRef)
+	}
+	return &c, nil
+}
+
+// pushes the given manifest or index (which must already be fully present in the repository by digest) to the given tag
+func pushTag(ctx context.Context, resolver remotes.Resolver, tag string, desc ocispec.Descriptor, open func() (io.ReadCloser, error)) error {
+	pusher, err := resolver.Pusher(ctx, tag)
+	if err != nil {
+		return err
+	}
+	// (see "containerdPush" -- without the "tag" annotation, containerd's status tracking will think we already pushed this object by digest and quietly skip the tag)
+	desc.Annotations = map[string]string{ocispec.AnnotationRefName: tag}
+	return copier{pusher: pusher}.push(ctx, desc, open)
+}
+
+// Copy copies the object "src" points to (and everything it references, recursively) to "dst" entirely via the registry API, preserving all digests (and using cross-repository blob mounts when "src" and "dst" live on the same registry)
+func Copy(ctx context.Context, src, dst string) (*ocispec.Descriptor, error) {
+	obj, err := Resolve(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	dstRef, err := docker.ParseNormalizedNamed(dst)
+	if err != nil {
+		return nil, err
+	}
+	dstRef = docker.TagNameOnly(dstRef)
+	dstTagged, ok := dstRef.(docker.Tagged)
+	if !ok {
+		return nil, fmt.Errorf("destination %q must be a tag", dst)
+	}
+
+	resolver := newPushResolver()
+	c, err := newCopier(ctx, resolver, *obj, dstRef.Name())
+	if err != nil {
+		return nil, err
+	}
+	if err := c.copyManifest(ctx, obj.Desc); err != nil {
+		return nil, err
+	}
+
+	// and finally, point the tag at the result
+	if err := pushTag(ctx, resolver, dstTagged.String(), obj.Desc, func() (io.ReadCloser, error) {
+		b, err := obj.fetchBytes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return bytesOpener(b)()
+	}); err != nil {
+		return nil, err
+	}
+
+	return &obj.Desc, nil
+}
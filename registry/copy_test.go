@@ -0,0 +1,146 @@
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/docker-library/bashbrew/registry"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func testDescriptor(mediaType string, b []byte) ocispec.Descriptor {
+	return ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+}
+
+// pushes a small (but complete) single-image manifest (config and the given layers, which also end up as the config's "diff_ids") with the given annotations to the given repo, returning the manifest descriptor (with "Platform" filled in)
+func pushTestManifest(t *testing.T, reg *testRegistry, repo, tag string, platform ocispec.Platform, configMediaType string, layerMediaType string, annotations map[string]string, layers ...[]byte) ocispec.Descriptor {
+	t.Helper()
+
+	image := ocispec.Image{Platform: platform}
+	image.RootFS.Type = "layers"
+	layerDescs := []ocispec.Descriptor{}
+	for _, layer := range layers {
+		image.RootFS.DiffIDs = append(image.RootFS.DiffIDs, digest.FromBytes(layer))
+		layerDescs = append(layerDescs, testDescriptor(layerMediaType, layer))
+	}
+	config, err := json.Marshal(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := json.Marshal(ocispec.Manifest{
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Config:      testDescriptor(configMediaType, config),
+		Layers:      layerDescs,
+		Annotations: annotations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.putBlob(repo, config)
+	for _, layer := range layers {
+		reg.putBlob(repo, layer)
+	}
+	reg.putManifest(repo, tag, ocispec.MediaTypeImageManifest, manifest)
+	desc := testDescriptor(ocispec.MediaTypeImageManifest, manifest)
+	desc.Platform = &platform
+	return desc
+}
+
+// pushes an index of the given manifests to the given repo, returning the index descriptor (if no manifests are given, it pushes a small but complete multi-architecture set with an attestation manifest)
+func pushTestIndex(t *testing.T, reg *testRegistry, repo, tag string, manifests ...ocispec.Descriptor) ocispec.Descriptor {
+	t.Helper()
+
+	if len(manifests) == 0 {
+		manifestDesc := func(platform ocispec.Platform, configMediaType string, layerMediaType string, layer []byte) ocispec.Descriptor {
+			return pushTestManifest(t, reg, repo, "", platform, configMediaType, layerMediaType, nil, layer)
+		}
+
+		amd64 := manifestDesc(ocispec.Platform{OS: "linux", Architecture: "amd64"}, ocispec.MediaTypeImageConfig, ocispec.MediaTypeImageLayerGzip, []byte("amd64 layer"))
+		arm64 := manifestDesc(ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, ocispec.MediaTypeImageConfig, ocispec.MediaTypeImageLayerGzip, []byte("arm64 layer"))
+		attestation := manifestDesc(ocispec.Platform{OS: "unknown", Architecture: "unknown"}, ocispec.MediaTypeImageConfig, "application/vnd.in-toto+json", []byte(`{"predicateType":"https://spdx.dev/Document"}`))
+		attestation.Annotations = map[string]string{
+			"vnd.docker.reference.type":   "attestation-manifest",
+			"vnd.docker.reference.digest": amd64.Digest.String(),
+		}
+		manifests = []ocispec.Descriptor{amd64, arm64, attestation}
+	}
+
+	index, err := json.Marshal(ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.putManifest(repo, tag, ocispec.MediaTypeImageIndex, index)
+	return testDescriptor(ocispec.MediaTypeImageIndex, index)
+}
+
+func TestCopy(t *testing.T) {
+	reg := newTestRegistry(t)
+	expected := pushTestIndex(t, reg, "staging/img", "1.0")
+	ctx := context.Background()
+
+	desc, err := registry.Copy(ctx, reg.Host+"/staging/img:1.0", reg.Host+"/production/img:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != expected.Digest {
+		t.Errorf("expected %s; got %s", expected.Digest, desc.Digest)
+	}
+
+	obj, err := registry.Resolve(ctx, reg.Host+"/production/img:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Desc.Digest != expected.Digest {
+		t.Errorf("expected tag to point at %s; got %s", expected.Digest, obj.Desc.Digest)
+	}
+
+	// every blob should have been mounted rather than uploaded (same registry)
+	if len(reg.blobs["production/img"]) != len(reg.blobs["staging/img"]) {
+		t.Errorf("expected %d blobs; got %d", len(reg.blobs["staging/img"]), len(reg.blobs["production/img"]))
+	}
+	if reg.mounts != len(reg.blobs["staging/img"]) {
+		t.Errorf("expected %d blob mounts; got %d", len(reg.blobs["staging/img"]), reg.mounts)
+	}
+
+	// every manifest (including the attestation) should have been pushed, and only the one tag we asked for should exist
+	if len(reg.manifests["production/img"]) != len(reg.manifests["staging/img"]) {
+		t.Errorf("expected %d manifests; got %d", len(reg.manifests["staging/img"]), len(reg.manifests["production/img"]))
+	}
+	tags, err := registry.ListTags(ctx, reg.Host+"/production/img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"1.0"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected tags %q; got %q", expected, tags)
+	}
+}
+
+func TestCopyTwice(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushTestIndex(t, reg, "staging/img", "1.0")
+	ctx := context.Background()
+
+	// the same content to two different repositories should end up complete in both (see "newPushResolver")
+	for _, dst := range []string{"production/img", "mirror/img"} {
+		if _, err := registry.Copy(ctx, reg.Host+"/staging/img:1.0", reg.Host+"/"+dst+":1.0"); err != nil {
+			t.Fatal(err)
+		}
+		if len(reg.blobs[dst]) != len(reg.blobs["staging/img"]) {
+			t.Errorf("%s: expected %d blobs; got %d", dst, len(reg.blobs["staging/img"]), len(reg.blobs[dst]))
+		}
+		if len(reg.manifests[dst]) != len(reg.manifests["staging/img"]) {
+			t.Errorf("%s: expected %d manifests; got %d", dst, len(reg.manifests["staging/img"]), len(reg.manifests[dst]))
+		}
+	}
+}
@@ -71,57 +71,68 @@ func lookupDockerAuthCredentials(registry string) (usernameColonPassword string,
 	return "", nil
 }
 
+// returns the list of "RegistryHost" objects for the given registry domain (that will transparently honor DOCKERHUB_PUBLIC_PROXY for read-only lookups *and* deal with looking up credentials from ~/.docker/config.json)
+func dockerRegistryHosts(domain string) ([]dockerremote.RegistryHost, error) {
+	// https://github.com/containerd/containerd/blob/v1.6.10/remotes/docker/registry.go#L152-L198
+	config := dockerremote.RegistryHost{
+		Host:         domain,
+		Scheme:       "https",
+		Path:         "/v2",
+		Capabilities: dockerremote.HostCapabilityPull | dockerremote.HostCapabilityResolve | dockerremote.HostCapabilityPush,
+		Authorizer: dockerremote.NewDockerAuthorizer(dockerremote.WithAuthCreds(func(_ string) (string, string, error) {
+			usernameColonPassword, err := lookupDockerAuthCredentials(domain)
+			if err != nil {
+				return "", "", err
+			}
+			username, password, _ := strings.Cut(usernameColonPassword, ":")
+			return username, password, nil
+		})),
+	}
+	if domain == "docker.io" {
+		// https://github.com/containerd/containerd/blob/v1.6.10/remotes/docker/registry.go#L193
+		config.Host = "registry-1.docker.io"
+
+		if publicProxy := os.Getenv("DOCKERHUB_PUBLIC_PROXY"); publicProxy != "" {
+			publicProxyURL, err := url.Parse(publicProxy)
+			if err != nil {
+				return nil, err
+			}
+			proxyConfig := dockerremote.RegistryHost{
+				Host:         publicProxyURL.Host,
+				Scheme:       publicProxyURL.Scheme,
+				Path:         path.Join(publicProxyURL.Path, config.Path),
+				Capabilities: dockerremote.HostCapabilityPull | dockerremote.HostCapabilityResolve,
+			}
+			return []dockerremote.RegistryHost{
+				proxyConfig,
+				config,
+			}, nil
+		}
+	} else if strings.Contains(domain, "localhost") {
+		config.Scheme = "http"
+	}
+	return []dockerremote.RegistryHost{config}, nil
+}
+
 var (
 	resolver     remotes.Resolver
 	resolverOnce sync.Once
 )
 
-// returns a containerd "Resolver" suitable for interacting with registries (that will transparently honor DOCKERHUB_PUBLIC_PROXY for read-only lookups *and* deal with looking up credentials from ~/.docker/config.json)
+// returns a containerd "Resolver" suitable for interacting with registries (see "dockerRegistryHosts")
 func NewDockerAuthResolver() remotes.Resolver {
 	resolverOnce.Do(func() {
 		resolver = dockerremote.NewResolver(dockerremote.ResolverOptions{
-			Hosts: func(domain string) ([]dockerremote.RegistryHost, error) {
-				// https://github.com/containerd/containerd/blob/v1.6.10/remotes/docker/registry.go#L152-L198
-				config := dockerremote.RegistryHost{
-					Host:         domain,
-					Scheme:       "https",
-					Path:         "/v2",
-					Capabilities: dockerremote.HostCapabilityPull | dockerremote.HostCapabilityResolve | dockerremote.HostCapabilityPush,
-					Authorizer: dockerremote.NewDockerAuthorizer(dockerremote.WithAuthCreds(func(_ string) (string, string, error) {
-						usernameColonPassword, err := lookupDockerAuthCredentials(domain)
-						if err != nil {
-							return "", "", err
-						}
-						username, password, _ := strings.Cut(usernameColonPassword, ":")
-						return username, password, nil
-					})),
-				}
-				if domain == "docker.io" {
-					// https://github.com/containerd/containerd/blob/v1.6.10/remotes/docker/registry.go#L193
-					config.Host = "registry-1.docker.io"
-
-					if publicProxy := os.Getenv("DOCKERHUB_PUBLIC_PROXY"); publicProxy != "" {
-						publicProxyURL, err := url.Parse(publicProxy)
-						if err != nil {
-							return nil, err
-						}
-						proxyConfig := dockerremote.RegistryHost{
-							Host:         publicProxyURL.Host,
-							Scheme:       publicProxyURL.Scheme,
-							Path:         path.Join(publicProxyURL.Path, config.Path),
-							Capabilities: dockerremote.HostCapabilityPull | dockerremote.HostCapabilityResolve,
-						}
-						return []dockerremote.RegistryHost{
-							proxyConfig,
-							config,
-						}, nil
-					}
-				} else if strings.Contains(domain, "localhost") {
-					config.Scheme = "http"
-				}
-				return []dockerremote.RegistryHost{config}, nil
-			},
+			Hosts: dockerRegistryHosts,
 		})
 	})
 	return resolver
 }
+
+// returns a fresh containerd "Resolver" for pushing content (containerd's push status tracking is keyed only by media type and digest, not by repository, so a shared tracker would quietly skip pushing content to a second repository after it was pushed to the first one)
+func newPushResolver() remotes.Resolver {
+	return dockerremote.NewResolver(dockerremote.ResolverOptions{
+		Hosts:   dockerRegistryHosts,
+		Tracker: dockerremote.NewInMemoryTracker(),
+	})
+}
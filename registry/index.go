@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// PutIndex assembles an OCI index out of the given objects (copying each of them into the repository of "tags" by digest first, the same way [Copy] does) and pushes it to every one of "tags" (which must all be in the same repository), returning the index descriptor
+//
+// Members which are single-image manifests are listed with their "Desc.Platform" (see [ResolvedObject.At]), with "os.version" and "os.features" filled in from the image config (the same way "manifest-tool" does, since the config is authoritative for those and they include details like the Windows revision that nothing else knows); members which are indexes themselves (images with attestations, for example) are flattened, listing each of their manifests with the platform (and annotations) of the original.
+func PutIndex(ctx context.Context, tags []string, members []ResolvedObject, annotations map[string]string) (*ocispec.Descriptor, error) {
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no tags to put index to")
+	}
+
+	var (
+		repoName   string
+		taggedRefs []string
+	)
+	for i, tag := range tags {
+		ref, err := docker.ParseNormalizedNamed(tag)
+		if err != nil {
+			return nil, err
+		}
+		tagged, ok := docker.TagNameOnly(ref).(docker.Tagged)
+		if !ok {
+			return nil, fmt.Errorf("%q must be a tag", tag)
+		}
+		if i == 0 {
+			repoName = ref.Name()
+		} else if ref.Name() != repoName {
+			return nil, fmt.Errorf("%q is not in the same repository as %q", tag, tags[0])
+		}
+		taggedRefs = append(taggedRefs, tagged.String())
+	}
+
+	resolver := newPushResolver()
+
+	index := ocispec.Index{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageIndex,
+		Manifests:   []ocispec.Descriptor{},
+		Annotations: annotations,
+	}
+	for _, member := range members {
+		c, err := newCopier(ctx, resolver, member, repoName)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.copyManifest(ctx, member.Desc); err != nil {
+			return nil, fmt.Errorf("failed copying %s (%s): %w", member.Desc.Digest, member.ImageRef, err)
+		}
+
+		manifests, err := member.Manifests(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range manifests {
+			platform := desc.Platform
+			if member.IsImageManifest() {
+				platform, err = member.configPlatform(ctx, platform)
+				if err != nil {
+					return nil, fmt.Errorf("failed reading the platform of %s (%s): %w", member.Desc.Digest, member.ImageRef, err)
+				}
+			}
+			index.Manifests = append(index.Manifests, ocispec.Descriptor{
+				MediaType:   desc.MediaType,
+				Digest:      desc.Digest,
+				Size:        desc.Size,
+				Platform:    platform,
+				Annotations: desc.Annotations,
+			})
+		}
+	}
+
+	b, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	for _, tag := range taggedRefs {
+		if err := pushTag(ctx, resolver, tag, desc, bytesOpener(b)); err != nil {
+			return nil, fmt.Errorf("failed pushing %q: %w", tag, err)
+		}
+	}
+
+	return &desc, nil
+}
+
+// returns a copy of the given platform with "os.version" and "os.features" from the config of the given single-image manifest (if it has them)
+func (obj ResolvedObject) configPlatform(ctx context.Context, platform *ocispec.Platform) (*ocispec.Platform, error) {
+	m, err := obj.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config, err := obj.At(m.Config).ConfigBlob(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := ocispec.Platform{}
+	if platform != nil {
+		ret = *platform
+	} else {
+		ret = ocispec.Platform{
+			OS:           config.OS,
+			Architecture: config.Architecture,
+			Variant:      config.Variant,
+		}
+	}
+	if config.OSVersion != "" {
+		ret.OSVersion = config.OSVersion
+	}
+	if len(config.OSFeatures) > 0 {
+		ret.OSFeatures = config.OSFeatures
+	}
+	return &ret, nil
+}
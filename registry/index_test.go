@@ -0,0 +1,116 @@
+package registry_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker-library/bashbrew/registry"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPutIndex(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushTestManifest(t, reg, "amd64/img", "1.0", ocispec.Platform{OS: "linux", Architecture: "amd64"}, ocispec.MediaTypeImageConfig, ocispec.MediaTypeImageLayerGzip, nil, []byte("amd64 layer"))
+	pushTestIndex(t, reg, "arm64v8/img", "1.0")
+	ctx := context.Background()
+
+	members := []registry.ResolvedObject{}
+	for _, image := range []string{"amd64/img:1.0", "arm64v8/img:1.0"} {
+		obj, err := registry.Resolve(ctx, reg.Host+"/"+image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.IsImageManifest() {
+			obj.Desc.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+		}
+		members = append(members, *obj)
+	}
+
+	annotations := map[string]string{ocispec.AnnotationVersion: "1.0"}
+	desc, err := registry.PutIndex(ctx, []string{reg.Host + "/library/img:1.0", reg.Host + "/library/img:latest"}, members, annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range []string{"1.0", "latest"} {
+		obj, err := registry.Resolve(ctx, reg.Host+"/library/img:"+tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.Desc.Digest != desc.Digest {
+			t.Errorf("%s: expected %s; got %s", tag, desc.Digest, obj.Desc.Digest)
+		}
+	}
+
+	obj, err := registry.Resolve(ctx, reg.Host+"/library/img:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := obj.Index(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Annotations[ocispec.AnnotationVersion] != "1.0" {
+		t.Errorf("expected index annotations %v; got %v", annotations, index.Annotations)
+	}
+	// one manifest from "amd64/img", and all three (flattened) from "arm64v8/img"
+	if len(index.Manifests) != 4 {
+		t.Fatalf("expected 4 manifests; got %d", len(index.Manifests))
+	}
+	if plat := index.Manifests[0].Platform; plat == nil || plat.Architecture != "amd64" {
+		t.Errorf("expected amd64 platform on the first manifest; got %+v", plat)
+	}
+	if index.Manifests[3].Annotations["vnd.docker.reference.type"] != "attestation-manifest" {
+		t.Errorf("expected attestation annotations to be preserved; got %v", index.Manifests[3].Annotations)
+	}
+
+	// every manifest should now also exist in the target repository (by digest)
+	for _, m := range index.Manifests {
+		if _, ok := reg.manifests["library/img"][m.Digest.String()]; !ok {
+			t.Errorf("expected %s in library/img", m.Digest)
+		}
+	}
+	blobs := map[string]bool{}
+	for _, repo := range []string{"amd64/img", "arm64v8/img"} {
+		for dgst := range reg.blobs[repo] {
+			blobs[dgst.String()] = true
+		}
+	}
+	if len(reg.blobs["library/img"]) != len(blobs) {
+		t.Errorf("expected %d blobs; got %d", len(blobs), len(reg.blobs["library/img"]))
+	}
+}
+
+func TestPutIndexConfigPlatform(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushTestManifest(t, reg, "winamd64/img", "1.0", ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.2227", OSFeatures: []string{"win32k"}}, ocispec.MediaTypeImageConfig, ocispec.MediaTypeImageLayerGzip, nil, []byte("windows layer"))
+	ctx := context.Background()
+
+	obj, err := registry.Resolve(ctx, reg.Host+"/winamd64/img:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a less specific guess (ala "Repo.ArchOSVersion") should lose to the config
+	obj.Desc.Platform = &ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348"}
+
+	if _, err := registry.PutIndex(ctx, []string{reg.Host + "/library/img:1.0"}, []registry.ResolvedObject{*obj}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err = registry.Resolve(ctx, reg.Host+"/library/img:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := obj.Index(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 {
+		t.Fatalf("expected 1 manifest; got %d", len(index.Manifests))
+	}
+	plat := index.Manifests[0].Platform
+	if plat == nil || plat.OS != "windows" || plat.OSVersion != "10.0.20348.2227" || len(plat.OSFeatures) != 1 || plat.OSFeatures[0] != "win32k" {
+		t.Errorf("expected the os.version and os.features of the config; got %+v", plat)
+	}
+}
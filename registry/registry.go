@@ -144,10 +144,8 @@ func (obj ResolvedObject) Architectures(ctx context.Context) (map[string][]Resol
 		objPlat := architecture.Normalize(*obj.Desc.Platform)
 		obj.Desc.Platform = &objPlat
 
-		for arch, plat := range architecture.SupportedArches {
-			if plat.Is(architecture.OCIPlatform(objPlat)) {
-				ret[arch] = append(ret[arch], *obj)
-			}
+		for _, arch := range architecture.MatchingArches(architecture.OCIPlatform(objPlat)) {
+			ret[arch] = append(ret[arch], *obj)
 		}
 	}
 	return ret, nil
@@ -0,0 +1,262 @@
+package registry_test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testRegistry is a tiny in-memory stand-in for a registry, implementing just enough of the distribution API for our tests (manifests, blobs, uploads, cross-repository mounts, tag listing, tag deletion, and referrers)
+type testRegistry struct {
+	// the "host:port" of the registry ("localhost" so that we get plain HTTP; see "dockerRegistryHosts")
+	Host string
+
+	mu        sync.Mutex
+	manifests map[string]map[string][]byte        // repo -> digest -> content
+	types     map[string]string                   // digest -> media type
+	tags      map[string]map[string]digest.Digest // repo -> tag -> digest
+	blobs     map[string]map[digest.Digest][]byte // repo -> digest -> content
+	uploads   map[string][]byte                   // upload ID -> content so far
+	mounts    int                                 // number of successful cross-repository mounts
+	deleted   []string                            // "repo:tag" values deleted
+
+	// whether to pretend we do not support the referrers API (so clients have to fall back to the "referrers tag schema")
+	noReferrers bool
+}
+
+var testRegistryRegex = regexp.MustCompile(`^/v2/(.+?)/(manifests|blobs/uploads|blobs|tags|referrers)/(.*)$`)
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	t.Helper()
+
+	reg := &testRegistry{
+		manifests: map[string]map[string][]byte{},
+		types:     map[string]string{},
+		tags:      map[string]map[string]digest.Digest{},
+		blobs:     map[string]map[digest.Digest][]byte{},
+		uploads:   map[string][]byte{},
+	}
+
+	server := httptest.NewServer(reg)
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.Host = "localhost:" + u.Port()
+
+	return reg
+}
+
+func (reg *testRegistry) putManifest(repo, tag, mediaType string, b []byte) digest.Digest {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.putManifestLocked(repo, tag, mediaType, b)
+}
+
+func (reg *testRegistry) putManifestLocked(repo, tag, mediaType string, b []byte) digest.Digest {
+	dgst := digest.FromBytes(b)
+	if reg.manifests[repo] == nil {
+		reg.manifests[repo] = map[string][]byte{}
+	}
+	reg.manifests[repo][dgst.String()] = b
+	reg.types[dgst.String()] = mediaType
+	if tag != "" {
+		if reg.tags[repo] == nil {
+			reg.tags[repo] = map[string]digest.Digest{}
+		}
+		reg.tags[repo][tag] = dgst
+	}
+	return dgst
+}
+
+func (reg *testRegistry) putBlob(repo string, b []byte) digest.Digest {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	dgst := digest.FromBytes(b)
+	if reg.blobs[repo] == nil {
+		reg.blobs[repo] = map[digest.Digest][]byte{}
+	}
+	reg.blobs[repo][dgst] = b
+	return dgst
+}
+
+func (reg *testRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// read the (possibly streamed) request body before taking the lock, otherwise a client that fetches from us while still uploading to us would deadlock
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if r.URL.Path == "/v2/" {
+		return
+	}
+	matches := testRegistryRegex.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		http.NotFound(w, r)
+		return
+	}
+	repo, kind, ref := matches[1], matches[2], matches[3]
+
+	switch {
+	case kind == "tags" && ref == "list" && r.Method == http.MethodGet:
+		tags := []string{}
+		for tag := range reg.tags[repo] {
+			tags = append(tags, tag)
+		}
+		slices.Sort(tags)
+		json.NewEncoder(w).Encode(map[string]any{"name": repo, "tags": tags})
+
+	case kind == "referrers" && r.Method == http.MethodGet && !reg.noReferrers:
+		index := ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: []ocispec.Descriptor{},
+		}
+		for dgst, b := range reg.manifests[repo] {
+			var manifest ocispec.Manifest
+			if err := json.Unmarshal(b, &manifest); err != nil || manifest.Subject == nil || manifest.Subject.Digest.String() != ref {
+				continue
+			}
+			// https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#listing-referrers
+			artifactType := manifest.ArtifactType
+			if artifactType == "" {
+				artifactType = manifest.Config.MediaType
+			}
+			if filter := r.URL.Query().Get("artifactType"); filter != "" && filter != artifactType {
+				continue
+			}
+			index.Manifests = append(index.Manifests, ocispec.Descriptor{
+				MediaType:    reg.types[dgst],
+				Digest:       digest.Digest(dgst),
+				Size:         int64(len(b)),
+				ArtifactType: artifactType,
+			})
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+		json.NewEncoder(w).Encode(index)
+
+	case kind == "referrers":
+		// (what registries without the referrers API do)
+		http.NotFound(w, r)
+
+	case kind == "manifests" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		dgst := ref
+		if tagged, ok := reg.tags[repo][ref]; ok {
+			dgst = tagged.String()
+		}
+		b, ok := reg.manifests[repo][dgst]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", reg.types[dgst])
+		w.Header().Set("Docker-Content-Digest", dgst)
+		w.Header().Set("Content-Length", jsonInt(len(b)))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+
+	case kind == "manifests" && r.Method == http.MethodPut:
+		b := body
+		tag := ref
+		if _, err := digest.Parse(ref); err == nil {
+			tag = ""
+		}
+		dgst := reg.putManifestLocked(repo, tag, r.Header.Get("Content-Type"), b)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.WriteHeader(http.StatusCreated)
+
+	case kind == "manifests" && r.Method == http.MethodDelete:
+		if _, ok := reg.tags[repo][ref]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(reg.tags[repo], ref)
+		reg.deleted = append(reg.deleted, repo+":"+ref)
+		w.WriteHeader(http.StatusAccepted)
+
+	case kind == "blobs" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		b, ok := reg.blobs[repo][digest.Digest(ref)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", ref)
+		w.Header().Set("Content-Length", jsonInt(len(b)))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+
+	case kind == "blobs/uploads" && r.Method == http.MethodPost:
+		if mount, from := r.URL.Query().Get("mount"), r.URL.Query().Get("from"); mount != "" && from != "" {
+			if b, ok := reg.blobs[from][digest.Digest(mount)]; ok {
+				if reg.blobs[repo] == nil {
+					reg.blobs[repo] = map[digest.Digest][]byte{}
+				}
+				reg.blobs[repo][digest.Digest(mount)] = b
+				reg.mounts++
+				w.Header().Set("Docker-Content-Digest", mount)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		id := make([]byte, 8)
+		rand.Read(id)
+		uuid := hex.EncodeToString(id)
+		reg.uploads[uuid] = nil
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+uuid)
+		w.WriteHeader(http.StatusAccepted)
+
+	case kind == "blobs/uploads" && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		if _, ok := reg.uploads[ref]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		b := body
+		reg.uploads[ref] = append(reg.uploads[ref], b...)
+		if r.Method == http.MethodPatch {
+			w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+ref)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		b = reg.uploads[ref]
+		delete(reg.uploads, ref)
+		dgst := digest.FromBytes(b)
+		if expected := r.URL.Query().Get("digest"); expected != dgst.String() {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		if reg.blobs[repo] == nil {
+			reg.blobs[repo] = map[digest.Digest][]byte{}
+		}
+		reg.blobs[repo][dgst] = b
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func jsonInt(i int) string {
+	b, _ := json.Marshal(i)
+	return string(b)
+}
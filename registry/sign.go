@@ -0,0 +1,397 @@
+package registry
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference/docker"
+	dockerremote "github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// the media types and annotation cosign uses for signatures stored as OCI referrers ("cosign sign --registry-referrers-mode=oci-1-1"; https://github.com/sigstore/cosign/blob/v2.2.0/specs/SIGNATURE_SPEC.md)
+const (
+	SignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
+	simpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	signatureAnnotation    = "dev.cosignproject.cosign/signature"
+)
+
+// the "critical" section of a "simple signing" payload (https://github.com/containers/image/blob/v5.28.0/docs/containers-signature.5.md#json-data-format)
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]any `json:"optional"`
+}
+
+const simpleSigningType = "cosign container image signature"
+
+// Signer signs images with a local (unencrypted) private key
+type Signer struct {
+	key crypto.Signer
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %q", file)
+	}
+	return block, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		// notably, this includes "ENCRYPTED COSIGN PRIVATE KEY" (encrypted keys need to be decrypted before we can use them)
+		return nil, fmt.Errorf("unsupported PEM block type: %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+	return signer, nil
+}
+
+// LoadSigner reads a PEM-encoded ECDSA, Ed25519, or RSA private key (PKCS #8, SEC 1, or PKCS #1) from the given file
+func LoadSigner(file string) (*Signer, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing %q: %w", file, err)
+	}
+	return &Signer{key: key}, nil
+}
+
+// Public returns the public half of the signing key (suitable for [ResolvedObject.Signatures])
+func (s Signer) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+// LoadPublicKey reads a PEM-encoded public key ("cosign.pub", for example) from the given file (a private key is accepted too, in which case its public half is returned)
+func LoadPublicKey(file string) (crypto.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "PUBLIC KEY" {
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing %q: %w", file, err)
+	}
+	return key.Public(), nil
+}
+
+func (s Signer) sign(payload []byte) ([]byte, error) {
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		// Ed25519 signs the message itself, not a hash of it
+		return s.key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	sum := sha256.Sum256(payload)
+	return s.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+}
+
+func verifySignature(pub crypto.PublicKey, payload, sig []byte) bool {
+	sum := sha256.Sum256(payload)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(pub, sum[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, payload, sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	default:
+		return false
+	}
+}
+
+// the repository name (ala "docker.io/library/hello-world") of the given object
+func (obj ResolvedObject) repoName() (string, error) {
+	ref, err := docker.ParseNormalizedNamed(obj.ImageRef)
+	if err != nil {
+		return "", err
+	}
+	return ref.Name(), nil
+}
+
+// the tag the OCI "referrers tag schema" uses for the given digest, for registries without the referrers API (https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#referrers-tag-schema)
+func referrersTag(dgst digest.Digest) string {
+	return dgst.Algorithm().String() + "-" + dgst.Encoded()
+}
+
+// fetches the referrers index for the given digest via the referrers API (returning an error that satisfies "errdefs.IsNotFound" if the registry does not support the API)
+func hostReferrers(ctx context.Context, host dockerremote.RegistryHost, repoPath string, dgst digest.Digest, artifactType string) (*ocispec.Index, error) {
+	u := url.URL{
+		Scheme: host.Scheme,
+		Host:   host.Host,
+		Path:   host.Path + "/" + repoPath + "/referrers/" + dgst.String(),
+	}
+	if artifactType != "" {
+		u.RawQuery = url.Values{"artifactType": {artifactType}}.Encode()
+	}
+	resp, err := hostRequest(ctx, host, http.MethodGet, u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, "fetching referrers of "+dgst.String())
+	}
+
+	var index ocispec.Index
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// returns the referrers index of the given object and whether the registry supports the referrers API (if it does not, the index comes from the "referrers tag schema" tag instead, and is empty if that tag does not exist yet)
+func (obj ResolvedObject) referrers(ctx context.Context, artifactType string) (*ocispec.Index, bool, error) {
+	repoName, err := obj.repoName()
+	if err != nil {
+		return nil, false, err
+	}
+	hosts, repoPath, err := repositoryHosts(repoName, dockerremote.HostCapabilityResolve)
+	if err != nil {
+		return nil, false, err
+	}
+	ctx = dockerremote.WithScope(ctx, "repository:"+repoPath+":pull")
+
+	index, err := hostReferrers(ctx, hosts[0], repoPath, obj.Desc.Digest, artifactType)
+	if err == nil {
+		return index, true, nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return nil, false, err
+	}
+
+	// no referrers API, so fall back to the "referrers tag schema"
+	tagObj, err := Resolve(ctx, repoName+":"+referrersTag(obj.Desc.Digest))
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return &ocispec.Index{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: ocispec.MediaTypeImageIndex,
+			}, false, nil
+		}
+		return nil, false, err
+	}
+	index, err = tagObj.Index(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	return index, false, nil
+}
+
+// Referrers returns the descriptors of every manifest whose "subject" is this object, optionally filtered to the given artifact type (via the referrers API, or the "referrers tag schema" on registries that do not support it)
+func (obj ResolvedObject) Referrers(ctx context.Context, artifactType string) ([]ocispec.Descriptor, error) {
+	index, _, err := obj.referrers(ctx, artifactType)
+	if err != nil {
+		return nil, err
+	}
+	// (registries are allowed to ignore the "artifactType" filter, and the referrers tag schema has no filtering at all)
+	ret := []ocispec.Descriptor{}
+	for _, desc := range index.Manifests {
+		if artifactType == "" || desc.ArtifactType == artifactType {
+			ret = append(ret, desc)
+		}
+	}
+	return ret, nil
+}
+
+// Signatures returns the descriptors of every signature referrer of this object which has a valid signature (made by the given public key) over a payload that names this object's digest
+func (obj ResolvedObject) Signatures(ctx context.Context, pub crypto.PublicKey) ([]ocispec.Descriptor, error) {
+	referrers, err := obj.Referrers(ctx, SignatureArtifactType)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []ocispec.Descriptor{}
+	for _, desc := range referrers {
+		manifest, err := obj.At(desc).Manifest(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching signature %s: %w", desc.Digest, err)
+		}
+		if manifest.Subject == nil || manifest.Subject.Digest != obj.Desc.Digest {
+			continue
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != simpleSigningMediaType {
+				continue
+			}
+			sig, err := base64.StdEncoding.DecodeString(layer.Annotations[signatureAnnotation])
+			if err != nil {
+				continue
+			}
+			payload, err := obj.At(layer).fetchBytes(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed fetching signature payload %s (from %s): %w", layer.Digest, desc.Digest, err)
+			}
+			if !verifySignature(pub, payload, sig) {
+				continue
+			}
+			var parsed simpleSigningPayload
+			if err := json.Unmarshal(payload, &parsed); err != nil {
+				continue
+			}
+			if parsed.Critical.Type != simpleSigningType || parsed.Critical.Image.DockerManifestDigest != obj.Desc.Digest {
+				continue
+			}
+			ret = append(ret, desc)
+			break
+		}
+	}
+	return ret, nil
+}
+
+// Sign pushes a cosign-compatible signature of this object (as an OCI referrer in the same repository) unless a valid signature by the same key already exists, returning the descriptor of the signature manifest
+func (obj ResolvedObject) Sign(ctx context.Context, signer *Signer) (*ocispec.Descriptor, error) {
+	// (ECDSA signatures are not deterministic, so without this every run would push yet another signature)
+	existing, err := obj.Signatures(ctx, signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return &existing[0], nil
+	}
+
+	repoName, err := obj.repoName()
+	if err != nil {
+		return nil, err
+	}
+
+	var payload simpleSigningPayload
+	payload.Critical.Identity.DockerReference = repoName
+	payload.Critical.Image.DockerManifestDigest = obj.Desc.Digest
+	payload.Critical.Type = simpleSigningType
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.sign(payloadBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadDesc := ocispec.Descriptor{
+		MediaType: simpleSigningMediaType,
+		Digest:    digest.FromBytes(payloadBytes),
+		Size:      int64(len(payloadBytes)),
+		Annotations: map[string]string{
+			signatureAnnotation: base64.StdEncoding.EncodeToString(sig),
+		},
+	}
+	subject := ocispec.Descriptor{
+		MediaType: obj.Desc.MediaType,
+		Digest:    obj.Desc.Digest,
+		Size:      obj.Desc.Size,
+	}
+	manifestBytes, err := json.Marshal(ocispec.Manifest{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: SignatureArtifactType,
+		// https://github.com/opencontainers/image-spec/blob/v1.1.0/manifest.md#guidance-for-an-empty-descriptor
+		Config:  ocispec.DescriptorEmptyJSON,
+		Layers:  []ocispec.Descriptor{payloadDesc},
+		Subject: &subject,
+	})
+	if err != nil {
+		return nil, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		Digest:       digest.FromBytes(manifestBytes),
+		Size:         int64(len(manifestBytes)),
+		ArtifactType: SignatureArtifactType,
+	}
+
+	resolver := newPushResolver()
+	pusher, err := resolver.Pusher(ctx, repoName+"@"+desc.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+	c := copier{pusher: pusher}
+	if err := c.push(ctx, ocispec.DescriptorEmptyJSON, bytesOpener(ocispec.DescriptorEmptyJSON.Data)); err != nil {
+		return nil, err
+	}
+	if err := c.push(ctx, payloadDesc, bytesOpener(payloadBytes)); err != nil {
+		return nil, err
+	}
+	if err := c.push(ctx, desc, bytesOpener(manifestBytes)); err != nil {
+		return nil, err
+	}
+
+	// registries which support the referrers API will have indexed our signature already, but for the rest we need to maintain the "referrers tag schema" index ourselves
+	index, supported, err := obj.referrers(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if supported {
+		return &desc, nil
+	}
+	if slices.ContainsFunc(index.Manifests, func(d ocispec.Descriptor) bool { return d.Digest == desc.Digest }) {
+		return &desc, nil
+	}
+	index.Manifests = append(index.Manifests, desc)
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	tag := repoName + ":" + referrersTag(obj.Desc.Digest)
+	indexDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Digest:    digest.FromBytes(indexBytes),
+		Size:      int64(len(indexBytes)),
+	}
+	if err := pushTag(ctx, resolver, tag, indexDesc, bytesOpener(indexBytes)); err != nil {
+		return nil, fmt.Errorf("failed updating %q: %w", tag, err)
+	}
+
+	return &desc, nil
+}
@@ -0,0 +1,121 @@
+package registry_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker-library/bashbrew/registry"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// generates a fresh ECDSA P-256 key (what "cosign generate-key-pair" creates) and writes it to a PEM file, returning the loaded signer
+func newTestSigner(t *testing.T) *registry.Signer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "signing.key")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := registry.LoadSigner(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func testSign(t *testing.T, noReferrers bool) {
+	reg := newTestRegistry(t)
+	reg.noReferrers = noReferrers
+	expected := pushTestIndex(t, reg, "foo/bar", "1.0")
+	signer := newTestSigner(t)
+	ctx := context.Background()
+
+	obj, err := registry.Resolve(ctx, reg.Host+"/foo/bar:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := obj.Sign(ctx, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(reg.manifests["foo/bar"][sig.Digest.String()], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ArtifactType != registry.SignatureArtifactType {
+		t.Errorf("expected artifactType %q; got %q", registry.SignatureArtifactType, manifest.ArtifactType)
+	}
+	if manifest.Config.MediaType != ocispec.MediaTypeEmptyJSON || manifest.Config.Digest != ocispec.DescriptorEmptyJSON.Digest || manifest.Config.Size != ocispec.DescriptorEmptyJSON.Size {
+		t.Errorf("expected the empty config descriptor; got %+v", manifest.Config)
+	}
+	if _, ok := reg.blobs["foo/bar"][ocispec.DescriptorEmptyJSON.Digest]; !ok {
+		t.Errorf("expected the empty config blob to be pushed")
+	}
+
+	sigs, err := obj.Signatures(ctx, signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 1 || sigs[0].Digest != sig.Digest {
+		t.Errorf("expected exactly %s; got %+v", sig.Digest, sigs)
+	}
+
+	// signing again should be a no-op (returning the existing signature)
+	again, err := obj.Sign(ctx, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Digest != sig.Digest {
+		t.Errorf("expected existing signature %s; got %s", sig.Digest, again.Digest)
+	}
+	referrers, err := obj.Referrers(ctx, registry.SignatureArtifactType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 {
+		t.Errorf("expected 1 referrer; got %d", len(referrers))
+	}
+
+	if noReferrers {
+		if _, ok := reg.tags["foo/bar"]["sha256-"+expected.Digest.Encoded()]; !ok {
+			t.Errorf("expected referrers tag for %s; got %v", expected.Digest, reg.tags["foo/bar"])
+		}
+	}
+
+	// a different key should not verify
+	sigs, err = obj.Signatures(ctx, newTestSigner(t).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 0 {
+		t.Errorf("expected no valid signatures for a different key; got %+v", sigs)
+	}
+}
+
+func TestSign(t *testing.T) {
+	testSign(t, false)
+}
+
+func TestSignReferrersTagSchema(t *testing.T) {
+	testSign(t, true)
+}
@@ -0,0 +1,203 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference/docker"
+	dockerremote "github.com/containerd/containerd/remotes/docker"
+)
+
+// performs an HTTP request against the given host, handling (one round of) authentication challenges via the host's Authorizer
+func hostRequest(ctx context.Context, host dockerremote.RegistryHost, method, u string) (*http.Response, error) {
+	client := host.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, vals := range host.Header {
+			req.Header[key] = append(req.Header[key], vals...)
+		}
+		if host.Authorizer != nil {
+			if err := host.Authorizer.Authorize(ctx, req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && host.Authorizer != nil && attempt == 0 {
+			err := host.Authorizer.AddResponses(ctx, []*http.Response{resp})
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return resp, nil
+	}
+}
+
+// returns the "RegistryHost" objects (with the given capabilities) and the repository path for the given (possibly short) repository name
+func repositoryHosts(repo string, caps dockerremote.HostCapabilities) ([]dockerremote.RegistryHost, string, error) {
+	ref, err := docker.ParseNormalizedNamed(repo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	hosts, err := dockerRegistryHosts(docker.Domain(ref))
+	if err != nil {
+		return nil, "", err
+	}
+
+	ret := []dockerremote.RegistryHost{}
+	for _, host := range hosts {
+		if host.Capabilities.Has(caps) {
+			ret = append(ret, host)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, "", fmt.Errorf("no registry hosts for %q support the requested operation", repo)
+	}
+
+	return ret, docker.Path(ref), nil
+}
+
+func responseError(resp *http.Response, what string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := fmt.Errorf("unexpected status %q while %s: %s", resp.Status, what, strings.TrimSpace(string(body)))
+	if resp.StatusCode == http.StatusNotFound {
+		err = fmt.Errorf("%w: %v", errdefs.ErrNotFound, err)
+	}
+	return err
+}
+
+// ListTags returns every tag of the given repository (ala "docker.io/library/hello-world"), following pagination via the "Link" header as necessary (https://github.com/opencontainers/distribution-spec/blob/v1.0.1/spec.md#listing-tags)
+func ListTags(ctx context.Context, repo string) ([]string, error) {
+	hosts, repoPath, err := repositoryHosts(repo, dockerremote.HostCapabilityResolve)
+	if err != nil {
+		return nil, err
+	}
+	ctx = dockerremote.WithScope(ctx, "repository:"+repoPath+":pull")
+
+	var lastErr error
+	for _, host := range hosts {
+		tags, err := hostListTags(ctx, host, repoPath)
+		if err == nil {
+			return tags, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func hostListTags(ctx context.Context, host dockerremote.RegistryHost, repoPath string) ([]string, error) {
+	base := url.URL{
+		Scheme: host.Scheme,
+		Host:   host.Host,
+	}
+	next := &url.URL{Path: host.Path + "/" + repoPath + "/tags/list"}
+
+	tags := []string{}
+	for next != nil {
+		u := base.ResolveReference(next)
+		resp, err := hostRequest(ctx, host, http.MethodGet, u.String())
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err := responseError(resp, "listing tags of "+repoPath)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, list.Tags...)
+
+		next, err = nextLink(resp.Header.Get("Link"))
+		if err != nil {
+			return nil, err
+		}
+		if next != nil {
+			// relative links are relative to the URL we requested
+			base = *u
+		}
+	}
+
+	return tags, nil
+}
+
+// parses an RFC 5988 "Link" header value for the rel="next" URL (if any)
+func nextLink(link string) (*url.URL, error) {
+	for link := range strings.SplitSeq(link, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		target = strings.TrimSpace(target)
+		target = strings.TrimPrefix(target, "<")
+		target = strings.TrimSuffix(target, ">")
+		return url.Parse(target)
+	}
+	return nil, nil
+}
+
+// DeleteTag deletes the given tag (ala "docker.io/tianon/foo:bar") via the registry API -- only the tag reference is deleted, never the manifest it points to (https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#deleting-tags)
+func DeleteTag(ctx context.Context, image string) error {
+	ref, err := docker.ParseNormalizedNamed(image)
+	if err != nil {
+		return err
+	}
+	tagged, ok := ref.(docker.Tagged)
+	if !ok {
+		return fmt.Errorf("refusing to delete %q: not a tag reference", image)
+	}
+
+	hosts, repoPath, err := repositoryHosts(ref.Name(), dockerremote.HostCapabilityPush)
+	if err != nil {
+		return err
+	}
+	ctx = dockerremote.WithScope(ctx, "repository:"+repoPath+":delete")
+
+	host := hosts[0]
+	u := url.URL{
+		Scheme: host.Scheme,
+		Host:   host.Host,
+		Path:   host.Path + "/" + repoPath + "/manifests/" + tagged.Tag(),
+	}
+	resp, err := hostRequest(ctx, host, http.MethodDelete, u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		return nil
+	default:
+		return responseError(resp, "deleting "+image)
+	}
+}
@@ -0,0 +1,79 @@
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/docker-library/bashbrew/registry"
+)
+
+func TestListTagsPagination(t *testing.T) {
+	expected := []string{"1", "1.0", "1.0.0", "latest", "old"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v2/foo/bar/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		page := expected
+		if last := r.URL.Query().Get("last"); last != "" {
+			page = page[slices.Index(page, last)+1:]
+		}
+		if len(page) > 2 {
+			page = page[:2]
+			w.Header().Set("Link", `</v2/foo/bar/tags/list?n=2&last=`+url.QueryEscape(page[1])+`>; rel="next"`)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"name": "foo/bar",
+			"tags": page,
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := registry.ListTags(context.Background(), "localhost:"+u.Port()+"/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %q; got %q", expected, tags)
+	}
+}
+
+func TestDeleteTag(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.putManifest("foo/bar", "latest", "application/vnd.oci.image.manifest.v1+json", []byte(`{}`))
+	reg.putManifest("foo/bar", "old", "application/vnd.oci.image.manifest.v1+json", []byte(`{}`))
+	repo := reg.Host + "/foo/bar"
+	ctx := context.Background()
+
+	if err := registry.DeleteTag(ctx, repo+":old"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"foo/bar:old"}; !reflect.DeepEqual(reg.deleted, expected) {
+		t.Errorf("expected %q; got %q", expected, reg.deleted)
+	}
+
+	tags, err := registry.ListTags(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"latest"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %q; got %q", expected, tags)
+	}
+
+	if err := registry.DeleteTag(ctx, repo+":nonexistent"); err == nil {
+		t.Error("expected error deleting nonexistent tag")
+	}
+
+	if err := registry.DeleteTag(ctx, repo); err == nil {
+		t.Error("expected error deleting a reference without a tag")
+	}
+}